@@ -1,27 +1,47 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
 	"flag"
+	"io"
 	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/lukaszbudnik/auditor/auth"
+	"github.com/lukaszbudnik/auditor/checkpoint"
+	"github.com/lukaszbudnik/auditor/encryption"
+	"github.com/lukaszbudnik/auditor/export"
 	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/schema"
 	"github.com/lukaszbudnik/auditor/server"
+	"github.com/lukaszbudnik/auditor/store"
 	"github.com/lukaszbudnik/auditor/store/provider"
+	"github.com/lukaszbudnik/auditor/validation"
+	"github.com/lukaszbudnik/auditor/witness"
 )
 
 const (
 	// DefaultConfigFile defines default file name of migrator configuration file
 	DefaultConfigFile = ".env"
+	// defaultShutdownTimeout is how long in-flight requests are drained on SIGTERM or SIGINT
+	defaultShutdownTimeout = 30 * time.Second
 )
 
 func main() {
-	// fail fast, ValidateBlockType method panics if type of passed struct is invalid
-	// this simplifies operations in the rest of the auditor code
-	model.ValidateBlockType(&model.Block{})
-
 	var configFile string
+	var verifyHead string
+	var verifyExport string
 	flag.StringVar(&configFile, "configFile", "", "optional argument with a name of configuration file to use")
+	flag.StringVar(&verifyHead, "verifyHead", "", "optional argument with a name of file with published chain heads, when set auditor verifies the chain against the latest head and exits")
+	flag.StringVar(&verifyExport, "verifyExport", "", "optional argument with a name of NDJSON or JSON Lines export file (gzipped when it ends with .gz), when set auditor verifies the export and exits")
 	flag.Parse()
 	if len(configFile) == 0 {
 		configFile = DefaultConfigFile
@@ -30,12 +50,305 @@ func main() {
 		log.Fatalf("FATAL Could not load configuration file: %v", err.Error())
 	}
 	log.Printf("INFO auditor read configuration from file: %v", configFile)
+
+	blockType := reflect.TypeOf(model.Block{})
+	switch os.Getenv("AUDITOR_MODEL") {
+	case "", "block":
+	case "event":
+		blockType = reflect.TypeOf(model.Event{})
+	default:
+		log.Fatalf("FATAL Unknown AUDITOR_MODEL: %v", os.Getenv("AUDITOR_MODEL"))
+	}
+	if schema.Enabled() {
+		var err error
+		if blockType, err = schema.Load(os.Getenv("AUDITOR_SCHEMA")); err != nil {
+			fatalWithDiagnostic("Could not load block schema", err)
+		}
+		log.Printf("INFO auditor read block schema from file: %v", os.Getenv("AUDITOR_SCHEMA"))
+	}
+	// fail fast, the rest of the auditor code assumes that block type is valid
+	if err := model.ValidateBlockType(reflect.New(blockType).Interface()); err != nil {
+		fatalWithDiagnostic("Invalid block type", err)
+	}
+
+	if len(verifyExport) > 0 {
+		verifyExportFile(blockType, verifyExport)
+		return
+	}
+
 	store, err := provider.NewStore()
 	if err != nil {
 		log.Fatalf("FATAL Could not connect to backend store: %v", err.Error())
 	}
-	_, err = server.Start(store)
+	if len(verifyHead) > 0 {
+		verify(store, blockType, verifyHead)
+		return
+	}
+	var checkpointer *checkpoint.Checkpointer
+	if checkpoint.Enabled() {
+		checkpointer, err = checkpoint.NewFromEnv(store, reflect.New(blockType).Interface())
+		if err != nil {
+			log.Fatalf("FATAL Could not create checkpointer: %v", err.Error())
+		}
+		checkpointer.Start()
+	}
+	var publisher *witness.Publisher
+	if witness.Enabled() {
+		publisher, err = witness.NewPublisherFromEnv(store, reflect.New(blockType).Interface())
+		if err != nil {
+			log.Fatalf("FATAL Could not create witness publisher: %v", err.Error())
+		}
+		publisher.Start()
+	}
+	// server reads and writes blocks in plain text, blocks are encrypted before they reach backend store
+	config := &server.Config{BlockType: blockType, Checkpointer: checkpointer}
+	var keyring *encryption.Keyring
+	if encryption.Enabled() || encryption.SubjectsEnabled() {
+		keyring = &encryption.Keyring{Keys: map[string][]byte{}}
+		if encryption.Enabled() {
+			if keyring, err = encryption.LoadKeyring(os.Getenv("AUDITOR_KEYRING")); err != nil {
+				log.Fatalf("FATAL Could not load keyring: %v", err.Error())
+			}
+		}
+		if encryption.SubjectsEnabled() {
+			keyring.Subjects = loadSubjectKeys()
+			config.Subjects = keyring.Subjects
+		}
+	}
+	apiStore := store
+	if keyring != nil {
+		apiStore = encryption.NewStore(store, keyring)
+	}
+	if schema.TypesEnabled() {
+		types, err := schema.LoadTypes(os.Getenv("AUDITOR_BLOCK_TYPES"))
+		if err != nil {
+			fatalWithDiagnostic("Could not load block types", err)
+		}
+		config.Chains = make(map[string]*server.Chain)
+		for name, chainType := range types {
+			chainStore, err := provider.NewStoreWithName(name)
+			if err != nil {
+				log.Fatalf("FATAL Could not connect to backend store for block type %v: %v", name, err.Error())
+			}
+			if keyring != nil {
+				chainStore = encryption.NewStore(chainStore, keyring)
+			}
+			config.Chains[name] = &server.Chain{Store: chainStore, BlockType: chainType}
+			log.Printf("INFO auditor serving block type %v at /audit/%v", name, name)
+		}
+	}
+	if config.Listener, err = server.ListenerFromEnv(); err != nil {
+		log.Fatalf("FATAL Could not configure listener: %v", err.Error())
+	}
+	if config.Timestamps, err = server.TimestampsFromEnv(); err != nil {
+		log.Fatalf("FATAL Could not configure timestamps: %v", err.Error())
+	}
+	if err := config.Timestamps.Check(blockType); err != nil {
+		log.Fatalf("FATAL Could not configure timestamps: %v", err.Error())
+	}
+	for _, chain := range config.Chains {
+		if err := config.Timestamps.Check(chain.BlockType); err != nil {
+			log.Fatalf("FATAL Could not configure timestamps: %v", err.Error())
+		}
+	}
+	if validation.Enabled() {
+		loadRules(config)
+	}
+	if auth.Enabled() {
+		if config.Authenticator, err = auth.NewFromEnv(); err != nil {
+			log.Fatalf("FATAL Could not configure authentication: %v", err.Error())
+		}
+	}
+	if auth.PolicyEnabled() {
+		loadPolicy(config)
+	}
+	if server.AccessLogEnabled() {
+		if _, ok := config.Chains[server.AccessName]; ok {
+			log.Fatalf("FATAL Block type %v is reserved for access chain when AUDITOR_ACCESS_LOG is enabled", server.AccessName)
+		}
+		if err := model.ValidateBlockType(&model.Access{}); err != nil {
+			fatalWithDiagnostic("Invalid access block type", err)
+		}
+		if config.Access, err = provider.NewStoreWithName(server.AccessName); err != nil {
+			log.Fatalf("FATAL Could not connect to backend store for access chain: %v", err.Error())
+		}
+		log.Printf("INFO auditor recording reads in access chain")
+	}
+	if config.ExportKey, err = export.KeyFromEnv(); err != nil {
+		log.Fatalf("FATAL Could not load AUDITOR_EXPORT_KEY: %v", err.Error())
+	}
+	if config.FingerprintSecret, err = server.FingerprintSecretFromEnv(); err != nil {
+		log.Fatalf("FATAL Could not load AUDITOR_IDEMPOTENCY_SECRET: %v", err.Error())
+	}
+	shutdownTimeout := defaultShutdownTimeout
+	if s := os.Getenv("AUDITOR_SHUTDOWN_TIMEOUT"); len(s) > 0 {
+		if shutdownTimeout, err = time.ParseDuration(s); err != nil || shutdownTimeout <= 0 {
+			log.Fatalf("FATAL Invalid AUDITOR_SHUTDOWN_TIMEOUT: %v", s)
+		}
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	srv, err := server.Start(apiStore, config)
 	if err != nil {
 		log.Fatalf("FATAL Could not start server: %v", err.Error())
 	}
+	select {
+	case err := <-srv.Done():
+		log.Fatalf("FATAL Server stopped: %v", err)
+	case sig := <-signals:
+		log.Printf("INFO auditor received %v, draining in-flight requests for up to %v", sig, shutdownTimeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("ERROR Could not drain in-flight requests: %v", err.Error())
+	}
+	// background writers are stopped before stores are closed
+	if checkpointer != nil {
+		checkpointer.Stop()
+	}
+	if publisher != nil {
+		publisher.Stop()
+	}
+	for _, chain := range config.Chains {
+		chain.Store.Close()
+	}
+	if config.Access != nil {
+		config.Access.Close()
+	}
+	apiStore.Close()
+	log.Printf("INFO auditor stopped")
+}
+
+// fatalWithDiagnostic logs error and exits, every violation found in invalid block type is logged on a separate line
+func fatalWithDiagnostic(message string, err error) {
+	validationError, ok := err.(*model.ValidationError)
+	if !ok {
+		log.Fatalf("FATAL %v: %v", message, err.Error())
+	}
+	log.Printf("FATAL %v: block type %v is invalid, found %v problem(s):", message, validationError.Type, len(validationError.Errors))
+	for _, e := range validationError.Errors {
+		log.Printf("FATAL   - %v", e.Error())
+	}
+	os.Exit(1)
+}
+
+// loadSubjectKeys creates subject keys kept in backend store, when AUDITOR_SUBJECT_KEYS is not set to store
+// it is a path of JSON file in which keys were kept before and keys from it are imported first
+func loadSubjectKeys() *encryption.SubjectKeys {
+	subjectStore, err := provider.NewStoreWithName(encryption.SubjectsName)
+	if err != nil {
+		log.Fatalf("FATAL Could not connect to backend store for subject keys: %v", err.Error())
+	}
+	keys, ok := subjectStore.(store.KeyStore)
+	if !ok {
+		log.Fatalf("FATAL Backend store cannot keep subject keys")
+	}
+	secret, err := encryption.SubjectSecretFromEnv()
+	if err != nil {
+		log.Fatalf("FATAL Could not load AUDITOR_SUBJECT_SECRET: %v", err.Error())
+	}
+	subjects := encryption.NewSubjectKeys(keys, secret)
+	if path := os.Getenv("AUDITOR_SUBJECT_KEYS"); path != "store" {
+		count, err := subjects.Import(path)
+		if err != nil {
+			log.Fatalf("FATAL Could not import subject keys: %v", err.Error())
+		}
+		log.Printf("INFO auditor imported %v subject keys from file: %v, set AUDITOR_SUBJECT_KEYS=store once all instances were upgraded", count, path)
+	}
+	return subjects
+}
+
+// loadPolicy loads authorization policy, checks that it refers only to known chains and that authentication is configured
+func loadPolicy(config *server.Config) {
+	if config.Authenticator == nil {
+		log.Fatalf("FATAL AUDITOR_POLICY requires authentication, set AUDITOR_API_KEYS or AUDITOR_JWKS")
+	}
+	policy, err := auth.PolicyFromEnv()
+	if err != nil {
+		log.Fatalf("FATAL Could not load policy: %v", err.Error())
+	}
+	chains := []string{auth.DefaultChain}
+	for name := range config.Chains {
+		chains = append(chains, name)
+	}
+	if err := policy.Check(chains); err != nil {
+		log.Fatalf("FATAL Invalid policy: %v", err.Error())
+	}
+	config.Policy = policy
+	log.Printf("INFO auditor read authorization policy from file: %v", os.Getenv("AUDITOR_POLICY"))
+}
+
+// loadRules assigns validation rules to the default chain (audit) and to chains of additional block types
+func loadRules(config *server.Config) {
+	rules, err := validation.Load(os.Getenv("AUDITOR_VALIDATION"))
+	if err != nil {
+		log.Fatalf("FATAL Could not load validation rules: %v", err.Error())
+	}
+	for name, r := range rules {
+		blockType := config.BlockType
+		if name == "audit" {
+			config.Rules = r
+		} else if chain, ok := config.Chains[name]; ok {
+			blockType = chain.BlockType
+			chain.Rules = r
+		} else {
+			log.Fatalf("FATAL Could not load validation rules: unknown block type %v", name)
+		}
+		if err := r.Check(blockType); err != nil {
+			log.Fatalf("FATAL Invalid validation rules of %v: %v", name, err.Error())
+		}
+	}
+	log.Printf("INFO auditor read validation rules from file: %v", os.Getenv("AUDITOR_VALIDATION"))
+}
+
+func verify(store store.Store, blockType reflect.Type, verifyHead string) {
+	defer store.Close()
+	key, err := witness.LoadPublicKey(os.Getenv("AUDITOR_WITNESS_PUBLIC_KEY"))
+	if err != nil {
+		log.Fatalf("FATAL Could not load AUDITOR_WITNESS_PUBLIC_KEY: %v", err.Error())
+	}
+	statement, err := witness.ReadLatest(verifyHead)
+	if err != nil {
+		log.Fatalf("FATAL Could not read published head: %v", err.Error())
+	}
+	block := reflect.New(blockType).Interface()
+	// DynamoDB follows the chain by hash from its head, partition is only used for tables saved before head item was introduced
+	fields := model.GetFieldsTaggedWith(block, "dynamodb_partition")
+	if len(fields) > 0 {
+		model.SetFieldValue(block, fields[0], os.Getenv("AUDITOR_WITNESS_PARTITION"))
+	}
+	if err := witness.Verify(key, statement, store, block); err != nil {
+		log.Fatalf("FATAL Chain verification failed: %v", err.Error())
+	}
+	log.Printf("INFO Chain contains head %v published at %v at height %v", statement.Hash, statement.Timestamp, statement.Height)
+}
+
+func verifyExportFile(blockType reflect.Type, path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("FATAL Could not open export: %v", err.Error())
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		if reader, err = gzip.NewReader(file); err != nil {
+			log.Fatalf("FATAL Could not open export: %v", err.Error())
+		}
+	}
+	var key ed25519.PublicKey
+	if keyPath := os.Getenv("AUDITOR_EXPORT_PUBLIC_KEY"); len(keyPath) > 0 {
+		if key, err = witness.LoadPublicKey(keyPath); err != nil {
+			log.Fatalf("FATAL Could not load AUDITOR_EXPORT_PUBLIC_KEY: %v", err.Error())
+		}
+	}
+	trailer, err := export.Verify(reader, blockType, key)
+	if err != nil {
+		log.Fatalf("FATAL Export verification failed: %v", err.Error())
+	}
+	if !export.RecomputesHashes(blockType) {
+		log.Printf("WARN Block type has encrypted fields, hashes of exported blocks were not checked against their contents, only their linkage was verified")
+	}
+	log.Printf("INFO Export contains %v blocks with head %v exported at %v (signature verified: %v)", trailer.Count, trailer.Head, trailer.Timestamp, key != nil)
 }
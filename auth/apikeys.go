@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// APIKeys authenticates requests carrying API key in X-API-Key header or in Authorization header with ApiKey scheme,
+// only SHA-256 hashes of keys are kept
+type APIKeys struct {
+	// names maps hex encoded SHA-256 hashes of keys to names of their owners
+	names map[string]string
+}
+
+// HashAPIKey returns hex encoded SHA-256 hash of API key, the same as: echo -n key | sha256sum
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// LoadAPIKeys loads JSON file which maps names of key owners (they become principal IDs) to hashes of their keys
+func LoadAPIKeys(path string) (*APIKeys, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string)
+	if err := json.Unmarshal(data, &hashes); err != nil {
+		return nil, err
+	}
+	return NewAPIKeys(hashes)
+}
+
+// NewAPIKeys creates APIKeys from map of owner names to hex encoded SHA-256 hashes of their keys
+func NewAPIKeys(hashes map[string]string) (*APIKeys, error) {
+	keys := &APIKeys{names: make(map[string]string)}
+	for name, hash := range hashes {
+		hash = strings.ToLower(hash)
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("API key of %v must be hex encoded SHA-256 hash", name)
+		}
+		if other, ok := keys.names[hash]; ok {
+			return nil, fmt.Errorf("API keys of %v and %v are the same", other, name)
+		}
+		keys.names[hash] = name
+	}
+	return keys, nil
+}
+
+// Authenticate implements Authenticator
+func (k *APIKeys) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if len(key) == 0 {
+		var ok bool
+		if key, ok = credentials(r, "ApiKey"); !ok {
+			return nil, ErrNoCredentials
+		}
+	}
+	// keys are high entropy secrets, lookup by hash does not reveal them
+	name, ok := k.names[HashAPIKey(key)]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{ID: name, Method: "apikey"}, nil
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashAPIKey(t *testing.T) {
+	// echo -n secret | sha256sum
+	assert.Equal(t, "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", HashAPIKey("secret"))
+}
+
+func TestAPIKeysAuthenticate(t *testing.T) {
+	keys, err := NewAPIKeys(map[string]string{"billing-service": HashAPIKey("secret")})
+	assert.Nil(t, err)
+
+	r := httptest.NewRequest("GET", "/audit", nil)
+	_, err = keys.Authenticate(r)
+	assert.Equal(t, ErrNoCredentials, err)
+
+	r.Header.Set("X-API-Key", "secret")
+	principal, err := keys.Authenticate(r)
+	assert.Nil(t, err)
+	assert.Equal(t, &Principal{ID: "billing-service", Method: "apikey"}, principal)
+
+	r = httptest.NewRequest("GET", "/audit", nil)
+	r.Header.Set("Authorization", "ApiKey secret")
+	principal, err = keys.Authenticate(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "billing-service", principal.ID)
+
+	r.Header.Set("Authorization", "ApiKey wrong")
+	_, err = keys.Authenticate(r)
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	// other schemes are left to other authenticators
+	r.Header.Set("Authorization", "Bearer token")
+	_, err = keys.Authenticate(r)
+	assert.Equal(t, ErrNoCredentials, err)
+}
+
+func TestNewAPIKeysErrors(t *testing.T) {
+	_, err := NewAPIKeys(map[string]string{"billing-service": "secret"})
+	assert.Equal(t, "API key of billing-service must be hex encoded SHA-256 hash", err.Error())
+	_, err = NewAPIKeys(map[string]string{"a": HashAPIKey("secret"), "b": HashAPIKey("secret")})
+	assert.Contains(t, err.Error(), "are the same")
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditor")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+	ioutil.WriteFile(path, []byte(`{"billing-service": "`+HashAPIKey("secret")+`"}`), 0600)
+
+	keys, err := LoadAPIKeys(path)
+	assert.Nil(t, err)
+	r := httptest.NewRequest("GET", "/audit", nil)
+	r.Header.Set("X-API-Key", "secret")
+	principal, err := keys.Authenticate(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "billing-service", principal.ID)
+
+	_, err = LoadAPIKeys(filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+}
+
+func TestAnyAndNewFromEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditor")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+	ioutil.WriteFile(path, []byte(`{"billing-service": "`+HashAPIKey("secret")+`"}`), 0600)
+	defer os.Setenv("AUDITOR_API_KEYS", "")
+	defer os.Setenv("AUDITOR_JWKS", "")
+
+	assert.False(t, Enabled())
+	os.Setenv("AUDITOR_API_KEYS", path)
+	assert.True(t, Enabled())
+	authenticator, err := NewFromEnv()
+	assert.Nil(t, err)
+
+	r := httptest.NewRequest("GET", "/audit", nil)
+	_, err = authenticator.Authenticate(r)
+	assert.Equal(t, ErrNoCredentials, err)
+	r.Header.Set("X-API-Key", "secret")
+	principal, err := authenticator.Authenticate(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "billing-service", principal.ID)
+
+	jwks := writeJWKS(t, dir, newTestKeys(t))
+	os.Setenv("AUDITOR_JWKS", jwks)
+	_, err = NewFromEnv()
+	assert.Equal(t, "AUDITOR_JWT_ISSUER and AUDITOR_JWT_AUDIENCE must be set when AUDITOR_JWKS is set", err.Error())
+}
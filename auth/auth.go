@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrNoCredentials is returned by authenticators when request carries no credentials they understand
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned by authenticators when credentials are not valid
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is an authenticated caller
+type Principal struct {
+	// ID is API key name or JWT subject
+	ID string
+	// Method is apikey or jwt
+	Method string
+	// Claims holds JWT claims, nil for API keys
+	Claims map[string]interface{}
+}
+
+// PrincipalKey is a context key under which authenticated Principal is stored
+type PrincipalKey struct{}
+
+// FromContext returns principal stored in context, nil when request was not authenticated
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(PrincipalKey{}).(*Principal)
+	return principal
+}
+
+// NewContext returns context with principal
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, PrincipalKey{}, principal)
+}
+
+// Authenticator authenticates requests
+type Authenticator interface {
+	// Authenticate returns principal, ErrNoCredentials when request carries no credentials authenticator understands,
+	// or error when credentials are invalid
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Any authenticates requests using the first authenticator which understands credentials carried by request
+type Any []Authenticator
+
+// Authenticate implements Authenticator
+func (a Any) Authenticate(r *http.Request) (*Principal, error) {
+	for _, authenticator := range a {
+		principal, err := authenticator.Authenticate(r)
+		if err != ErrNoCredentials {
+			return principal, err
+		}
+	}
+	return nil, ErrNoCredentials
+}
+
+// Enabled returns true when AUDITOR_API_KEYS or AUDITOR_JWKS is set
+func Enabled() bool {
+	return len(os.Getenv("AUDITOR_API_KEYS")) > 0 || len(os.Getenv("AUDITOR_JWKS")) > 0
+}
+
+// NewFromEnv creates authenticator accepting API keys from AUDITOR_API_KEYS file and JWT bearer tokens
+// signed with keys from AUDITOR_JWKS (file or URL), issued by AUDITOR_JWT_ISSUER for AUDITOR_JWT_AUDIENCE
+func NewFromEnv() (Authenticator, error) {
+	authenticators := Any{}
+	if path := os.Getenv("AUDITOR_API_KEYS"); len(path) > 0 {
+		keys, err := LoadAPIKeys(path)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, keys)
+	}
+	if location := os.Getenv("AUDITOR_JWKS"); len(location) > 0 {
+		jwks, err := LoadJWKS(location)
+		if err != nil {
+			return nil, err
+		}
+		issuer, audience := os.Getenv("AUDITOR_JWT_ISSUER"), os.Getenv("AUDITOR_JWT_AUDIENCE")
+		if len(issuer) == 0 || len(audience) == 0 {
+			return nil, errors.New("AUDITOR_JWT_ISSUER and AUDITOR_JWT_AUDIENCE must be set when AUDITOR_JWKS is set")
+		}
+		authenticators = append(authenticators, &JWTAuthenticator{Keys: jwks, Issuer: issuer, Audience: audience})
+	}
+	return authenticators, nil
+}
+
+// credentials returns credentials sent in Authorization header using scheme
+func credentials(r *http.Request, scheme string) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) > len(scheme) && strings.EqualFold(header[:len(scheme)], scheme) && header[len(scheme)] == ' ' {
+		return strings.TrimSpace(header[len(scheme)+1:]), true
+	}
+	return "", false
+}
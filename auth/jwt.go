@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// refreshInterval limits how often JWKS loaded from URL is refreshed when token is signed with unknown key
+	refreshInterval = time.Minute
+	// leeway is allowed clock difference when checking exp and nbf claims
+	leeway     = time.Minute
+	now        = time.Now
+	httpClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// JWKS holds public keys of JSON Web Key Set loaded from file or http(s) URL, RSA, EC P-256, and Ed25519 keys are supported,
+// keys loaded from URL are refreshed when token is signed with unknown key
+type JWKS struct {
+	location  string
+	lock      sync.Mutex
+	keys      map[string]crypto.PublicKey
+	refreshed time.Time
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJWKS loads JSON Web Key Set from file or http(s) URL
+func LoadJWKS(location string) (*JWKS, error) {
+	jwks := &JWKS{location: location}
+	if err := jwks.load(); err != nil {
+		return nil, err
+	}
+	return jwks, nil
+}
+
+func (j *JWKS) remote() bool {
+	return strings.HasPrefix(j.location, "http://") || strings.HasPrefix(j.location, "https://")
+}
+
+func (j *JWKS) load() error {
+	var data []byte
+	var err error
+	if j.remote() {
+		data, err = fetch(j.location)
+	} else {
+		data, err = ioutil.ReadFile(j.location)
+	}
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("invalid JWKS %v: %v", j.location, err.Error())
+	}
+	j.keys, j.refreshed = keys, now()
+	return nil
+}
+
+func fetch(url string) ([]byte, error) {
+	response, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch JWKS %v: %v", url, response.Status)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	set := struct{ Keys []jwk }{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %v: %v", k.Kid, err.Error())
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no keys found")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := func(values ...string) ([][]byte, error) {
+		decoded := make([][]byte, len(values))
+		for i, value := range values {
+			var err error
+			if decoded[i], err = base64.RawURLEncoding.DecodeString(value); err != nil || len(decoded[i]) == 0 {
+				return nil, errors.New("invalid key parameters")
+			}
+		}
+		return decoded, nil
+	}
+	switch {
+	case k.Kty == "RSA":
+		params, err := decode(k.N, k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(params[0]), E: int(new(big.Int).SetBytes(params[1]).Int64())}, nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		params, err := decode(k.X, k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(params[0]), Y: new(big.Int).SetBytes(params[1])}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on curve")
+		}
+		return key, nil
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		params, err := decode(k.X)
+		if err != nil || len(params[0]) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid key parameters")
+		}
+		return ed25519.PublicKey(params[0]), nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %v %v", k.Kty, k.Crv)
+}
+
+// key returns key with kid, token without kid can be used when there is only one key,
+// JWKS loaded from URL is refreshed when key is not found
+func (j *JWKS) key(kid string) (crypto.PublicKey, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+	if j.remote() && now().Sub(j.refreshed) > refreshInterval {
+		if err := j.load(); err != nil {
+			return nil, err
+		}
+		if key, ok := j.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key: %v", kid)
+}
+
+func (j *JWKS) lookup(kid string) (crypto.PublicKey, bool) {
+	if key, ok := j.keys[kid]; ok {
+		return key, true
+	}
+	if len(kid) == 0 && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// JWTAuthenticator authenticates requests carrying JWT in Authorization header with Bearer scheme,
+// tokens must be signed (RS256, ES256, or EdDSA) with one of Keys, issued by Issuer for Audience, and have exp and sub claims
+type JWTAuthenticator struct {
+	Keys     *JWKS
+	Issuer   string
+	Audience string
+}
+
+// Authenticate implements Authenticator
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := credentials(r, "Bearer")
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	claims, err := a.verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidCredentials.Error(), err.Error())
+	}
+	return &Principal{ID: claims["sub"].(string), Method: "jwt", Claims: claims}, nil
+}
+
+func (a *JWTAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	header := struct{ Alg, Kid string }{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	key, err := a.Keys.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{})
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("exp claim is required")
+	}
+	if now().After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now().Before(time.Unix(int64(nbf), 0).Add(-leeway)) {
+		return nil, errors.New("token not valid yet")
+	}
+	if claims["iss"] != a.Issuer {
+		return nil, fmt.Errorf("unexpected issuer: %v", claims["iss"])
+	}
+	if !hasAudience(claims["aud"], a.Audience) {
+		return nil, fmt.Errorf("unexpected audience: %v", claims["aud"])
+	}
+	if sub, ok := claims["sub"].(string); !ok || len(sub) == 0 {
+		return nil, errors.New("sub claim is required")
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
+
+// verifySignature verifies signature with key of the type required by alg, none and HMAC algorithms are rejected
+func verifySignature(alg string, key crypto.PublicKey, input, signature []byte) error {
+	digest := sha256.Sum256(input)
+	valid := false
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		valid = alg == "RS256" && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		valid = alg == "ES256" && len(signature) == 64 &&
+			ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:]))
+	case ed25519.PublicKey:
+		valid = alg == "EdDSA" && ed25519.Verify(k, input, signature)
+	}
+	if !valid {
+		return fmt.Errorf("invalid %v signature", alg)
+	}
+	return nil
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch value := aud.(type) {
+	case string:
+		return value == audience
+	case []interface{}:
+		for _, a := range value {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
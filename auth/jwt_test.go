@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testKeys struct {
+	rsa     *rsa.PrivateKey
+	ec      *ecdsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	return &testKeys{rsa: rsaKey, ec: ecKey, ed25519: edKey}
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func fixed(i *big.Int) []byte {
+	b := make([]byte, 32)
+	return i.FillBytes(b)
+}
+
+func (k *testKeys) jwks() []byte {
+	set := map[string][]map[string]string{"keys": {
+		{"kty": "RSA", "kid": "rsa", "n": encode(k.rsa.N.Bytes()), "e": encode(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(fixed(k.ec.X)), "y": encode(fixed(k.ec.Y))},
+		{"kty": "OKP", "kid": "ed25519", "crv": "Ed25519", "x": encode(k.ed25519.Public().(ed25519.PublicKey))},
+	}}
+	data, _ := json.Marshal(set)
+	return data
+}
+
+func writeJWKS(t *testing.T, dir string, keys *testKeys) string {
+	path := filepath.Join(dir, "jwks.json")
+	assert.Nil(t, ioutil.WriteFile(path, keys.jwks(), 0600))
+	return path
+}
+
+// sign creates token signed with key selected by kid
+func (k *testKeys) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := encode(header) + "." + encode(payload)
+	digest := sha256.Sum256([]byte(input))
+	var signature []byte
+	var err error
+	switch kid {
+	case "rsa":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+	case "ec":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		signature = append(fixed(r), fixed(s)...)
+	default:
+		signature = ed25519.Sign(k.ed25519, []byte(input))
+	}
+	assert.Nil(t, err)
+	return input + "." + encode(signature)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss": "https://issuer.example.com",
+		"aud": []string{"other", "auditor"},
+		"sub": "billing-service",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+}
+
+func bearer(token string) *http.Request {
+	r := httptest.NewRequest("GET", "/audit", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func newTestAuthenticator(t *testing.T) (*JWTAuthenticator, *testKeys, func()) {
+	dir, err := ioutil.TempDir("", "auditor")
+	assert.Nil(t, err)
+	keys := newTestKeys(t)
+	jwks, err := LoadJWKS(writeJWKS(t, dir, keys))
+	assert.Nil(t, err)
+	authenticator := &JWTAuthenticator{Keys: jwks, Issuer: "https://issuer.example.com", Audience: "auditor"}
+	return authenticator, keys, func() { os.RemoveAll(dir) }
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	authenticator, keys, cleanup := newTestAuthenticator(t)
+	defer cleanup()
+
+	_, err := authenticator.Authenticate(httptest.NewRequest("GET", "/audit", nil))
+	assert.Equal(t, ErrNoCredentials, err)
+
+	for alg, kid := range map[string]string{"RS256": "rsa", "ES256": "ec", "EdDSA": "ed25519"} {
+		principal, err := authenticator.Authenticate(bearer(keys.sign(t, alg, kid, validClaims())))
+		if !assert.Nil(t, err, alg) {
+			continue
+		}
+		assert.Equal(t, "billing-service", principal.ID)
+		assert.Equal(t, "jwt", principal.Method)
+		assert.Equal(t, "https://issuer.example.com", principal.Claims["iss"])
+	}
+
+	// audience can be a string too
+	claims := validClaims()
+	claims["aud"] = "auditor"
+	_, err = authenticator.Authenticate(bearer(keys.sign(t, "ES256", "ec", claims)))
+	assert.Nil(t, err)
+}
+
+func TestJWTAuthenticateErrors(t *testing.T) {
+	authenticator, keys, cleanup := newTestAuthenticator(t)
+	defer cleanup()
+
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	valid := strings.Split(keys.sign(t, "RS256", "rsa", validClaims()), ".")
+	forged := keys.sign(t, "RS256", "rsa", with("sub", "admin"))
+
+	for expected, token := range map[string]string{
+		"token expired":                   keys.sign(t, "RS256", "rsa", with("exp", time.Now().Add(-time.Hour).Unix())),
+		"exp claim is required":           keys.sign(t, "RS256", "rsa", with("exp", nil)),
+		"token not valid yet":             keys.sign(t, "RS256", "rsa", with("nbf", time.Now().Add(time.Hour).Unix())),
+		"unexpected issuer: https://evil": keys.sign(t, "RS256", "rsa", with("iss", "https://evil")),
+		"unexpected audience: other":      keys.sign(t, "RS256", "rsa", with("aud", "other")),
+		"sub claim is required":           keys.sign(t, "RS256", "rsa", with("sub", "")),
+		"unknown key: other":              keys.sign(t, "EdDSA", "other", validClaims()),
+		// payload of one token with signature of another
+		"invalid RS256 signature": valid[0] + "." + strings.Split(forged, ".")[1] + "." + valid[2],
+		"invalid none signature":  encode([]byte(`{"alg":"none","kid":"rsa"}`)) + "." + valid[1] + ".",
+		// algorithm must match type of key
+		"invalid ES256 signature": keys.sign(t, "ES256", "rsa", validClaims()),
+		"invalid EdDSA signature": keys.sign(t, "EdDSA", "ec", validClaims()),
+		"malformed token":         "not-a-token",
+	} {
+		_, err := authenticator.Authenticate(bearer(token))
+		if assert.NotNil(t, err, expected) {
+			assert.Equal(t, "invalid credentials: "+expected, err.Error())
+		}
+	}
+}
+
+func TestJWKSRefresh(t *testing.T) {
+	keys, rotated := newTestKeys(t), newTestKeys(t)
+	body := atomic.Value{}
+	body.Store(keys.jwks())
+	fetches := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Write(body.Load().([]byte))
+	}))
+	defer server.Close()
+
+	jwks, err := LoadJWKS(server.URL)
+	assert.Nil(t, err)
+	authenticator := &JWTAuthenticator{Keys: jwks, Issuer: "https://issuer.example.com", Audience: "auditor"}
+	_, err = authenticator.Authenticate(bearer(keys.sign(t, "ES256", "ec", validClaims())))
+	assert.Nil(t, err)
+
+	// key rotated by issuer
+	body.Store([]byte(`{"keys": [{"kty": "OKP", "kid": "rotated", "crv": "Ed25519", "x": "` + encode(rotated.ed25519.Public().(ed25519.PublicKey)) + `"}]}`))
+	token := rotated.sign(t, "EdDSA", "rotated", validClaims())
+
+	// refreshed at most once per refreshInterval
+	_, err = authenticator.Authenticate(bearer(token))
+	assert.Equal(t, "invalid credentials: unknown key: rotated", err.Error())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	defer func(interval time.Duration) { refreshInterval = interval }(refreshInterval)
+	refreshInterval = 0
+	principal, err := authenticator.Authenticate(bearer(token))
+	assert.Nil(t, err)
+	assert.Equal(t, "billing-service", principal.ID)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+}
+
+func TestLoadJWKSErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditor")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+
+	for expected, content := range map[string]string{
+		"no keys found":                         `{"keys": []}`,
+		"key k: unsupported key type: EC P-384": `{"keys": [{"kty": "EC", "kid": "k", "crv": "P-384", "x": "AA", "y": "AA"}]}`,
+		"key k: invalid key parameters":         `{"keys": [{"kty": "RSA", "kid": "k", "n": "", "e": "AQAB"}]}`,
+		"key k: point is not on curve":          `{"keys": [{"kty": "EC", "kid": "k", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`,
+	} {
+		ioutil.WriteFile(path, []byte(content), 0600)
+		_, err := LoadJWKS(path)
+		if assert.NotNil(t, err, expected) {
+			assert.Equal(t, "invalid JWKS "+path+": "+expected, err.Error())
+		}
+	}
+	_, err = LoadJWKS(filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+}
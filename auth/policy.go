@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Action is an operation principals perform on a chain
+type Action string
+
+const (
+	// Read allows reading blocks
+	Read Action = "read"
+	// Write allows appending blocks
+	Write Action = "write"
+	// Admin allows redacting blocks and erasing subjects
+	Admin Action = "admin"
+)
+
+// DefaultChain is the name of the default chain served at /audit
+const DefaultChain = "audit"
+
+// Permission grants actions to principals, empty Chains, Partitions, and Categories match all of them
+type Permission struct {
+	// Principals lists principal IDs, * matches every authenticated principal
+	Principals []string `json:"principals"`
+	Actions    []Action `json:"actions"`
+	// Chains lists names of chains, audit is the default chain
+	Chains []string `json:"chains"`
+	// Partitions lists values of dynamodb_partition field
+	Partitions []string `json:"partitions"`
+	// Categories lists values of Category field
+	Categories []string `json:"categories"`
+}
+
+// Policy lists permissions, requests not allowed by any of them are denied
+type Policy struct {
+	Permissions []Permission `json:"permissions"`
+}
+
+// Request describes action principal wants to perform
+type Request struct {
+	Principal *Principal
+	Action    Action
+	Chain     string
+	Partition string
+	Category  string
+	// AnyCategory is set for requests which are not about a single category, like reads, such requests are allowed
+	// when principal can perform action on at least one category, blocks must then be checked one by one
+	AnyCategory bool
+	// Unrestricted is set for requests which affect all chains, partitions, and categories, like erasure of subjects,
+	// such requests are allowed only by permissions which do not restrict Chains, Partitions, and Categories
+	Unrestricted bool
+}
+
+// Decision is a result of authorization
+type Decision struct {
+	Request
+	Allowed bool
+	// Permission is index of permission which allowed request, -1 when request was denied
+	Permission int
+}
+
+func (d Decision) String() string {
+	result, principal, category := "denied", "anonymous", d.Category
+	if d.Allowed {
+		result = fmt.Sprintf("allowed by permission %v", d.Permission)
+	}
+	if d.Principal != nil {
+		principal = d.Principal.ID
+	}
+	chain, partition := d.Chain, d.Partition
+	if d.AnyCategory {
+		category = "*"
+	}
+	if d.Unrestricted {
+		chain, partition, category = "*", "*", "*"
+	}
+	return fmt.Sprintf("%v %v chain: %v partition: %v category: %v %v", principal, d.Action, chain, partition, category, result)
+}
+
+// PolicyEnabled returns true when AUDITOR_POLICY is set
+func PolicyEnabled() bool {
+	return len(os.Getenv("AUDITOR_POLICY")) > 0
+}
+
+// PolicyFromEnv loads policy from file set in AUDITOR_POLICY
+func PolicyFromEnv() (*Policy, error) {
+	return LoadPolicy(os.Getenv("AUDITOR_POLICY"))
+}
+
+// LoadPolicy loads policy from JSON file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	policy := &Policy{}
+	if err := decoder.Decode(policy); err != nil {
+		return nil, fmt.Errorf("invalid policy %v: %v", path, err.Error())
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %v: %v", path, err.Error())
+	}
+	return policy, nil
+}
+
+func (p *Policy) validate() error {
+	if len(p.Permissions) == 0 {
+		return errors.New("no permissions found")
+	}
+	for i, permission := range p.Permissions {
+		if len(permission.Principals) == 0 || len(permission.Actions) == 0 {
+			return fmt.Errorf("permission %v must have principals and actions", i)
+		}
+		for _, action := range permission.Actions {
+			if action != Read && action != Write && action != Admin {
+				return fmt.Errorf("permission %v has unknown action: %v", i, action)
+			}
+		}
+	}
+	return nil
+}
+
+// Check returns error when policy refers to chains which are not in chains
+func (p *Policy) Check(chains []string) error {
+	for i, permission := range p.Permissions {
+		for _, chain := range permission.Chains {
+			if !contains(chains, chain) {
+				return fmt.Errorf("permission %v refers to unknown chain: %v", i, chain)
+			}
+		}
+	}
+	return nil
+}
+
+// Authorize returns decision for request, requests without principal are always denied
+func (p *Policy) Authorize(request Request) Decision {
+	decision := Decision{Request: request, Permission: -1}
+	if request.Principal == nil {
+		return decision
+	}
+	for i, permission := range p.Permissions {
+		if permission.allows(request) {
+			decision.Allowed, decision.Permission = true, i
+			break
+		}
+	}
+	return decision
+}
+
+func (p Permission) allows(r Request) bool {
+	if r.Unrestricted && (len(p.Chains) > 0 || len(p.Partitions) > 0 || len(p.Categories) > 0) {
+		return false
+	}
+	return (contains(p.Principals, "*") || contains(p.Principals, r.Principal.ID)) &&
+		hasAction(p.Actions, r.Action) &&
+		matches(p.Chains, r.Chain) &&
+		matches(p.Partitions, r.Partition) &&
+		(r.AnyCategory || matches(p.Categories, r.Category))
+}
+
+// matches returns true when value is in values or values are empty
+func matches(values []string, value string) bool {
+	return len(values) == 0 || contains(values, value)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAction(actions []Action, action Action) bool {
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPolicy = `{
+  "permissions": [
+    {"principals": ["billing-service"], "actions": ["write"], "chains": ["audit"], "partitions": ["billing"]},
+    {"principals": ["billing-service"], "actions": ["read"], "partitions": ["billing"], "categories": ["payment"]},
+    {"principals": ["alice", "bob"], "actions": ["read"]}
+  ]
+}`
+
+func writePolicy(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "auditor")
+	assert.Nil(t, err)
+	path := filepath.Join(dir, "policy.json")
+	ioutil.WriteFile(path, []byte(content), 0600)
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestPolicyAuthorize(t *testing.T) {
+	path, cleanup := writePolicy(t, testPolicy)
+	defer cleanup()
+	policy, err := LoadPolicy(path)
+	assert.Nil(t, err)
+
+	service, alice := &Principal{ID: "billing-service"}, &Principal{ID: "alice"}
+	for _, test := range []struct {
+		request    Request
+		permission int
+	}{
+		{Request{Principal: service, Action: Write, Chain: "audit", Partition: "billing", Category: "refund"}, 0},
+		{Request{Principal: service, Action: Write, Chain: "audit", Partition: "shipping"}, -1},
+		{Request{Principal: service, Action: Write, Chain: "events", Partition: "billing"}, -1},
+		{Request{Principal: service, Action: Read, Chain: "events", Partition: "billing", Category: "payment"}, 1},
+		{Request{Principal: service, Action: Read, Chain: "audit", Partition: "billing", Category: "refund"}, -1},
+		{Request{Principal: service, Action: Read, Chain: "audit", Partition: "billing", AnyCategory: true}, 1},
+		{Request{Principal: service, Action: Admin, Chain: "audit", Partition: "billing", AnyCategory: true}, -1},
+		{Request{Principal: alice, Action: Read, Chain: "audit", Partition: "shipping", Category: "refund"}, 2},
+		{Request{Principal: alice, Action: Write, Chain: "audit", Partition: "shipping"}, -1},
+		{Request{Action: Read, Chain: "audit"}, -1},
+	} {
+		decision := policy.Authorize(test.request)
+		assert.Equal(t, test.permission, decision.Permission, decision.String())
+		assert.Equal(t, test.permission >= 0, decision.Allowed, decision.String())
+	}
+
+	decision := policy.Authorize(Request{Principal: service, Action: Read, Chain: "audit", Partition: "billing", AnyCategory: true})
+	assert.Equal(t, "billing-service read chain: audit partition: billing category: * allowed by permission 1", decision.String())
+	decision = policy.Authorize(Request{Action: Write, Chain: "audit", Partition: "billing", Category: "payment"})
+	assert.Equal(t, "anonymous write chain: audit partition: billing category: payment denied", decision.String())
+}
+
+func TestPolicyUnrestricted(t *testing.T) {
+	policy := &Policy{Permissions: []Permission{
+		{Principals: []string{"billing-admin"}, Actions: []Action{Admin}, Partitions: []string{"billing"}},
+		{Principals: []string{"audit-admin"}, Actions: []Action{Admin}, Chains: []string{"audit"}},
+		{Principals: []string{"security-admin"}, Actions: []Action{Admin}},
+	}}
+	for principal, allowed := range map[string]bool{"billing-admin": false, "audit-admin": false, "security-admin": true} {
+		request := Request{Principal: &Principal{ID: principal}, Action: Admin, Unrestricted: true}
+		assert.Equal(t, allowed, policy.Authorize(request).Allowed, principal)
+	}
+	decision := policy.Authorize(Request{Principal: &Principal{ID: "billing-admin"}, Action: Admin, Unrestricted: true})
+	assert.Equal(t, "billing-admin admin chain: * partition: * category: * denied", decision.String())
+}
+
+func TestPolicyWildcardPrincipal(t *testing.T) {
+	policy := &Policy{Permissions: []Permission{{Principals: []string{"*"}, Actions: []Action{Read}}}}
+	assert.True(t, policy.Authorize(Request{Principal: &Principal{ID: "anyone"}, Action: Read, Chain: "audit"}).Allowed)
+	assert.False(t, policy.Authorize(Request{Action: Read, Chain: "audit"}).Allowed)
+}
+
+func TestPolicyCheck(t *testing.T) {
+	path, cleanup := writePolicy(t, testPolicy)
+	defer cleanup()
+	policy, err := LoadPolicy(path)
+	assert.Nil(t, err)
+	assert.Nil(t, policy.Check([]string{"audit"}))
+
+	policy.Permissions[0].Chains = []string{"events"}
+	assert.Equal(t, "permission 0 refers to unknown chain: events", policy.Check([]string{"audit"}).Error())
+}
+
+func TestLoadPolicyErrors(t *testing.T) {
+	for expected, content := range map[string]string{
+		"no permissions found":                          `{"permissions": []}`,
+		"permission 0 must have principals and actions": `{"permissions": [{"actions": ["read"]}]}`,
+		"permission 0 has unknown action: delete":       `{"permissions": [{"principals": ["alice"], "actions": ["delete"]}]}`,
+		`json: unknown field "roles"`:                   `{"permissions": [{"principals": ["alice"], "actions": ["read"], "roles": []}]}`,
+	} {
+		path, cleanup := writePolicy(t, content)
+		_, err := LoadPolicy(path)
+		if assert.NotNil(t, err, expected) {
+			assert.Equal(t, "invalid policy "+path+": "+expected, err.Error())
+		}
+		cleanup()
+	}
+	_, err := LoadPolicy("/non/existing/policy.json")
+	assert.NotNil(t, err)
+}
+
+func TestPolicyFromEnv(t *testing.T) {
+	path, cleanup := writePolicy(t, testPolicy)
+	defer cleanup()
+	defer os.Setenv("AUDITOR_POLICY", "")
+
+	assert.False(t, PolicyEnabled())
+	os.Setenv("AUDITOR_POLICY", path)
+	assert.True(t, PolicyEnabled())
+	policy, err := PolicyFromEnv()
+	assert.Nil(t, err)
+	assert.Len(t, policy.Permissions, 3)
+}
@@ -0,0 +1,148 @@
+package chain
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store"
+)
+
+const pageSize int64 = 100
+
+// Follower follows audit chain stored in a store and returns hashes of new blocks
+type Follower struct {
+	store  store.Store
+	block  interface{}
+	head   string
+	height int64
+}
+
+// NewFollower creates Follower which returns blocks added after block with head hash,
+// height is number of blocks in the chain up to and including head block (use empty head and 0 to follow the chain from the beginning),
+// block is a pointer to struct of the same type as blocks in the store,
+// it is used as last argument of the first Read call (for DynamoDB it must have dynamodb_partition field set),
+// panics if block type is invalid
+func NewFollower(store store.Store, block interface{}, head string, height int64) *Follower {
+	model.MustValidateBlockType(block)
+	return &Follower{store: store, block: block, head: head, height: height}
+}
+
+// Head returns hash of the most recent block returned by Follower and height of the chain
+func (f *Follower) Head() (string, int64) {
+	return f.head, f.height
+}
+
+// Next reads blocks added to the chain since last call and returns their hashes in chain order,
+// it returns error when head block is not in the chain any more
+func (f *Follower) Next() ([]string, error) {
+	var hashes []string
+	var err error
+	if walker, ok := f.store.(store.Walker); ok {
+		hashes, err = f.walk(walker)
+	} else {
+		hashes, err = f.read()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(hashes) > 0 {
+		f.head = hashes[len(hashes)-1]
+		f.height += int64(len(hashes))
+	}
+	return hashes, nil
+}
+
+func (f *Follower) headNotFound() error {
+	return fmt.Errorf("head %v not found in the chain, chain was truncated or rewritten", f.head)
+}
+
+// read reads the chain page by page, it is used for stores which hold the whole chain in one collection
+func (f *Follower) read() ([]string, error) {
+	t := reflect.TypeOf(f.block).Elem()
+	hashField := model.GetTypeFieldsTaggedWith(t, "hash")[0]
+	previousHashField := model.GetTypeFieldsTaggedWith(t, "previoushash")[0]
+
+	// blocks are read newest first, maps previous hash to hash
+	next := make(map[string]string)
+	last := f.block
+	found := false
+	for {
+		page := reflect.New(reflect.SliceOf(t))
+		page.Elem().Set(reflect.MakeSlice(reflect.SliceOf(t), 0, int(pageSize)))
+		if err := f.store.Read(page.Interface(), pageSize, last); err != nil {
+			return nil, err
+		}
+		blocks := page.Elem()
+		for i := 0; i < blocks.Len(); i++ {
+			block := blocks.Index(i).Addr().Interface()
+			hash := model.GetFieldStringValue(block, hashField)
+			if hash == f.head {
+				found = true
+				break
+			}
+			next[model.GetFieldStringValue(block, previousHashField)] = hash
+		}
+		if found || int64(blocks.Len()) < pageSize {
+			break
+		}
+		last = blocks.Index(blocks.Len() - 1).Addr().Interface()
+	}
+	if !found && len(f.head) > 0 {
+		return nil, f.headNotFound()
+	}
+
+	hashes := []string{}
+	for hash, ok := next[f.head]; ok; hash, ok = next[hash] {
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// walk follows previous hashes from the most recent block of the chain back to head block,
+// it is used for stores which link blocks of different partitions
+func (f *Follower) walk(walker store.Walker) ([]string, error) {
+	schema := model.SchemaFor(f.block)
+	block := reflect.New(schema.Type).Interface()
+	if schema.HasPartition() {
+		schema.SetPartition(block, schema.Partition(f.block))
+	}
+	err := walker.Head(block)
+	if err == store.ErrBlockNotFound {
+		if len(f.head) > 0 {
+			return nil, f.headNotFound()
+		}
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// hashes are collected newest first
+	hashes := []string{}
+	for hash := schema.Hash(block); hash != f.head; hash = schema.Hash(block) {
+		hashes = append(hashes, hash)
+		previousHash := schema.PreviousHash(block)
+		if len(previousHash) == 0 {
+			if len(f.head) > 0 {
+				return nil, f.headNotFound()
+			}
+			break
+		}
+		previous := reflect.New(schema.Type).Interface()
+		schema.SetHash(previous, previousHash)
+		if schema.HasPartition() {
+			schema.SetPartition(previous, schema.Partition(block))
+		}
+		if err := walker.FindByHash(previous); err == store.ErrBlockNotFound {
+			return nil, fmt.Errorf("block %v linked from block %v not found", previousHash, hash)
+		} else if err != nil {
+			return nil, err
+		}
+		block = previous
+	}
+	for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+		hashes[i], hashes[j] = hashes[j], hashes[i]
+	}
+	return hashes, nil
+}
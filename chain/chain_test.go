@@ -0,0 +1,96 @@
+package chain
+
+import (
+	"testing"
+
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNext(t *testing.T) {
+	audit := storetest.NewAudit(3)
+	follower := NewFollower(audit, &model.Block{}, "", 0)
+
+	hashes, err := follower.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{audit.HashAt(0), audit.HashAt(1), audit.HashAt(2)}, hashes)
+	head, height := follower.Head()
+	assert.Equal(t, audit.HashAt(2), head)
+	assert.Equal(t, int64(3), height)
+
+	hashes, err = follower.Next()
+	assert.Nil(t, err)
+	assert.Empty(t, hashes)
+
+	audit.Append(2)
+	hashes, err = follower.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{audit.HashAt(3), audit.HashAt(4)}, hashes)
+	_, height = follower.Head()
+	assert.Equal(t, int64(5), height)
+}
+
+func TestNextMultiplePages(t *testing.T) {
+	audit := storetest.NewAudit(int(pageSize) + 50)
+	follower := NewFollower(audit, &model.Block{}, "", 0)
+
+	hashes, err := follower.Next()
+	assert.Nil(t, err)
+	assert.Len(t, hashes, int(pageSize)+50)
+	assert.Equal(t, audit.HashAt(0), hashes[0])
+	assert.Equal(t, audit.HashAt(int(pageSize)+49), hashes[len(hashes)-1])
+}
+
+func TestNextFromHead(t *testing.T) {
+	audit := storetest.NewAudit(4)
+	follower := NewFollower(audit, &model.Block{}, audit.HashAt(1), 2)
+
+	hashes, err := follower.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{audit.HashAt(2), audit.HashAt(3)}, hashes)
+	_, height := follower.Head()
+	assert.Equal(t, int64(4), height)
+}
+
+func TestNextMissingHead(t *testing.T) {
+	audit := storetest.NewAudit(3)
+	follower := NewFollower(audit, &model.Block{}, "abc", 3)
+
+	hashes, err := follower.Next()
+	assert.Nil(t, hashes)
+	assert.Equal(t, "head abc not found in the chain, chain was truncated or rewritten", err.Error())
+}
+
+func TestNextPartitions(t *testing.T) {
+	audit := &storetest.PartitionedStore{Store: *storetest.NewAudit(3)}
+	// blocks of other partitions are linked into the same chain
+	audit.Blocks[1].Interface().(*model.Block).Customer = "def"
+	model.ComputeAndSetHash(audit.Blocks[1].Interface())
+	audit.Blocks[2].Interface().(*model.Block).PreviousHash = audit.HashAt(1)
+	model.ComputeAndSetHash(audit.Blocks[2].Interface())
+	follower := NewFollower(audit, &model.Block{Customer: "abc"}, "", 0)
+
+	hashes, err := follower.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{audit.HashAt(0), audit.HashAt(1), audit.HashAt(2)}, hashes)
+
+	audit.Append(1)
+	hashes, err = follower.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{audit.HashAt(3)}, hashes)
+	_, height := follower.Head()
+	assert.Equal(t, int64(4), height)
+
+	// chain was truncated
+	audit.Blocks = audit.Blocks[:2]
+	hashes, err = follower.Next()
+	assert.Nil(t, hashes)
+	assert.Contains(t, err.Error(), "not found in the chain")
+
+	// chain is empty
+	follower = NewFollower(&storetest.PartitionedStore{}, &model.Block{}, "", 0)
+	hashes, err = follower.Next()
+	assert.Nil(t, err)
+	assert.Empty(t, hashes)
+}
@@ -0,0 +1,381 @@
+package checkpoint
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/chain"
+	"github.com/lukaszbudnik/auditor/merkle"
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store"
+	"github.com/lukaszbudnik/auditor/store/provider"
+)
+
+const (
+	// Name is a name of collection (MongoDB) or table (DynamoDB) used for storing checkpoints
+	Name = "checkpoint"
+	// Log is a value of dynamodb_partition field of all checkpoints
+	Log                = "audit"
+	pageSize     int64 = 100
+	pollInterval       = time.Second
+)
+
+var (
+	// ErrNotCheckpointed is returned when block is not yet included in any checkpoint
+	ErrNotCheckpointed = errors.New("block not found in any checkpoint")
+	// ErrCheckpointNotFound is returned when there is no checkpoint of a given size
+	ErrCheckpointNotFound = errors.New("checkpoint not found")
+)
+
+// Proof is a Merkle inclusion proof of a block in the latest checkpoint
+type Proof struct {
+	Hash           string
+	LeafIndex      int64
+	TreeSize       int64
+	Root           string
+	AuditPath      []string
+	CheckpointHash string
+}
+
+// ConsistencyProof is a Merkle consistency proof between two checkpoints
+type ConsistencyProof struct {
+	FromSize int64
+	FromRoot string
+	ToSize   int64
+	ToRoot   string
+	Proof    []string
+}
+
+// Checkpointer groups blocks into epochs and persists a checkpoint for every epoch
+type Checkpointer struct {
+	follower       *chain.Follower
+	checkpoints    store.Store
+	blocks         int
+	interval       time.Duration
+	lock           *sync.Mutex
+	leaves         []string
+	indexes        map[string]int
+	roots          map[int64]string
+	pending        []string
+	latest         *model.Checkpoint
+	lastCheckpoint time.Time
+	done           chan struct{}
+	stopped        chan struct{}
+}
+
+// Enabled returns true when AUDITOR_CHECKPOINT_BLOCKS or AUDITOR_CHECKPOINT_INTERVAL is set
+func Enabled() bool {
+	return len(os.Getenv("AUDITOR_CHECKPOINT_BLOCKS")) > 0 || len(os.Getenv("AUDITOR_CHECKPOINT_INTERVAL")) > 0
+}
+
+// NewFromEnv creates Checkpointer configured using AUDITOR_CHECKPOINT_* env variables,
+// block is a pointer to struct of the same type as blocks stored in audit store
+func NewFromEnv(audit store.Store, block interface{}) (*Checkpointer, error) {
+	var blocks int
+	var interval time.Duration
+	var err error
+	if s := os.Getenv("AUDITOR_CHECKPOINT_BLOCKS"); len(s) > 0 {
+		if blocks, err = strconv.Atoi(s); err != nil || blocks < 0 {
+			return nil, fmt.Errorf("invalid AUDITOR_CHECKPOINT_BLOCKS: %v", s)
+		}
+	}
+	if s := os.Getenv("AUDITOR_CHECKPOINT_INTERVAL"); len(s) > 0 {
+		if interval, err = time.ParseDuration(s); err != nil || interval < 0 {
+			return nil, fmt.Errorf("invalid AUDITOR_CHECKPOINT_INTERVAL: %v", s)
+		}
+	}
+	// DynamoDB follows the chain by hash from its head, partition is only used for tables saved before head item was introduced
+	fields := model.GetFieldsTaggedWith(block, "dynamodb_partition")
+	if len(fields) > 0 {
+		model.SetFieldValue(block, fields[0], os.Getenv("AUDITOR_CHECKPOINT_PARTITION"))
+	}
+	checkpoints, err := provider.NewStoreWithName(Name)
+	if err != nil {
+		return nil, err
+	}
+	return New(audit, checkpoints, block, blocks, interval)
+}
+
+// New creates Checkpointer which creates a checkpoint every blocks blocks or every interval,
+// existing checkpoints are loaded from checkpoints store and verified
+func New(audit, checkpoints store.Store, block interface{}, blocks int, interval time.Duration) (*Checkpointer, error) {
+	if blocks <= 0 && interval <= 0 {
+		return nil, errors.New("checkpoint blocks or interval must be greater than zero")
+	}
+	c := &Checkpointer{
+		checkpoints:    checkpoints,
+		blocks:         blocks,
+		interval:       interval,
+		lock:           &sync.Mutex{},
+		leaves:         []string{},
+		indexes:        make(map[string]int),
+		roots:          make(map[int64]string),
+		pending:        []string{},
+		lastCheckpoint: time.Now(),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	head := ""
+	if len(c.leaves) > 0 {
+		head = c.leaves[len(c.leaves)-1]
+	}
+	c.follower = chain.NewFollower(audit, block, head, int64(len(c.leaves)))
+	return c, nil
+}
+
+func (c *Checkpointer) load() error {
+	all := []model.Checkpoint{}
+	last := &model.Checkpoint{Log: Log}
+	for {
+		page := []model.Checkpoint{}
+		if err := c.checkpoints.Read(&page, pageSize, last); err != nil {
+			return err
+		}
+		all = append(all, page...)
+		if int64(len(page)) < pageSize {
+			break
+		}
+		last = &page[len(page)-1]
+	}
+	// checkpoints are read newest first
+	for i := len(all) - 1; i >= 0; i-- {
+		checkpoint := all[i]
+		if checkpoint.From != int64(len(c.leaves)) {
+			return fmt.Errorf("checkpoint %v starts at %v but %v blocks were checkpointed before it", checkpoint.Hash, checkpoint.From, len(c.leaves))
+		}
+		c.append(checkpoint.Hashes)
+		if checkpoint.Size != int64(len(c.leaves)) || checkpoint.Root != root(c.leaves) {
+			return fmt.Errorf("checkpoint %v does not match blocks it covers", checkpoint.Hash)
+		}
+		c.roots[checkpoint.Size] = checkpoint.Root
+		c.latest = &all[i]
+	}
+	if c.latest != nil {
+		log.Printf("INFO auditor loaded %v checkpoints covering %v blocks", len(all), c.latest.Size)
+	}
+	return nil
+}
+
+func (c *Checkpointer) append(hashes []string) {
+	for _, hash := range hashes {
+		c.indexes[hash] = len(c.leaves)
+		c.leaves = append(c.leaves, hash)
+	}
+}
+
+// Start starts a background goroutine which periodically creates checkpoints
+func (c *Checkpointer) Start() {
+	c.done = make(chan struct{})
+	c.stopped = make(chan struct{})
+	go func() {
+		defer close(c.stopped)
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-ticker.C:
+				if err := c.Run(); err != nil {
+					log.Printf("ERROR Could not create checkpoint: %v", err.Error())
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops background goroutine started by Start and waits until it exits,
+// so that checkpoint being created is saved before the store is closed
+func (c *Checkpointer) Stop() {
+	if c.done != nil {
+		close(c.done)
+		<-c.stopped
+		c.done = nil
+	}
+}
+
+// Run reads new blocks from audit store and creates checkpoints when epoch is complete
+func (c *Checkpointer) Run() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	hashes, err := c.follower.Next()
+	if err != nil {
+		return err
+	}
+	c.pending = append(c.pending, hashes...)
+
+	for c.blocks > 0 && len(c.pending) >= c.blocks {
+		if err := c.checkpoint(c.pending[:c.blocks]); err != nil {
+			return err
+		}
+		c.pending = c.pending[c.blocks:]
+	}
+	if c.interval > 0 && len(c.pending) > 0 && time.Since(c.lastCheckpoint) >= c.interval {
+		if err := c.checkpoint(c.pending); err != nil {
+			return err
+		}
+		c.pending = []string{}
+	}
+	return nil
+}
+
+func (c *Checkpointer) checkpoint(hashes []string) error {
+	from := len(c.leaves)
+	leaves := append(c.leaves[:from:from], hashes...)
+	now := time.Now()
+	checkpoint := &model.Checkpoint{
+		Log:       Log,
+		Timestamp: &now,
+		Root:      root(leaves),
+		From:      int64(from),
+		Size:      int64(len(leaves)),
+		Hashes:    append([]string{}, hashes...),
+	}
+	if err := c.checkpoints.Save(checkpoint); err != nil {
+		return err
+	}
+	c.append(checkpoint.Hashes)
+	c.roots[checkpoint.Size] = checkpoint.Root
+	c.latest = checkpoint
+	c.lastCheckpoint = now
+	log.Printf("INFO auditor created checkpoint %v covering %v blocks", checkpoint.Hash, checkpoint.Size)
+	return nil
+}
+
+// Latest returns the most recent checkpoint or nil if there are no checkpoints
+func (c *Checkpointer) Latest() *model.Checkpoint {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.latest
+}
+
+// Proof returns Merkle inclusion proof of block with given hash in the latest checkpoint
+func (c *Checkpointer) Proof(hash string) (*Proof, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	index, ok := c.indexes[hash]
+	if !ok {
+		return nil, ErrNotCheckpointed
+	}
+	path, err := merkle.InclusionProof(index, leafData(c.leaves))
+	if err != nil {
+		return nil, err
+	}
+	proof := &Proof{
+		Hash:           hash,
+		LeafIndex:      int64(index),
+		TreeSize:       c.latest.Size,
+		Root:           c.latest.Root,
+		AuditPath:      encode(path),
+		CheckpointHash: c.latest.Hash,
+	}
+	return proof, nil
+}
+
+// Consistency returns Merkle consistency proof between checkpoints of sizes from and to
+func (c *Checkpointer) Consistency(from, to int64) (*ConsistencyProof, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	fromRoot, ok := c.roots[from]
+	if !ok {
+		return nil, ErrCheckpointNotFound
+	}
+	toRoot, ok := c.roots[to]
+	if !ok {
+		return nil, ErrCheckpointNotFound
+	}
+	if from > to {
+		return nil, fmt.Errorf("from checkpoint size %v must not be greater than to checkpoint size %v", from, to)
+	}
+	path, err := merkle.ConsistencyProof(int(from), leafData(c.leaves[:to]))
+	if err != nil {
+		return nil, err
+	}
+	return &ConsistencyProof{
+		FromSize: from,
+		FromRoot: fromRoot,
+		ToSize:   to,
+		ToRoot:   toRoot,
+		Proof:    encode(path),
+	}, nil
+}
+
+// VerifyProof verifies Merkle inclusion proof of a block in checkpoint trusted by the caller (for example cosigned by a witness),
+// root returned in the proof is ignored, proof of a different tree size than the trusted checkpoint is rejected
+func VerifyProof(proof *Proof, trusted *model.Checkpoint) bool {
+	if proof.TreeSize != trusted.Size {
+		return false
+	}
+	root, err := hex.DecodeString(trusted.Root)
+	if err != nil {
+		return false
+	}
+	path, err := decode(proof.AuditPath)
+	if err != nil {
+		return false
+	}
+	return merkle.VerifyInclusion([]byte(proof.Hash), int(proof.LeafIndex), int(proof.TreeSize), path, root)
+}
+
+// VerifyConsistencyProof verifies that trusted checkpoint from (for example the one recorded previously) is a prefix of
+// trusted checkpoint to, which means that the chain only grew between these checkpoints, roots returned in the proof are ignored
+func VerifyConsistencyProof(proof *ConsistencyProof, from, to *model.Checkpoint) bool {
+	if proof.FromSize != from.Size || proof.ToSize != to.Size {
+		return false
+	}
+	fromRoot, err := hex.DecodeString(from.Root)
+	if err != nil {
+		return false
+	}
+	toRoot, err := hex.DecodeString(to.Root)
+	if err != nil {
+		return false
+	}
+	path, err := decode(proof.Proof)
+	if err != nil {
+		return false
+	}
+	return merkle.VerifyConsistency(int(from.Size), int(to.Size), path, fromRoot, toRoot)
+}
+
+func encode(path [][]byte) []string {
+	encoded := make([]string, len(path))
+	for i, p := range path {
+		encoded[i] = hex.EncodeToString(p)
+	}
+	return encoded
+}
+
+func decode(path []string) ([][]byte, error) {
+	decoded := make([][]byte, len(path))
+	for i, p := range path {
+		var err error
+		if decoded[i], err = hex.DecodeString(p); err != nil {
+			return nil, err
+		}
+	}
+	return decoded, nil
+}
+
+// leafData converts block hashes to Merkle tree leaves, leaf data is block hash string
+func leafData(hashes []string) [][]byte {
+	leaves := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		leaves[i] = []byte(hash)
+	}
+	return leaves
+}
+
+func root(hashes []string) string {
+	return hex.EncodeToString(merkle.Root(leafData(hashes)))
+}
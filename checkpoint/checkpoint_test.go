@@ -0,0 +1,190 @@
+package checkpoint
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewError(t *testing.T) {
+	_, err := New(storetest.NewAudit(0), &storetest.Store{}, &model.Block{}, 0, 0)
+	assert.Equal(t, "checkpoint blocks or interval must be greater than zero", err.Error())
+}
+
+func TestRunBlocks(t *testing.T) {
+	audit := storetest.NewAudit(5)
+	checkpoints := &storetest.Store{}
+	c, err := New(audit, checkpoints, &model.Block{}, 2, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, c.Latest())
+
+	err = c.Run()
+	assert.Nil(t, err)
+	assert.Len(t, checkpoints.Blocks, 2)
+	assert.Equal(t, int64(2), c.Latest().From)
+	assert.Equal(t, int64(4), c.Latest().Size)
+	assert.Equal(t, []string{audit.HashAt(2), audit.HashAt(3)}, c.Latest().Hashes)
+	assert.Equal(t, []string{audit.HashAt(4)}, c.pending)
+}
+
+func TestRunInterval(t *testing.T) {
+	audit := storetest.NewAudit(3)
+	c, err := New(audit, &storetest.Store{}, &model.Block{}, 0, time.Nanosecond)
+	assert.Nil(t, err)
+
+	err = c.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), c.Latest().Size)
+
+	// no new blocks no new checkpoint
+	latest := c.Latest()
+	err = c.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, latest, c.Latest())
+
+	audit.Append(2)
+	err = c.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), c.Latest().From)
+	assert.Equal(t, int64(5), c.Latest().Size)
+}
+
+func TestProof(t *testing.T) {
+	audit := storetest.NewAudit(7)
+	c, err := New(audit, &storetest.Store{}, &model.Block{}, 3, 0)
+	assert.Nil(t, err)
+	err = c.Run()
+	assert.Nil(t, err)
+
+	for i := 0; i < 6; i++ {
+		proof, err := c.Proof(audit.HashAt(i))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(i), proof.LeafIndex)
+		assert.Equal(t, int64(6), proof.TreeSize)
+		assert.Equal(t, c.Latest().Root, proof.Root)
+		assert.Equal(t, c.Latest().Hash, proof.CheckpointHash)
+		assert.True(t, VerifyProof(proof, c.Latest()))
+	}
+
+	// 7th block is still pending
+	_, err = c.Proof(audit.HashAt(6))
+	assert.Equal(t, ErrNotCheckpointed, err)
+}
+
+func TestVerifyProofTampered(t *testing.T) {
+	audit := storetest.NewAudit(4)
+	c, err := New(audit, &storetest.Store{}, &model.Block{}, 4, 0)
+	assert.Nil(t, err)
+	err = c.Run()
+	assert.Nil(t, err)
+
+	proof, err := c.Proof(audit.HashAt(1))
+	assert.Nil(t, err)
+	proof.Hash = audit.HashAt(2)
+	assert.False(t, VerifyProof(proof, c.Latest()))
+	proof.Hash = audit.HashAt(1)
+
+	// root returned in the proof is not trusted
+	proof.Root = root([]string{audit.HashAt(0), audit.HashAt(2), audit.HashAt(1), audit.HashAt(3)})
+	assert.True(t, VerifyProof(proof, c.Latest()))
+	assert.False(t, VerifyProof(proof, &model.Checkpoint{Size: 4, Root: proof.Root}))
+	assert.False(t, VerifyProof(proof, &model.Checkpoint{Size: 4, Root: "not hex"}))
+	assert.False(t, VerifyProof(proof, &model.Checkpoint{Size: 3, Root: c.Latest().Root}))
+}
+
+func TestLoad(t *testing.T) {
+	audit := storetest.NewAudit(4)
+	checkpoints := &storetest.Store{}
+	c1, err := New(audit, checkpoints, &model.Block{}, 2, 0)
+	assert.Nil(t, err)
+	err = c1.Run()
+	assert.Nil(t, err)
+
+	audit.Append(2)
+
+	c2, err := New(audit, checkpoints, &model.Block{}, 2, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, c1.Latest().Hash, c2.Latest().Hash)
+	assert.Equal(t, c1.leaves, c2.leaves)
+
+	err = c2.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), c2.Latest().Size)
+	assert.Equal(t, []string{audit.HashAt(4), audit.HashAt(5)}, c2.Latest().Hashes)
+}
+
+func TestLoadTampered(t *testing.T) {
+	audit := storetest.NewAudit(4)
+	checkpoints := &storetest.Store{}
+	c, err := New(audit, checkpoints, &model.Block{}, 2, 0)
+	assert.Nil(t, err)
+	err = c.Run()
+	assert.Nil(t, err)
+
+	// remove block from the first checkpoint
+	first := checkpoints.Blocks[0].Interface().(*model.Checkpoint)
+	first.Hashes = first.Hashes[1:]
+
+	_, err = New(audit, checkpoints, &model.Block{}, 2, 0)
+	assert.NotNil(t, err)
+}
+
+func TestConsistency(t *testing.T) {
+	audit := storetest.NewAudit(7)
+	c, err := New(audit, &storetest.Store{}, &model.Block{}, 3, 0)
+	assert.Nil(t, err)
+	err = c.Run()
+	assert.Nil(t, err)
+	audit.Append(5)
+	err = c.Run()
+	assert.Nil(t, err)
+
+	sizes := []int64{3, 6, 9, 12}
+	for i, from := range sizes {
+		for _, to := range sizes[i:] {
+			proof, err := c.Consistency(from, to)
+			assert.Nil(t, err)
+			assert.Equal(t, from, proof.FromSize)
+			assert.Equal(t, to, proof.ToSize)
+			assert.True(t, VerifyConsistencyProof(proof, &model.Checkpoint{Size: from, Root: c.roots[from]}, &model.Checkpoint{Size: to, Root: c.roots[to]}))
+		}
+	}
+}
+
+func TestConsistencyErrors(t *testing.T) {
+	audit := storetest.NewAudit(6)
+	c, err := New(audit, &storetest.Store{}, &model.Block{}, 3, 0)
+	assert.Nil(t, err)
+	err = c.Run()
+	assert.Nil(t, err)
+
+	_, err = c.Consistency(2, 6)
+	assert.Equal(t, ErrCheckpointNotFound, err)
+	_, err = c.Consistency(3, 7)
+	assert.Equal(t, ErrCheckpointNotFound, err)
+	_, err = c.Consistency(6, 3)
+	assert.Equal(t, "from checkpoint size 6 must not be greater than to checkpoint size 3", err.Error())
+}
+
+func TestVerifyConsistencyProofRewritten(t *testing.T) {
+	audit := storetest.NewAudit(6)
+	checkpoints := &storetest.Store{}
+	c, err := New(audit, checkpoints, &model.Block{}, 3, 0)
+	assert.Nil(t, err)
+	err = c.Run()
+	assert.Nil(t, err)
+
+	proof, err := c.Consistency(3, 6)
+	assert.Nil(t, err)
+	to := c.Latest()
+	// root recorded from the first checkpoint does not match rewritten log, roots returned in the proof are not trusted
+	rewritten := &model.Checkpoint{Size: 3, Root: root([]string{audit.HashAt(0), audit.HashAt(2), audit.HashAt(1)})}
+	proof.FromRoot = rewritten.Root
+	assert.False(t, VerifyConsistencyProof(proof, rewritten, to))
+	assert.False(t, VerifyConsistencyProof(proof, &model.Checkpoint{Size: 3, Root: "not hex"}, to))
+	assert.False(t, VerifyConsistencyProof(proof, &model.Checkpoint{Size: 2, Root: c.roots[3]}, to))
+	assert.True(t, VerifyConsistencyProof(proof, &model.Checkpoint{Size: 3, Root: c.roots[3]}, to))
+}
@@ -0,0 +1,304 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store"
+)
+
+const (
+	// Prefix is a prefix of all encrypted field values
+	Prefix  = "enc:v1:"
+	keySize = 32
+)
+
+// Keyring holds AES-256 data keys, Current key is used for encryption, all keys are used for decryption,
+// when Subjects is set blocks with non empty field tagged with subject are encrypted using subject keys
+// and the subject is replaced by its pseudonym
+type Keyring struct {
+	Current  string
+	Keys     map[string][]byte
+	Subjects *SubjectKeys `json:"-"`
+}
+
+// Enabled returns true when AUDITOR_KEYRING is set
+func Enabled() bool {
+	return len(os.Getenv("AUDITOR_KEYRING")) > 0
+}
+
+// LoadKeyring loads keyring from JSON file, keys are base64 encoded
+func LoadKeyring(path string) (*Keyring, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keyring := &Keyring{}
+	if err := json.Unmarshal(bytes, keyring); err != nil {
+		return nil, err
+	}
+	if err := keyring.validate(); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+func (k *Keyring) validate() error {
+	if _, ok := k.Keys[k.Current]; !ok {
+		return fmt.Errorf("current key %v not found in keyring", k.Current)
+	}
+	for id, key := range k.Keys {
+		if len(id) == 0 || strings.Contains(id, ":") || strings.HasPrefix(id, subjectKeyPrefix) || strings.HasPrefix(id, pseudonymKeyPrefix) {
+			return fmt.Errorf("invalid key id: %v", id)
+		}
+		if len(key) != keySize {
+			return fmt.Errorf("key %v must be %v bytes long, but is %v", id, keySize, len(key))
+		}
+	}
+	return nil
+}
+
+// Encrypt encrypts all non empty fields tagged with encrypt using subject key or current key and replaces subject by its pseudonym,
+// values which look like encrypted ones are encrypted too, otherwise clients could store unencrypted or malformed values
+func Encrypt(keyring *Keyring, block interface{}) error {
+	for _, field := range model.GetFieldsTaggedWith(block, "encrypt") {
+		value := model.GetFieldStringValue(block, field)
+		if len(value) == 0 {
+			continue
+		}
+		id, key, err := encryptionKey(keyring, block)
+		if err != nil {
+			return err
+		}
+		encrypted, err := seal(id, key, field.Name, value)
+		if err != nil {
+			return err
+		}
+		model.SetFieldValue(block, field, encrypted)
+	}
+	if keyring.Subjects != nil {
+		for _, field := range model.GetFieldsTaggedWith(block, "subject") {
+			if subject := model.GetFieldStringValue(block, field); len(subject) > 0 {
+				model.SetFieldValue(block, field, keyring.Subjects.Pseudonym(subject))
+			}
+		}
+	}
+	return nil
+}
+
+func encryptionKey(keyring *Keyring, block interface{}) (string, []byte, error) {
+	fields := model.GetFieldsTaggedWith(block, "subject")
+	if keyring.Subjects != nil && len(fields) > 0 {
+		if subject := model.GetFieldStringValue(block, fields[0]); len(subject) > 0 {
+			key, err := keyring.Subjects.key(subject, true)
+			if err == store.ErrKeyDestroyed {
+				return "", nil, ErrSubjectErased
+			}
+			return keyring.Subjects.keyID(subject), key, err
+		}
+	}
+	key, ok := keyring.Keys[keyring.Current]
+	if !ok {
+		return "", nil, errors.New("keyring has no current key")
+	}
+	return keyring.Current, key, nil
+}
+
+func decryptionKey(keyring *Keyring, id string) ([]byte, error) {
+	if strings.HasPrefix(id, pseudonymKeyPrefix) {
+		if keyring.Subjects == nil {
+			return nil, errors.New("subject keys are not configured")
+		}
+		return keyring.Subjects.keys.GetKey(id)
+	}
+	// keys created before subjects were pseudonymised were imported under pseudonyms
+	if subject, ok := subjectFromKeyID(id); ok {
+		if keyring.Subjects == nil {
+			return nil, errors.New("subject keys are not configured")
+		}
+		return keyring.Subjects.key(subject, false)
+	}
+	key, ok := keyring.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("key %v not found in keyring", id)
+	}
+	return key, nil
+}
+
+// Decrypt decrypts all fields tagged with encrypt, fields encrypted with destroyed subject keys are set to Erased,
+// missing subject key is an error
+func Decrypt(keyring *Keyring, block interface{}) error {
+	for _, field := range model.GetFieldsTaggedWith(block, "encrypt") {
+		value := model.GetFieldStringValue(block, field)
+		if !strings.HasPrefix(value, Prefix) {
+			continue
+		}
+		id, ciphertext, err := parse(value)
+		if err != nil {
+			return err
+		}
+		key, err := decryptionKey(keyring, id)
+		if err == store.ErrKeyDestroyed {
+			model.SetFieldValue(block, field, Erased)
+			continue
+		}
+		if err == store.ErrKeyNotFound {
+			return fmt.Errorf("key %v not found in subject keys", id)
+		}
+		if err != nil {
+			return err
+		}
+		plaintext, err := open(key, field.Name, ciphertext)
+		if err != nil {
+			return err
+		}
+		model.SetFieldValue(block, field, plaintext)
+	}
+	return nil
+}
+
+// seal encrypts value using AES-GCM, field name is used as additional authenticated data
+// so that encrypted values cannot be moved between fields
+func seal(id string, key []byte, name, value string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return fmt.Sprintf("%v%v:%v", Prefix, id, base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+func open(key []byte, name string, sealed []byte) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// parse splits encrypted value into key id and sealed bytes
+func parse(value string) (string, []byte, error) {
+	parts := strings.SplitN(strings.TrimPrefix(value, Prefix), ":", 2)
+	if len(parts) != 2 {
+		return "", nil, errors.New("invalid encrypted value")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, err
+	}
+	return parts[0], sealed, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type encryptedStore struct {
+	store   store.Store
+	keyring *Keyring
+}
+
+// NewStore creates Store which encrypts fields tagged with encrypt before saving blocks to the underlying store
+// (block hash is computed over encrypted values) and decrypts them after reading
+func NewStore(store store.Store, keyring *Keyring) store.Store {
+	return &encryptedStore{store: store, keyring: keyring}
+}
+
+func (s *encryptedStore) Save(block interface{}) error {
+	if err := Encrypt(s.keyring, block); err != nil {
+		return err
+	}
+	return s.store.Save(block)
+}
+
+// SaveSequenced encrypts block and saves it using underlying store, sort field cannot be encrypted so sequence sees plain values
+func (s *encryptedStore) SaveSequenced(block interface{}, sequence func(block, head interface{})) error {
+	sequencer, ok := s.store.(store.Sequencer)
+	if !ok {
+		return s.Save(block)
+	}
+	if err := Encrypt(s.keyring, block); err != nil {
+		return err
+	}
+	return sequencer.SaveSequenced(block, sequence)
+}
+
+func (s *encryptedStore) Read(result interface{}, limit int64, last interface{}) error {
+	if err := s.store.Read(result, limit, last); err != nil {
+		return err
+	}
+	slicev := reflect.ValueOf(result).Elem()
+	for i := 0; i < slicev.Len(); i++ {
+		if err := Decrypt(s.keyring, slicev.Index(i).Addr().Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Redact redacts block using underlying store and decrypts it, commitments are computed over encrypted values
+func (s *encryptedStore) Redact(block interface{}, fields []string) error {
+	redactor, ok := s.store.(store.Redactor)
+	if !ok {
+		return store.ErrRedactionNotSupported
+	}
+	if err := redactor.Redact(block, fields); err != nil {
+		return err
+	}
+	return Decrypt(s.keyring, block)
+}
+
+// FindByHash finds block using underlying store and decrypts it
+func (s *encryptedStore) FindByHash(block interface{}) error {
+	finder, ok := s.store.(store.Finder)
+	if !ok {
+		return store.ErrFindNotSupported
+	}
+	if err := finder.FindByHash(block); err != nil {
+		return err
+	}
+	return Decrypt(s.keyring, block)
+}
+
+// FindByIdempotencyKey finds block using underlying store and decrypts it
+func (s *encryptedStore) FindByIdempotencyKey(block interface{}) error {
+	deduplicator, ok := s.store.(store.Deduplicator)
+	if !ok {
+		return store.ErrIdempotencyNotSupported
+	}
+	if err := deduplicator.FindByIdempotencyKey(block); err != nil {
+		return err
+	}
+	return Decrypt(s.keyring, block)
+}
+
+func (s *encryptedStore) Close() {
+	s.store.Close()
+}
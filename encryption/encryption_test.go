@@ -0,0 +1,378 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store"
+	"github.com/lukaszbudnik/auditor/store/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+type testBlock struct {
+	Timestamp    *time.Time `auditor:"sort"`
+	Customer     string
+	Email        string `auditor:"encrypt"`
+	Phone        string `auditor:"encrypt"`
+	Hash         string `auditor:"hash"`
+	PreviousHash string `auditor:"previoushash"`
+}
+
+type mockStore struct {
+	blocks []testBlock
+}
+
+func (ms *mockStore) Save(block interface{}) error {
+	model.ComputeAndSetHash(block)
+	ms.blocks = append(ms.blocks, *block.(*testBlock))
+	return nil
+}
+
+func (ms *mockStore) Read(result interface{}, limit int64, last interface{}) error {
+	blocks := result.(*[]testBlock)
+	*blocks = append(*blocks, ms.blocks...)
+	return nil
+}
+
+func (ms *mockStore) Close() {
+}
+
+func newKeyring() *Keyring {
+	return &Keyring{
+		Current: "k2",
+		Keys: map[string][]byte{
+			"k1": bytes.Repeat([]byte{1}, keySize),
+			"k2": bytes.Repeat([]byte{2}, keySize),
+		},
+	}
+}
+
+func writeKeyring(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "keyring")
+	assert.Nil(t, err)
+	file.WriteString(content)
+	file.Close()
+	return file.Name()
+}
+
+func TestLoadKeyring(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, keySize))
+	path := writeKeyring(t, fmt.Sprintf(`{"Current": "k1", "Keys": {"k1": "%v"}}`, key))
+	defer os.Remove(path)
+
+	keyring, err := LoadKeyring(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "k1", keyring.Current)
+	assert.Len(t, keyring.Keys["k1"], keySize)
+}
+
+func TestLoadKeyringErrors(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, keySize))
+	short := base64.StdEncoding.EncodeToString([]byte{1, 2, 3})
+	invalid := map[string]string{
+		fmt.Sprintf(`{"Current": "k2", "Keys": {"k1": "%v"}}`, key):                   "current key k2 not found in keyring",
+		fmt.Sprintf(`{"Current": "k1", "Keys": {"k1": "%v"}}`, short):                 "key k1 must be 32 bytes long, but is 3",
+		fmt.Sprintf(`{"Current": "k:1", "Keys": {"k:1": "%v"}}`, key):                 "invalid key id: k:1",
+		fmt.Sprintf(`{"Current": "pseudonym.a", "Keys": {"pseudonym.a": "%v"}}`, key): "invalid key id: pseudonym.a",
+	}
+	for content, message := range invalid {
+		path := writeKeyring(t, content)
+		_, err := LoadKeyring(path)
+		os.Remove(path)
+		assert.Equal(t, message, err.Error())
+	}
+
+	_, err := LoadKeyring("/does/not/exist.json")
+	assert.NotNil(t, err)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keyring := newKeyring()
+	block := &testBlock{Customer: "abc", Email: "john@example.com"}
+
+	err := Encrypt(keyring, block)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", block.Customer)
+	assert.True(t, strings.HasPrefix(block.Email, Prefix+"k2:"))
+	// empty values are not encrypted
+	assert.Equal(t, "", block.Phone)
+
+	err = Decrypt(keyring, block)
+	assert.Nil(t, err)
+	assert.Equal(t, "john@example.com", block.Email)
+
+	// values sent with encryption prefix are encrypted as any other value
+	forged := &testBlock{Customer: "abc", Email: Prefix + "k1:not-base64"}
+	err = Encrypt(keyring, forged)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(forged.Email, Prefix+"k2:"))
+	err = Decrypt(keyring, forged)
+	assert.Nil(t, err)
+	assert.Equal(t, Prefix+"k1:not-base64", forged.Email)
+}
+
+func TestDecryptRotatedKey(t *testing.T) {
+	keyring := newKeyring()
+	keyring.Current = "k1"
+	block := &testBlock{Email: "john@example.com"}
+	err := Encrypt(keyring, block)
+	assert.Nil(t, err)
+
+	keyring.Current = "k2"
+	err = Decrypt(keyring, block)
+	assert.Nil(t, err)
+	assert.Equal(t, "john@example.com", block.Email)
+}
+
+func TestDecryptErrors(t *testing.T) {
+	keyring := newKeyring()
+	block := &testBlock{Email: "john@example.com"}
+	err := Encrypt(keyring, block)
+	assert.Nil(t, err)
+
+	// encrypted value moved to another field
+	moved := &testBlock{Phone: block.Email}
+	assert.NotNil(t, Decrypt(keyring, moved))
+
+	// unknown key
+	delete(keyring.Keys, "k2")
+	assert.Equal(t, "key k2 not found in keyring", Decrypt(keyring, block).Error())
+
+	assert.Equal(t, "invalid encrypted value", Decrypt(keyring, &testBlock{Email: Prefix + "k1"}).Error())
+	assert.Equal(t, "encrypted value is too short", Decrypt(keyring, &testBlock{Email: Prefix + "k1:AQID"}).Error())
+}
+
+func TestStore(t *testing.T) {
+	keyring := newKeyring()
+	ms := &mockStore{}
+	store := NewStore(ms, keyring)
+	defer store.Close()
+
+	now := time.Now()
+	block := &testBlock{Timestamp: &now, Customer: "abc", Email: "john@example.com"}
+	err := store.Save(block)
+	assert.Nil(t, err)
+
+	// underlying store saves ciphertext and hash is computed over it
+	saved := ms.blocks[0]
+	assert.True(t, strings.HasPrefix(saved.Email, Prefix))
+	saved.Hash = ""
+	expected, err := model.ComputeHash(&saved)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, ms.blocks[0].Hash)
+
+	result := []testBlock{}
+	err = store.Read(&result, 10, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "john@example.com", result[0].Email)
+	assert.Equal(t, ms.blocks[0].Hash, result[0].Hash)
+}
+
+var testSecret = []byte("01234567890123456789012345678901")
+
+type subjectBlock struct {
+	Timestamp    *time.Time `auditor:"sort"`
+	Customer     string     `auditor:"subject"`
+	Email        string     `auditor:"encrypt"`
+	Hash         string     `auditor:"hash"`
+	PreviousHash string     `auditor:"previoushash"`
+}
+
+func TestEncryptSubjectKey(t *testing.T) {
+	keys := &storetest.Keys{}
+	keyring := newKeyring()
+	keyring.Subjects = NewSubjectKeys(keys, testSecret)
+
+	block := &subjectBlock{Customer: "abc", Email: "john@example.com"}
+	err := Encrypt(keyring, block)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(block.Email, Prefix+keyring.Subjects.keyID("abc")+":"))
+	// subject is not saved, only its pseudonym
+	assert.Equal(t, keyring.Subjects.Pseudonym("abc"), block.Customer)
+	assert.True(t, strings.HasPrefix(block.Customer, PseudonymPrefix))
+	assert.NotContains(t, block.Email, base64.RawURLEncoding.EncodeToString([]byte("abc")))
+
+	// blocks without subject are encrypted using current key
+	other := &subjectBlock{Email: "jane@example.com"}
+	err = Encrypt(keyring, other)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(other.Email, Prefix+"k2:"))
+
+	// keys are shared by all instances which use the same key store
+	keyring.Subjects = NewSubjectKeys(keys, testSecret)
+	err = Decrypt(keyring, block)
+	assert.Nil(t, err)
+	assert.Equal(t, "john@example.com", block.Email)
+}
+
+func TestEncryptSubjectKeyCreatedConcurrently(t *testing.T) {
+	keys := &storetest.Keys{}
+	keyring := newKeyring()
+	keyring.Subjects = NewSubjectKeys(keys, testSecret)
+
+	// other instance created the key after this one found there was none
+	existing := make([]byte, keySize)
+	_, err := keys.CreateKey(keyring.Subjects.keyID("abc"), existing)
+	assert.Nil(t, err)
+	key, err := keyring.Subjects.key("abc", true)
+	assert.Nil(t, err)
+	assert.Equal(t, existing, key)
+}
+
+func TestEraseSubject(t *testing.T) {
+	keys := &storetest.Keys{}
+	keyring := newKeyring()
+	keyring.Subjects = NewSubjectKeys(keys, testSecret)
+
+	block := &subjectBlock{Customer: "abc", Email: "john@example.com"}
+	other := &subjectBlock{Customer: "def", Email: "jane@example.com"}
+	assert.Nil(t, Encrypt(keyring, block))
+	assert.Nil(t, Encrypt(keyring, other))
+
+	assert.Nil(t, keyring.Subjects.Erase("abc"))
+	// erasing unknown subject leaves tombstone too
+	assert.Nil(t, keyring.Subjects.Erase("xyz"))
+
+	// erasure is seen by all instances which use the same key store
+	keyring.Subjects = NewSubjectKeys(keys, testSecret)
+	assert.Nil(t, Decrypt(keyring, block))
+	assert.Equal(t, Erased, block.Email)
+	assert.Nil(t, Decrypt(keyring, other))
+	assert.Equal(t, "jane@example.com", other.Email)
+
+	// keys of erased subjects are not created again
+	assert.Equal(t, ErrSubjectErased, Encrypt(keyring, &subjectBlock{Customer: "xyz", Email: "john@example.com"}))
+}
+
+func TestDecryptSubjectKeyNotFound(t *testing.T) {
+	keyring := newKeyring()
+	keyring.Subjects = NewSubjectKeys(&storetest.Keys{}, testSecret)
+	block := &subjectBlock{Customer: "abc", Email: "john@example.com"}
+	assert.Nil(t, Encrypt(keyring, block))
+
+	// missing key is not treated as destroyed one
+	keyring.Subjects = NewSubjectKeys(&storetest.Keys{}, testSecret)
+	assert.Equal(t, "key "+keyring.Subjects.keyID("abc")+" not found in subject keys", Decrypt(keyring, block).Error())
+}
+
+func TestSubjectPseudonym(t *testing.T) {
+	subjects := NewSubjectKeys(&storetest.Keys{}, testSecret)
+	assert.Equal(t, subjects.Pseudonym("abc"), subjects.Pseudonym("abc"))
+	assert.NotEqual(t, subjects.Pseudonym("abc"), subjects.Pseudonym("abd"))
+	// pseudonyms cannot be computed without secret
+	other := NewSubjectKeys(&storetest.Keys{}, []byte("12345678901234567890123456789012"))
+	assert.NotEqual(t, subjects.Pseudonym("abc"), other.Pseudonym("abc"))
+
+	// blocks without encrypted fields are pseudonymised too
+	keyring := &Keyring{Subjects: subjects}
+	block := &subjectBlock{Customer: "abc"}
+	assert.Nil(t, Encrypt(keyring, block))
+	assert.Equal(t, subjects.Pseudonym("abc"), block.Customer)
+}
+
+func TestDecryptLegacySubjectKey(t *testing.T) {
+	keyring := newKeyring()
+	keyring.Subjects = NewSubjectKeys(&storetest.Keys{}, testSecret)
+	key, err := keyring.Subjects.key("abc", true)
+	assert.Nil(t, err)
+
+	// keys created before subjects were pseudonymised encode subject in key id
+	sealed, err := seal(subjectKeyPrefix+base64.RawURLEncoding.EncodeToString([]byte("abc")), key, "Email", "john@example.com")
+	assert.Nil(t, err)
+	block := &subjectBlock{Customer: "abc", Email: sealed}
+	assert.Nil(t, Decrypt(keyring, block))
+	assert.Equal(t, "john@example.com", block.Email)
+
+	assert.Nil(t, keyring.Subjects.Erase("abc"))
+	block.Email = sealed
+	assert.Nil(t, Decrypt(keyring, block))
+	assert.Equal(t, Erased, block.Email)
+}
+
+func TestSubjectSecretFromEnv(t *testing.T) {
+	defer os.Unsetenv("AUDITOR_SUBJECT_SECRET")
+	os.Unsetenv("AUDITOR_SUBJECT_SECRET")
+	_, err := SubjectSecretFromEnv()
+	assert.Equal(t, "subject keys require AUDITOR_SUBJECT_SECRET", err.Error())
+
+	dir, err := ioutil.TempDir("", "subjects")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := dir + "/subject.secret"
+	os.Setenv("AUDITOR_SUBJECT_SECRET", path)
+	assert.Nil(t, ioutil.WriteFile(path, []byte("short\n"), 0600))
+	_, err = SubjectSecretFromEnv()
+	assert.Equal(t, "secret must have at least 32 bytes", err.Error())
+
+	assert.Nil(t, ioutil.WriteFile(path, append(testSecret, '\n'), 0600))
+	secret, err := SubjectSecretFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, testSecret, secret)
+}
+
+func TestImportSubjectKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "subjects")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := dir + "/subjects.json"
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"abc":"MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE=","def":"MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE="}`), 0600))
+
+	keys := &storetest.Keys{}
+	subjects := NewSubjectKeys(keys, testSecret)
+	assert.Nil(t, subjects.Erase("def"))
+	count, err := subjects.Import(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	key, err := subjects.key("abc", false)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("01234567890123456789012345678901"), key)
+	// destroyed keys are not imported again
+	_, err = subjects.key("def", false)
+	assert.Equal(t, store.ErrKeyDestroyed, err)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"abc":"MDEy"}`), 0600))
+	_, err = subjects.Import(path)
+	assert.Equal(t, "key of subject abc must be 32 bytes long, but is 3", err.Error())
+}
+
+func TestDecryptSubjectKeysNotConfigured(t *testing.T) {
+	keyring := newKeyring()
+	keyring.Subjects = NewSubjectKeys(&storetest.Keys{}, testSecret)
+
+	block := &subjectBlock{Customer: "abc", Email: "john@example.com"}
+	assert.Nil(t, Encrypt(keyring, block))
+
+	keyring.Subjects = nil
+	assert.Equal(t, "subject keys are not configured", Decrypt(keyring, block).Error())
+}
+
+func TestStoreRedactNotSupported(t *testing.T) {
+	store := NewStore(&mockStore{}, newKeyring())
+	err := store.(interface {
+		Redact(block interface{}, fields []string) error
+	}).Redact(&testBlock{}, []string{"Email"})
+	assert.Equal(t, "redaction is not supported", err.Error())
+}
+
+func TestStoreFindNotSupported(t *testing.T) {
+	store := NewStore(&mockStore{}, newKeyring())
+	err := store.(interface {
+		FindByHash(block interface{}) error
+	}).FindByHash(&testBlock{Hash: "abc"})
+	assert.Equal(t, "finding blocks by hash is not supported", err.Error())
+}
+
+func TestStoreIdempotencyNotSupported(t *testing.T) {
+	store := NewStore(&mockStore{}, newKeyring())
+	err := store.(interface {
+		FindByIdempotencyKey(block interface{}) error
+	}).FindByIdempotencyKey(&testBlock{})
+	assert.Equal(t, "idempotency keys are not supported", err.Error())
+}
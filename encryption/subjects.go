@@ -0,0 +1,142 @@
+package encryption
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/lukaszbudnik/auditor/store"
+)
+
+const (
+	// subjectKeyPrefix is a prefix of ids of keys created before subjects were pseudonymised, they encode subject itself
+	subjectKeyPrefix   = "subject."
+	pseudonymKeyPrefix = "pseudonym."
+	// PseudonymPrefix is a prefix of values which replace subjects in saved blocks
+	PseudonymPrefix = "hmac:v1:"
+	// minSubjectSecretSize is the minimum size of secret used to compute pseudonyms of subjects
+	minSubjectSecretSize = 32
+	// SubjectsName is a name of collection (MongoDB) or table (DynamoDB) which holds subject keys
+	SubjectsName = "subjects"
+	// Erased is a value returned for encrypted fields whose subject key was destroyed
+	Erased = "[erased]"
+)
+
+// ErrSubjectErased is returned when block of erased subject is encrypted, destroyed subject keys are never created again
+var ErrSubjectErased = errors.New("data subject was erased")
+
+// SubjectKeys holds per-subject AES-256 keys in a key store shared by all auditor instances,
+// destroying subject key renders all fields encrypted with it unreadable,
+// subjects are replaced by pseudonyms (keyed hashes) both in saved blocks and in ids of their keys
+type SubjectKeys struct {
+	keys   store.KeyStore
+	secret []byte
+}
+
+// SubjectsEnabled returns true when AUDITOR_SUBJECT_KEYS is set
+func SubjectsEnabled() bool {
+	return len(os.Getenv("AUDITOR_SUBJECT_KEYS")) > 0
+}
+
+// SubjectSecretFromEnv loads secret used to compute pseudonyms of subjects from file set in AUDITOR_SUBJECT_SECRET,
+// all instances must use the same secret and it cannot be changed without losing access to subject keys
+func SubjectSecretFromEnv() ([]byte, error) {
+	path := os.Getenv("AUDITOR_SUBJECT_SECRET")
+	if len(path) == 0 {
+		return nil, errors.New("subject keys require AUDITOR_SUBJECT_SECRET")
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret := []byte(strings.TrimSpace(string(bytes)))
+	if len(secret) < minSubjectSecretSize {
+		return nil, fmt.Errorf("secret must have at least %v bytes", minSubjectSecretSize)
+	}
+	return secret, nil
+}
+
+// NewSubjectKeys creates SubjectKeys which keep keys in a given key store and compute pseudonyms using secret
+func NewSubjectKeys(keys store.KeyStore, secret []byte) *SubjectKeys {
+	return &SubjectKeys{keys: keys, secret: secret}
+}
+
+// Pseudonym returns value which replaces subject in saved blocks, it is the same for all blocks of subject
+// and cannot be reversed without secret
+func (s *SubjectKeys) Pseudonym(subject string) string {
+	return PseudonymPrefix + s.mac(subject)
+}
+
+func (s *SubjectKeys) mac(subject string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(subject))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// keyID returns id of subject key, key ids must not contain colons
+func (s *SubjectKeys) keyID(subject string) string {
+	return pseudonymKeyPrefix + s.mac(subject)
+}
+
+// key returns subject key, when create is true and there is no key for subject a new key is created,
+// returns store.ErrKeyNotFound or store.ErrKeyDestroyed when there is no key
+func (s *SubjectKeys) key(subject string, create bool) ([]byte, error) {
+	id := s.keyID(subject)
+	key, err := s.keys.GetKey(id)
+	if err != store.ErrKeyNotFound || !create {
+		return key, err
+	}
+	key = make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	// key created concurrently by other instance is returned instead
+	return s.keys.CreateKey(id, key)
+}
+
+// Erase destroys subject key, a tombstone is left even when subject has no key
+func (s *SubjectKeys) Erase(subject string) error {
+	return s.keys.DestroyKey(s.keyID(subject))
+}
+
+// Import copies subject keys from JSON file in which they were kept before they were moved to key store, keys are saved under pseudonyms,
+// keys which already exist are kept, returns number of keys in the file
+func (s *SubjectKeys) Import(path string) (int, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	keys := make(map[string][]byte)
+	if err := json.Unmarshal(bytes, &keys); err != nil {
+		return 0, err
+	}
+	for subject, key := range keys {
+		if len(key) != keySize {
+			return 0, fmt.Errorf("key of subject %v must be %v bytes long, but is %v", subject, keySize, len(key))
+		}
+		if _, err := s.keys.CreateKey(s.keyID(subject), key); err != nil && err != store.ErrKeyDestroyed {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// subjectFromKeyID decodes subject from id of key created before subjects were pseudonymised
+func subjectFromKeyID(id string) (string, bool) {
+	if !strings.HasPrefix(id, subjectKeyPrefix) {
+		return "", false
+	}
+	subject, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(id, subjectKeyPrefix))
+	if err != nil {
+		return "", false
+	}
+	return string(subject), true
+}
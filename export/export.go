@@ -0,0 +1,231 @@
+package export
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/witness"
+)
+
+// Name is a path segment of export endpoint, it cannot be used as a name of block type
+const Name = "export"
+
+// Format is a format of exported blocks
+type Format string
+
+const (
+	// NDJSON writes every block as JSON object on a separate line, this is the default
+	NDJSON Format = "ndjson"
+	// JSONLines is the same as NDJSON but is served with application/jsonl media type
+	JSONLines Format = "jsonl"
+	// CSV writes header row with field names and a row for every block, trailer is written as a comment line
+	CSV Format = "csv"
+)
+
+// ParseFormat returns format of a given name, NDJSON when name is empty
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case "":
+		return NDJSON, nil
+	case NDJSON, JSONLines, CSV:
+		return Format(name), nil
+	}
+	return "", fmt.Errorf("unknown export format: %v", name)
+}
+
+// ContentType returns media type of format
+func (f Format) ContentType() string {
+	switch f {
+	case JSONLines:
+		return "application/jsonl"
+	case CSV:
+		return "text/csv"
+	default:
+		return "application/x-ndjson"
+	}
+}
+
+// Trailer ends every complete export, blocks are exported newest first so Head is hash of the first exported block (or skipped block),
+// Count is number of exported blocks, Skipped is number of skipped blocks, Signature is optional
+type Trailer struct {
+	Head      string
+	Count     int64
+	Skipped   int64 `json:",omitempty"`
+	Timestamp time.Time
+	Signature string `json:",omitempty"`
+}
+
+// Skipped is written instead of a block which is not exported (it is not readable by principal or belongs to other partition),
+// it keeps the chain of exported blocks verifiable
+type Skipped struct {
+	Hash         string
+	PreviousHash string
+}
+
+func (t *Trailer) message() []byte {
+	message := fmt.Sprintf("auditor-export\n%v\n%v\n%v", t.Head, t.Count, t.Timestamp.UTC().Format(time.RFC3339Nano))
+	// exports without skipped blocks are signed the same way as before skipped blocks were introduced
+	if t.Skipped > 0 {
+		message = fmt.Sprintf("%v\n%v", message, t.Skipped)
+	}
+	return []byte(message)
+}
+
+// Sign signs trailer
+func Sign(key ed25519.PrivateKey, trailer *Trailer) {
+	trailer.Timestamp = trailer.Timestamp.UTC()
+	trailer.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, trailer.message()))
+}
+
+// VerifySignature verifies trailer signature
+func VerifySignature(key ed25519.PublicKey, trailer *Trailer) error {
+	if len(trailer.Signature) == 0 {
+		return errors.New("trailer is not signed")
+	}
+	signature, err := base64.StdEncoding.DecodeString(trailer.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err.Error())
+	}
+	if !ed25519.Verify(key, trailer.message(), signature) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// KeyFromEnv loads Ed25519 key used to sign trailers from AUDITOR_EXPORT_KEY, returns nil when it is not set
+func KeyFromEnv() (ed25519.PrivateKey, error) {
+	path := os.Getenv("AUDITOR_EXPORT_KEY")
+	if len(path) == 0 {
+		return nil, nil
+	}
+	return witness.LoadPrivateKey(path)
+}
+
+// Writer writes exported blocks, Skip writes hashes of a block which is not exported,
+// Close writes trailer and must be called only when all blocks were written
+type Writer interface {
+	Write(block interface{}) error
+	Skip(skipped *Skipped) error
+	Close(trailer *Trailer) error
+}
+
+// NewWriter creates Writer which writes blocks of blockType in a given format
+func NewWriter(w io.Writer, format Format, blockType reflect.Type) Writer {
+	if format == CSV {
+		return &csvWriter{out: w, csv: csv.NewWriter(w), blockType: blockType}
+	}
+	return &jsonWriter{encoder: json.NewEncoder(w)}
+}
+
+type jsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (j *jsonWriter) Write(block interface{}) error {
+	return j.encoder.Encode(block)
+}
+
+func (j *jsonWriter) Skip(skipped *Skipped) error {
+	return j.encoder.Encode(struct{ Skipped *Skipped }{skipped})
+}
+
+func (j *jsonWriter) Close(trailer *Trailer) error {
+	return j.encoder.Encode(struct{ Trailer *Trailer }{trailer})
+}
+
+type csvWriter struct {
+	out       io.Writer
+	csv       *csv.Writer
+	blockType reflect.Type
+	header    bool
+}
+
+// columns returns exported fields of block type, unexported fields are not serialized to JSON either
+func (c *csvWriter) columns() []reflect.StructField {
+	fields := []reflect.StructField{}
+	for i := 0; i < c.blockType.NumField(); i++ {
+		if field := c.blockType.Field(i); field.PkgPath == "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func (c *csvWriter) writeHeader() error {
+	if c.header {
+		return nil
+	}
+	c.header = true
+	names := []string{}
+	for _, field := range c.columns() {
+		names = append(names, field.Name)
+	}
+	return c.csv.Write(names)
+}
+
+// Write writes block as a row, strings are written as is, times as RFC3339Nano timestamps and other values as JSON
+func (c *csvWriter) Write(block interface{}) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	blockv := reflect.ValueOf(block).Elem()
+	row := []string{}
+	for _, field := range c.columns() {
+		value, err := csvValue(blockv.FieldByIndex(field.Index))
+		if err != nil {
+			return err
+		}
+		row = append(row, value)
+	}
+	return c.csv.Write(row)
+}
+
+func csvValue(value reflect.Value) (string, error) {
+	switch v := value.Interface().(type) {
+	case string:
+		return v, nil
+	case *time.Time:
+		if v == nil {
+			return "", nil
+		}
+		return v.Format(time.RFC3339Nano), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	}
+	bytes, err := json.Marshal(value.Interface())
+	return string(bytes), err
+}
+
+// Skip writes skipped block as JSON in a comment line starting with #
+func (c *csvWriter) Skip(skipped *Skipped) error {
+	return c.comment(struct{ Skipped *Skipped }{skipped})
+}
+
+// Close writes trailer as JSON in a comment line starting with #
+func (c *csvWriter) Close(trailer *Trailer) error {
+	return c.comment(trailer)
+}
+
+func (c *csvWriter) comment(value interface{}) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.csv.Flush()
+	if err := c.csv.Error(); err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.out, "# %s\n", bytes)
+	return err
+}
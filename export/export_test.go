@@ -0,0 +1,192 @@
+package export
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/csv"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// newChain returns blocks newest first, the way they are exported
+func newChain(n int) []*model.Block {
+	blocks := []*model.Block{}
+	previousHash := ""
+	for i := 0; i < n; i++ {
+		timestamp := time.Date(2019, 1, 1, 12, i, 0, 0, time.UTC)
+		block := &model.Block{Customer: "abc", Timestamp: &timestamp, Category: "login", Event: "user, \"quoted\"", PreviousHash: previousHash}
+		model.ComputeAndSetHash(block)
+		previousHash = block.Hash
+		blocks = append([]*model.Block{block}, blocks...)
+	}
+	return blocks
+}
+
+func write(t *testing.T, format Format, blocks []*model.Block, key ed25519.PrivateKey) string {
+	buffer := &bytes.Buffer{}
+	writer := NewWriter(buffer, format, reflect.TypeOf(model.Block{}))
+	trailer := &Trailer{Count: int64(len(blocks)), Timestamp: time.Now()}
+	for _, block := range blocks {
+		assert.Nil(t, writer.Write(block))
+	}
+	if len(blocks) > 0 {
+		trailer.Head = blocks[0].Hash
+	}
+	if key != nil {
+		Sign(key, trailer)
+	}
+	assert.Nil(t, writer.Close(trailer))
+	return buffer.String()
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, expected := range map[string]Format{"": NDJSON, "ndjson": NDJSON, "jsonl": JSONLines, "csv": CSV} {
+		format, err := ParseFormat(name)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, format)
+	}
+	_, err := ParseFormat("xml")
+	assert.Equal(t, "unknown export format: xml", err.Error())
+	assert.Equal(t, "application/jsonl", JSONLines.ContentType())
+}
+
+func TestVerify(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	blocks := newChain(3)
+
+	for _, format := range []Format{NDJSON, JSONLines} {
+		export := write(t, format, blocks, private)
+		lines := strings.Split(strings.TrimSpace(export), "\n")
+		assert.Len(t, lines, 4)
+		assert.True(t, strings.HasPrefix(lines[3], `{"Trailer":{"Head":"`+blocks[0].Hash+`","Count":3,`))
+
+		trailer, err := Verify(strings.NewReader(export), reflect.TypeOf(model.Block{}), public)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(3), trailer.Count)
+		assert.Equal(t, blocks[0].Hash, trailer.Head)
+	}
+
+	// empty export
+	trailer, err := Verify(strings.NewReader(write(t, NDJSON, nil, nil)), reflect.TypeOf(model.Block{}), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), trailer.Count)
+}
+
+func TestVerifyTimes(t *testing.T) {
+	blockType := reflect.TypeOf(model.Block{})
+	// server and clients save blocks with local times with nanoseconds, export decodes them in UTC or with offset
+	for _, location := range []*time.Location{time.Local, time.FixedZone("CET", 3600)} {
+		blocks := []*model.Block{}
+		previousHash := ""
+		for i := 0; i < 3; i++ {
+			timestamp := time.Now().In(location)
+			block := &model.Block{Customer: "abc", Timestamp: &timestamp, Category: "login", Event: "user", PreviousHash: previousHash}
+			model.ComputeAndSetHash(block)
+			previousHash = block.Hash
+			blocks = append([]*model.Block{block}, blocks...)
+		}
+		trailer, err := Verify(strings.NewReader(write(t, NDJSON, blocks, nil)), blockType, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(3), trailer.Count)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	blocks := newChain(3)
+	export := write(t, NDJSON, blocks, private)
+	lines := strings.Split(strings.TrimSpace(export), "\n")
+	blockType := reflect.TypeOf(model.Block{})
+
+	tampered := map[string]string{
+		strings.Join(lines[:3], "\n"):                                 "trailer not found, export is incomplete",
+		strings.Join([]string{lines[0], lines[2], lines[3]}, "\n"):    "does not match previous hash",
+		strings.Replace(export, `"Event":"user`, `"Event":"admin`, 1): "does not match its content",
+		strings.Join([]string{lines[0], lines[1], lines[3]}, "\n"):    "trailer count 3 does not match number of exported blocks 2",
+		strings.Join([]string{lines[1], lines[2], lines[3]}, "\n"):    "trailer count 3 does not match number of exported blocks 2",
+		export + lines[0]: "blocks found after trailer",
+	}
+	for content, message := range tampered {
+		_, err := Verify(strings.NewReader(content), blockType, public)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), message)
+	}
+
+	_, err := Verify(strings.NewReader(export), blockType, other)
+	assert.Equal(t, "invalid signature", err.Error())
+	_, err = Verify(strings.NewReader(write(t, NDJSON, blocks, nil)), blockType, public)
+	assert.Equal(t, "trailer is not signed", err.Error())
+}
+
+func TestCSV(t *testing.T) {
+	blocks := newChain(2)
+	export := write(t, CSV, blocks, nil)
+
+	reader := csv.NewReader(strings.NewReader(export))
+	reader.Comment = '#'
+	rows, err := reader.ReadAll()
+	assert.Nil(t, err)
+	assert.Len(t, rows, 3)
+	assert.Equal(t, []string{"Customer", "Timestamp", "Category", "Subcategory", "Event", "Hash", "PreviousHash"}, rows[0])
+	assert.Equal(t, []string{"abc", "2019-01-01T12:01:00Z", "login", "", "user, \"quoted\"", blocks[0].Hash, blocks[1].Hash}, rows[1])
+
+	lines := strings.Split(strings.TrimSpace(export), "\n")
+	assert.True(t, strings.HasPrefix(lines[len(lines)-1], `# {"Head":"`+blocks[0].Hash+`","Count":2,`))
+
+	// header is written for empty export too
+	assert.True(t, strings.HasPrefix(write(t, CSV, nil, nil), "Customer,Timestamp,Category,Subcategory,Event,Hash,PreviousHash\n# {"))
+}
+
+func TestRecomputesHashes(t *testing.T) {
+	assert.True(t, RecomputesHashes(reflect.TypeOf(model.Block{})))
+	assert.False(t, RecomputesHashes(reflect.TypeOf(struct {
+		Timestamp    *time.Time `auditor:"sort"`
+		Email        string     `auditor:"encrypt"`
+		Hash         string     `auditor:"hash"`
+		PreviousHash string     `auditor:"previoushash"`
+	}{})))
+}
+
+func TestVerifySkipped(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	blocks := newChain(3)
+	blockType := reflect.TypeOf(model.Block{})
+
+	for _, format := range []Format{NDJSON, CSV} {
+		buffer := &bytes.Buffer{}
+		writer := NewWriter(buffer, format, blockType)
+		assert.Nil(t, writer.Write(blocks[0]))
+		assert.Nil(t, writer.Skip(&Skipped{Hash: blocks[1].Hash, PreviousHash: blocks[1].PreviousHash}))
+		assert.Nil(t, writer.Write(blocks[2]))
+		trailer := &Trailer{Head: blocks[0].Hash, Count: 2, Skipped: 1, Timestamp: time.Now()}
+		Sign(private, trailer)
+		assert.Nil(t, writer.Close(trailer))
+
+		if format == CSV {
+			assert.Contains(t, buffer.String(), "\n# {\"Skipped\":{\"Hash\":\""+blocks[1].Hash+"\"")
+			continue
+		}
+		export := buffer.String()
+		trailer, err := Verify(strings.NewReader(export), blockType, public)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), trailer.Count)
+		assert.Equal(t, int64(1), trailer.Skipped)
+
+		// skipped block must still link exported blocks
+		tampered := strings.Replace(export, `"PreviousHash":"`+blocks[1].PreviousHash+`"}}`, `"PreviousHash":"abc"}}`, 1)
+		_, err = Verify(strings.NewReader(tampered), blockType, public)
+		assert.Contains(t, err.Error(), "does not match previous hash abc")
+		// number of skipped blocks is signed
+		tampered = strings.Replace(export, `"Skipped":1,`, `"Skipped":2,`, 1)
+		_, err = Verify(strings.NewReader(tampered), blockType, public)
+		assert.Equal(t, "trailer skipped 2 does not match number of skipped blocks 1", err.Error())
+	}
+}
@@ -0,0 +1,102 @@
+package export
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/lukaszbudnik/auditor/model"
+)
+
+// RecomputesHashes returns false when Verify cannot check hashes of blocks of blockType against their contents:
+// hashes of blocks with encrypted fields are computed over ciphertext while exports contain decrypted blocks
+func RecomputesHashes(blockType reflect.Type) bool {
+	return len(model.SchemaOf(blockType).FieldsTaggedWith("encrypt")) == 0
+}
+
+// Verify reads NDJSON or JSON Lines export of blocks of blockType and checks that every block (or skipped block) is linked to the next one,
+// that block hashes match block contents and that trailer matches exported blocks, when key is not nil trailer signature
+// is verified too, when RecomputesHashes returns false only linkage of blocks is checked
+func Verify(r io.Reader, blockType reflect.Type, key ed25519.PublicKey) (*Trailer, error) {
+	schema := model.SchemaOf(blockType)
+	recompute := RecomputesHashes(blockType)
+
+	decoder := json.NewDecoder(r)
+	var trailer *Trailer
+	var head, previousHash string
+	// position counts exported and skipped blocks
+	var count, skipped, position int64
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if trailer != nil {
+			return nil, errors.New("blocks found after trailer")
+		}
+		end := struct {
+			Trailer *Trailer
+			Skipped *Skipped
+		}{}
+		if err := json.Unmarshal(raw, &end); err == nil && end.Trailer != nil {
+			trailer = end.Trailer
+			continue
+		}
+
+		// skipped blocks cannot be checked against their content, they only link exported blocks
+		var hash, next string
+		if end.Skipped != nil {
+			hash, next = end.Skipped.Hash, end.Skipped.PreviousHash
+		} else {
+			block := reflect.New(blockType).Interface()
+			if err := json.Unmarshal(raw, block); err != nil {
+				return nil, fmt.Errorf("block %v: %v", position+1, err.Error())
+			}
+			hash, next = schema.Hash(block), schema.PreviousHash(block)
+			if recompute {
+				if err := model.VerifyHash(block); err != nil {
+					return nil, fmt.Errorf("block %v: hash %v does not match its content", position+1, hash)
+				}
+			}
+		}
+		// blocks are exported newest first
+		if position == 0 {
+			head = hash
+		} else if previousHash != hash {
+			return nil, fmt.Errorf("block %v: hash %v does not match previous hash %v of block %v", position+1, hash, previousHash, position)
+		}
+		previousHash = next
+		position++
+		if end.Skipped != nil {
+			skipped++
+		} else {
+			count++
+		}
+	}
+
+	if trailer == nil {
+		return nil, errors.New("trailer not found, export is incomplete")
+	}
+	if trailer.Count != count {
+		return nil, fmt.Errorf("trailer count %v does not match number of exported blocks %v", trailer.Count, count)
+	}
+	if trailer.Skipped != skipped {
+		return nil, fmt.Errorf("trailer skipped %v does not match number of skipped blocks %v", trailer.Skipped, skipped)
+	}
+	if trailer.Head != head {
+		return nil, fmt.Errorf("trailer head %v does not match hash of the first exported block %v", trailer.Head, head)
+	}
+	if key != nil {
+		if err := VerifySignature(key, trailer); err != nil {
+			return nil, err
+		}
+	}
+	return trailer, nil
+}
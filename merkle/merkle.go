@@ -0,0 +1,162 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+const (
+	leafPrefix byte = 0x00
+	nodePrefix byte = 0x01
+)
+
+// LeafHash computes RFC 6962 leaf hash: SHA-256(0x00 || data)
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// NodeHash computes RFC 6962 interior node hash: SHA-256(0x01 || left || right)
+func NodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// split returns the largest power of two smaller than n
+func split(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// Root computes Merkle Tree Hash (MTH) of passed leaves data
+func Root(leaves [][]byte) []byte {
+	n := len(leaves)
+	if n == 0 {
+		h := sha256.Sum256(nil)
+		return h[:]
+	}
+	if n == 1 {
+		return LeafHash(leaves[0])
+	}
+	k := split(n)
+	return NodeHash(Root(leaves[:k]), Root(leaves[k:]))
+}
+
+// InclusionProof computes audit path for leaf at given index in the tree built from passed leaves data
+func InclusionProof(index int, leaves [][]byte) ([][]byte, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, fmt.Errorf("leaf index %v out of range, tree size: %v", index, len(leaves))
+	}
+	return path(index, leaves), nil
+}
+
+func path(m int, leaves [][]byte) [][]byte {
+	n := len(leaves)
+	if n <= 1 {
+		return [][]byte{}
+	}
+	k := split(n)
+	if m < k {
+		return append(path(m, leaves[:k]), Root(leaves[k:]))
+	}
+	return append(path(m-k, leaves[k:]), Root(leaves[:k]))
+}
+
+// VerifyInclusion verifies that leaf data at given index is included in the tree of given size and root
+func VerifyInclusion(leaf []byte, index, size int, proof [][]byte, root []byte) bool {
+	if index < 0 || index >= size {
+		return false
+	}
+	hash := LeafHash(leaf)
+	fn, sn := index, size-1
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			hash = NodeHash(p, hash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			hash = NodeHash(hash, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(hash, root)
+}
+
+// ConsistencyProof computes RFC 6962 consistency proof between tree of size m and tree built from passed leaves data
+func ConsistencyProof(m int, leaves [][]byte) ([][]byte, error) {
+	if m <= 0 || m > len(leaves) {
+		return nil, fmt.Errorf("tree size %v out of range, tree size: %v", m, len(leaves))
+	}
+	return subproof(m, leaves, true), nil
+}
+
+func subproof(m int, leaves [][]byte, complete bool) [][]byte {
+	n := len(leaves)
+	if m == n {
+		if complete {
+			return [][]byte{}
+		}
+		return [][]byte{Root(leaves)}
+	}
+	k := split(n)
+	if m <= k {
+		return append(subproof(m, leaves[:k], complete), Root(leaves[k:]))
+	}
+	return append(subproof(m-k, leaves[k:], false), Root(leaves[:k]))
+}
+
+// VerifyConsistency verifies that tree of size first and root firstRoot is a prefix of tree of size second and root secondRoot
+func VerifyConsistency(first, second int, proof [][]byte, firstRoot, secondRoot []byte) bool {
+	if first <= 0 || first > second {
+		return false
+	}
+	if first == second {
+		return len(proof) == 0 && bytes.Equal(firstRoot, secondRoot)
+	}
+	// when first tree is a complete subtree its root is the first node of the proof
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return false
+	}
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, p := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = NodeHash(p, fr)
+			sr = NodeHash(p, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = NodeHash(sr, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(fr, firstRoot) && bytes.Equal(sr, secondRoot)
+}
@@ -0,0 +1,108 @@
+package merkle
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// test vectors from RFC 6962 reference implementation (certificate-transparency)
+var testLeaves = [][]byte{
+	{},
+	{0x00},
+	{0x10},
+	{0x20, 0x21},
+	{0x30, 0x31},
+	{0x40, 0x41, 0x42, 0x43},
+	{0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57},
+	{0x60, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f},
+}
+
+func TestRootEmpty(t *testing.T) {
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", hex.EncodeToString(Root(nil)))
+}
+
+func TestRoot(t *testing.T) {
+	assert.Equal(t, "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d", hex.EncodeToString(Root(testLeaves[:1])))
+	assert.Equal(t, "5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328", hex.EncodeToString(Root(testLeaves)))
+}
+
+func TestInclusionProof(t *testing.T) {
+	for size := 1; size <= len(testLeaves); size++ {
+		leaves := testLeaves[:size]
+		root := Root(leaves)
+		for index := 0; index < size; index++ {
+			proof, err := InclusionProof(index, leaves)
+			assert.Nil(t, err)
+			assert.True(t, VerifyInclusion(leaves[index], index, size, proof, root), fmt.Sprintf("size %v index %v", size, index))
+		}
+	}
+}
+
+func TestInclusionProofOutOfRange(t *testing.T) {
+	_, err := InclusionProof(3, testLeaves[:3])
+	assert.Equal(t, "leaf index 3 out of range, tree size: 3", err.Error())
+}
+
+func TestVerifyInclusionTampered(t *testing.T) {
+	root := Root(testLeaves)
+	proof, err := InclusionProof(5, testLeaves)
+	assert.Nil(t, err)
+	// wrong leaf
+	assert.False(t, VerifyInclusion(testLeaves[4], 5, len(testLeaves), proof, root))
+	// wrong index
+	assert.False(t, VerifyInclusion(testLeaves[5], 4, len(testLeaves), proof, root))
+	// wrong size
+	assert.False(t, VerifyInclusion(testLeaves[5], 5, len(testLeaves)+1, proof, root))
+	// truncated proof
+	assert.False(t, VerifyInclusion(testLeaves[5], 5, len(testLeaves), proof[1:], root))
+}
+
+func TestConsistencyProof(t *testing.T) {
+	for second := 1; second <= len(testLeaves); second++ {
+		secondRoot := Root(testLeaves[:second])
+		for first := 1; first <= second; first++ {
+			firstRoot := Root(testLeaves[:first])
+			proof, err := ConsistencyProof(first, testLeaves[:second])
+			assert.Nil(t, err)
+			assert.True(t, VerifyConsistency(first, second, proof, firstRoot, secondRoot), fmt.Sprintf("first %v second %v", first, second))
+		}
+	}
+}
+
+func TestConsistencyProofKnownSizes(t *testing.T) {
+	// RFC 6962 section 2.1.3 examples: PROOF(3, D[7]) = [c, d, g, l], PROOF(4, D[7]) = [l], PROOF(6, D[7]) = [i, j, k]
+	proof, _ := ConsistencyProof(3, testLeaves[:7])
+	assert.Len(t, proof, 4)
+	proof, _ = ConsistencyProof(4, testLeaves[:7])
+	assert.Len(t, proof, 1)
+	assert.Equal(t, Root(testLeaves[4:7]), proof[0])
+	proof, _ = ConsistencyProof(6, testLeaves[:7])
+	assert.Len(t, proof, 3)
+}
+
+func TestConsistencyProofOutOfRange(t *testing.T) {
+	_, err := ConsistencyProof(0, testLeaves)
+	assert.Equal(t, "tree size 0 out of range, tree size: 8", err.Error())
+	_, err = ConsistencyProof(9, testLeaves)
+	assert.Equal(t, "tree size 9 out of range, tree size: 8", err.Error())
+}
+
+func TestVerifyConsistencyRewritten(t *testing.T) {
+	rewritten := append([][]byte{}, testLeaves...)
+	rewritten[2] = []byte{0x11}
+
+	firstRoot := Root(testLeaves[:5])
+	proof, err := ConsistencyProof(5, rewritten)
+	assert.Nil(t, err)
+	assert.False(t, VerifyConsistency(5, len(rewritten), proof, firstRoot, Root(rewritten)))
+
+	// sizes out of order
+	proof, err = ConsistencyProof(5, testLeaves)
+	assert.Nil(t, err)
+	assert.False(t, VerifyConsistency(8, 5, proof, Root(testLeaves), firstRoot))
+	// truncated proof
+	assert.False(t, VerifyConsistency(5, 8, proof[1:], firstRoot, Root(testLeaves)))
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Ignored is a name returned for fields which are not stored by a backend
+const Ignored = "-"
+
+// BSONName returns name under which mgo stores field in MongoDB document:
+// name from bson tag or lowercased field name
+func BSONName(field reflect.StructField) string {
+	if name := tagName(field.Tag.Get("bson")); len(name) > 0 {
+		return name
+	}
+	return strings.ToLower(field.Name)
+}
+
+// DynamoDBName returns name under which dynamodbattribute stores field in DynamoDB item:
+// name from dynamodbav tag, json tag when dynamodbav tag is not set, or field name
+func DynamoDBName(field reflect.StructField) string {
+	tag := field.Tag.Get("dynamodbav")
+	if len(tag) == 0 {
+		tag = field.Tag.Get("json")
+	}
+	if name := tagName(tag); len(name) > 0 {
+		return name
+	}
+	return field.Name
+}
+
+func tagName(tag string) string {
+	return strings.Split(tag, ",")[0]
+}
+
+// Stored returns errors for all fields tagged with tag which are not stored by a backend,
+// name resolves stored name of a field, for example BSONName or DynamoDBName
+func Stored(t reflect.Type, tag string, name func(reflect.StructField) string) []error {
+	errors := []error{}
+	for _, field := range GetTypeFieldsTaggedWith(t, tag) {
+		if name(field) == Ignored {
+			errors = append(errors, fmt.Errorf("field %v tagged with '%v' must not be ignored by store", field.Name, tag))
+		}
+	}
+	return errors
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type renamedBlock struct {
+	Customer     string     `auditor:"dynamodb_partition" dynamodbav:"pk" bson:"customer_id"`
+	Timestamp    *time.Time `auditor:"sort" dynamodbav:",omitempty" json:"ts" bson:"ts,omitempty"`
+	Event        string     `json:"event"`
+	Category     string     `dynamodbav:"-" bson:"-"`
+	Hash         string     `auditor:"hash"`
+	PreviousHash string     `auditor:"previoushash"`
+}
+
+func TestStoredNames(t *testing.T) {
+	blockType := reflect.TypeOf(renamedBlock{})
+	expected := map[string][]string{
+		"Customer":     {"customer_id", "pk"},
+		"Timestamp":    {"ts", "Timestamp"},
+		"Event":        {"event", "event"},
+		"Category":     {Ignored, Ignored},
+		"PreviousHash": {"previoushash", "PreviousHash"},
+	}
+	for name, names := range expected {
+		field, _ := blockType.FieldByName(name)
+		assert.Equal(t, names[0], BSONName(field), name)
+		assert.Equal(t, names[1], DynamoDBName(field), name)
+	}
+}
+
+func TestStored(t *testing.T) {
+	blockType := reflect.TypeOf(renamedBlock{})
+	assert.Empty(t, Stored(blockType, "sort", BSONName))
+	s := struct {
+		Hash string `auditor:"hash" bson:"-"`
+	}{}
+	errors := Stored(reflect.TypeOf(s), "hash", BSONName)
+	assert.Equal(t, "field Hash tagged with 'hash' must not be ignored by store", errors[0].Error())
+}
@@ -0,0 +1,183 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BlockSchema holds auditor metadata of a block type, it is compiled once per type and cached
+type BlockSchema struct {
+	Type         reflect.Type
+	tagged       map[string][]reflect.StructField
+	hash         int
+	previousHash int
+	sort         int
+	partition    int
+	clientTime   int
+	skewed       int
+	principal    int
+	idempotency  int
+	fingerprint  int
+	// hashType is set when block has fields which gob cannot serialize deterministically
+	hashType     reflect.Type
+	hashFields   []int
+	canonical    []bool
+	hasCanonical bool
+	// times holds indexes of time fields, they are hashed in UTC at millisecond precision
+	times []int
+}
+
+var blockSchemas sync.Map
+
+// SchemaOf returns compiled schema of a struct type
+func SchemaOf(t reflect.Type) *BlockSchema {
+	if schema, ok := blockSchemas.Load(t); ok {
+		return schema.(*BlockSchema)
+	}
+	schema, _ := blockSchemas.LoadOrStore(t, compileSchema(t))
+	return schema.(*BlockSchema)
+}
+
+// SchemaFor returns compiled schema of block, block must be a pointer to struct
+func SchemaFor(block interface{}) *BlockSchema {
+	validateBlock(block)
+	return SchemaOf(reflect.TypeOf(block).Elem())
+}
+
+func compileSchema(t reflect.Type) *BlockSchema {
+	schema := &BlockSchema{Type: t, tagged: make(map[string][]reflect.StructField)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		for _, tag := range strings.Split(field.Tag.Get("auditor"), ",") {
+			if len(tag) > 0 {
+				schema.tagged[tag] = append(schema.tagged[tag], field)
+			}
+		}
+	}
+	schema.hash = schema.index("hash")
+	schema.previousHash = schema.index("previoushash")
+	schema.sort = schema.index("sort")
+	schema.partition = schema.index("dynamodb_partition")
+	schema.clientTime = schema.index("clienttime")
+	schema.skewed = schema.index("skewed")
+	schema.principal = schema.index("principal")
+	schema.idempotency = schema.index("idempotencykey")
+	schema.fingerprint = schema.index("fingerprint")
+	schema.compileHashType()
+	return schema
+}
+
+// index returns index of the first field tagged with tag or -1
+func (s *BlockSchema) index(tag string) int {
+	if fields := s.tagged[tag]; len(fields) > 0 {
+		return fields[0].Index[0]
+	}
+	return -1
+}
+
+// FieldsTaggedWith returns fields tagged with a specific auditor value, returned slice must not be modified
+func (s *BlockSchema) FieldsTaggedWith(tag string) []reflect.StructField {
+	if fields, ok := s.tagged[tag]; ok {
+		return fields
+	}
+	return []reflect.StructField{}
+}
+
+// HashField returns field tagged with hash
+func (s *BlockSchema) HashField() reflect.StructField {
+	return s.Type.Field(s.hash)
+}
+
+// SortField returns field tagged with sort
+func (s *BlockSchema) SortField() reflect.StructField {
+	return s.Type.Field(s.sort)
+}
+
+// HasPartition returns true if block type has field tagged with dynamodb_partition
+func (s *BlockSchema) HasPartition() bool {
+	return s.partition >= 0
+}
+
+// PartitionField returns field tagged with dynamodb_partition
+func (s *BlockSchema) PartitionField() reflect.StructField {
+	return s.Type.Field(s.partition)
+}
+
+// Indexes returns fields tagged with mongodb_index
+func (s *BlockSchema) Indexes() []reflect.StructField {
+	return s.FieldsTaggedWith("mongodb_index")
+}
+
+func (s *BlockSchema) field(block interface{}, index int) reflect.Value {
+	v := reflect.ValueOf(block)
+	if v.Kind() != reflect.Ptr || v.Type().Elem() != s.Type {
+		panic(fmt.Sprintf("block must be a pointer to %v, but got: %v", s.Type, v.Type()))
+	}
+	return v.Elem().Field(index)
+}
+
+// Hash returns value of hash field
+func (s *BlockSchema) Hash(block interface{}) string {
+	return s.field(block, s.hash).String()
+}
+
+// SetHash sets value of hash field
+func (s *BlockSchema) SetHash(block interface{}, hash string) {
+	s.field(block, s.hash).SetString(hash)
+}
+
+// PreviousHash returns value of previoushash field
+func (s *BlockSchema) PreviousHash(block interface{}) string {
+	return s.field(block, s.previousHash).String()
+}
+
+// SetPreviousHash sets value of previoushash field
+func (s *BlockSchema) SetPreviousHash(block interface{}, previousHash string) {
+	s.field(block, s.previousHash).SetString(previousHash)
+}
+
+// Sort returns value of sort field
+func (s *BlockSchema) Sort(block interface{}) interface{} {
+	return s.field(block, s.sort).Interface()
+}
+
+// SetSort sets value of sort field
+func (s *BlockSchema) SetSort(block interface{}, value interface{}) {
+	s.field(block, s.sort).Set(reflect.ValueOf(value))
+}
+
+// HasSort returns true if sort field is set, zero value (nil time, 0, empty string) marks the beginning of the chain
+func (s *BlockSchema) HasSort(block interface{}) bool {
+	return !s.field(block, s.sort).IsZero()
+}
+
+// ParseSort converts string to a value of sort field type,
+// time is parsed as RFC3339Nano timestamp and int64 as a decimal number
+func (s *BlockSchema) ParseSort(value string) (interface{}, error) {
+	switch s.SortField().Type {
+	case timeType:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, err
+		}
+		return &t, nil
+	case int64Type:
+		return strconv.ParseInt(value, 10, 64)
+	default:
+		return value, nil
+	}
+}
+
+// Partition returns value of dynamodb_partition field
+func (s *BlockSchema) Partition(block interface{}) interface{} {
+	return s.field(block, s.partition).Interface()
+}
+
+// SetPartition sets value of dynamodb_partition field
+func (s *BlockSchema) SetPartition(block interface{}, value interface{}) {
+	s.field(block, s.partition).Set(reflect.ValueOf(value))
+}
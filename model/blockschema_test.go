@@ -0,0 +1,132 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type partitionedBlock struct {
+	Customer     string     `auditor:"dynamodb_partition,mongodb_index"`
+	Timestamp    *time.Time `auditor:"sort,mongodb_index"`
+	Event        string
+	Hash         string `auditor:"hash"`
+	PreviousHash string `auditor:"previoushash"`
+}
+
+func TestSchemaOf(t *testing.T) {
+	schema := SchemaOf(reflect.TypeOf(partitionedBlock{}))
+	// schemas are cached
+	assert.True(t, schema == SchemaFor(&partitionedBlock{}))
+
+	assert.Equal(t, "Hash", schema.HashField().Name)
+	assert.Equal(t, "Timestamp", schema.SortField().Name)
+	assert.True(t, schema.HasPartition())
+	assert.Equal(t, "Customer", schema.PartitionField().Name)
+	assert.Len(t, schema.Indexes(), 2)
+	assert.Len(t, schema.FieldsTaggedWith("encrypt"), 0)
+	assert.False(t, SchemaFor(&testBlock{}).HasPartition())
+}
+
+func TestSchemaGettersAndSetters(t *testing.T) {
+	schema := SchemaFor(&partitionedBlock{})
+	block := &partitionedBlock{}
+	now := time.Now()
+
+	schema.SetHash(block, "abc")
+	schema.SetPreviousHash(block, "def")
+	schema.SetSort(block, &now)
+	schema.SetPartition(block, "xyz")
+
+	assert.Equal(t, "abc", block.Hash)
+	assert.Equal(t, "abc", schema.Hash(block))
+	assert.Equal(t, "def", schema.PreviousHash(block))
+	assert.Equal(t, &now, schema.Sort(block))
+	assert.Equal(t, "xyz", schema.Partition(block))
+
+	assert.Panics(t, func() {
+		schema.Hash(&testBlock{})
+	})
+}
+
+func TestSchemaSortTypes(t *testing.T) {
+	timeSchema := SchemaFor(&partitionedBlock{})
+	assert.False(t, timeSchema.HasSort(&partitionedBlock{}))
+	sort, err := timeSchema.ParseSort("2019-01-01T12:39:01.999999999+01:00")
+	assert.Nil(t, err)
+	block := &partitionedBlock{}
+	timeSchema.SetSort(block, sort)
+	assert.True(t, timeSchema.HasSort(block))
+	_, err = timeSchema.ParseSort("123")
+	assert.NotNil(t, err)
+
+	type sequenceBlock struct {
+		Sequence     int64  `auditor:"sort"`
+		Hash         string `auditor:"hash"`
+		PreviousHash string `auditor:"previoushash"`
+	}
+	assert.Nil(t, ValidateBlockType(&sequenceBlock{}))
+	sequenceSchema := SchemaFor(&sequenceBlock{})
+	assert.False(t, sequenceSchema.HasSort(&sequenceBlock{}))
+	assert.True(t, sequenceSchema.HasSort(&sequenceBlock{Sequence: 10}))
+	sort, err = sequenceSchema.ParseSort("10")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), sort)
+	_, err = sequenceSchema.ParseSort("abc")
+	assert.NotNil(t, err)
+
+	type ulidBlock struct {
+		ID           string `auditor:"sort"`
+		Hash         string `auditor:"hash"`
+		PreviousHash string `auditor:"previoushash"`
+	}
+	assert.Nil(t, ValidateBlockType(&ulidBlock{}))
+	ulidSchema := SchemaFor(&ulidBlock{})
+	assert.False(t, ulidSchema.HasSort(&ulidBlock{}))
+	assert.True(t, ulidSchema.HasSort(&ulidBlock{ID: "01ARZ3NDEKTSV4RRFFQ69G5FAV"}))
+	sort, err = ulidSchema.ParseSort("01ARZ3NDEKTSV4RRFFQ69G5FAV")
+	assert.Nil(t, err)
+	assert.Equal(t, "01ARZ3NDEKTSV4RRFFQ69G5FAV", sort)
+}
+
+// parseFieldsTaggedWith is how tags were looked up before BlockSchema was introduced
+func parseFieldsTaggedWith(t reflect.Type, tagValue string) []reflect.StructField {
+	fields := []reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		for _, tag := range strings.Split(field.Tag.Get("auditor"), ",") {
+			if tag == tagValue {
+				fields = append(fields, field)
+			}
+		}
+	}
+	return fields
+}
+
+func BenchmarkParseFieldsTaggedWith(b *testing.B) {
+	t := reflect.TypeOf(partitionedBlock{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		parseFieldsTaggedWith(t, "previoushash")
+	}
+}
+
+func BenchmarkGetTypeFieldsTaggedWith(b *testing.B) {
+	t := reflect.TypeOf(partitionedBlock{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		GetTypeFieldsTaggedWith(t, "previoushash")
+	}
+}
+
+func BenchmarkSetPreviousHash(b *testing.B) {
+	block := &partitionedBlock{}
+	previous := &partitionedBlock{Hash: "abc"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		SetPreviousHash(block, previous)
+	}
+}
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"time"
+)
+
+// SortTime returns value of sort field as time: int64 is read as nanoseconds since epoch and string as RFC3339Nano timestamp,
+// returns false when sort field is not set or is not a timestamp
+func (s *BlockSchema) SortTime(block interface{}) (time.Time, bool) {
+	if !s.HasSort(block) {
+		return time.Time{}, false
+	}
+	switch value := s.Sort(block).(type) {
+	case *time.Time:
+		return *value, true
+	case int64:
+		return time.Unix(0, value), true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// SortBefore returns true if block a sorts before block b, strings are compared lexicographically
+func (s *BlockSchema) SortBefore(a, b interface{}) bool {
+	switch value := s.Sort(a).(type) {
+	case *time.Time:
+		return value.Before(*s.Sort(b).(*time.Time))
+	case int64:
+		return value < s.Sort(b).(int64)
+	default:
+		return value.(string) < s.Sort(b).(string)
+	}
+}
+
+// HasClientTime returns true if block type has field tagged with clienttime
+func (s *BlockSchema) HasClientTime() bool {
+	return s.clientTime >= 0
+}
+
+// KeepClientTime copies sort value sent by client to field tagged with clienttime before server overwrites it,
+// it does nothing when block type has no such field
+func (s *BlockSchema) KeepClientTime(block interface{}) {
+	if s.HasClientTime() {
+		s.field(block, s.clientTime).Set(s.field(block, s.sort))
+	}
+}
+
+// HasSkewed returns true if block type has field tagged with skewed
+func (s *BlockSchema) HasSkewed() bool {
+	return s.skewed >= 0
+}
+
+// SetSkewed sets field tagged with skewed, it does nothing when block type has no such field
+func (s *BlockSchema) SetSkewed(block interface{}, skewed bool) {
+	if s.HasSkewed() {
+		s.field(block, s.skewed).SetBool(skewed)
+	}
+}
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type clockBlock struct {
+	Timestamp       *time.Time `auditor:"sort"`
+	ClientTimestamp *time.Time `auditor:"clienttime"`
+	Skewed          bool       `auditor:"skewed"`
+	Hash            string     `auditor:"hash"`
+	PreviousHash    string     `auditor:"previoushash"`
+}
+
+type sequenceClockBlock struct {
+	Sequence     int64  `auditor:"sort"`
+	Hash         string `auditor:"hash"`
+	PreviousHash string `auditor:"previoushash"`
+}
+
+type keyClockBlock struct {
+	Key          string `auditor:"sort"`
+	Hash         string `auditor:"hash"`
+	PreviousHash string `auditor:"previoushash"`
+}
+
+func TestSortTime(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	block := &clockBlock{}
+	schema := SchemaFor(block)
+	_, ok := schema.SortTime(block)
+	assert.False(t, ok)
+	block.Timestamp = &now
+	sort, ok := schema.SortTime(block)
+	assert.True(t, ok)
+	assert.Equal(t, now, sort)
+
+	sequence := &sequenceClockBlock{Sequence: now.UnixNano()}
+	sort, ok = SchemaFor(sequence).SortTime(sequence)
+	assert.True(t, ok)
+	assert.True(t, now.Equal(sort))
+
+	key := &keyClockBlock{Key: "2019-01-01T12:00:00Z"}
+	sort, ok = SchemaFor(key).SortTime(key)
+	assert.True(t, ok)
+	assert.Equal(t, now, sort)
+	key.Key = "order-1"
+	_, ok = SchemaFor(key).SortTime(key)
+	assert.False(t, ok)
+}
+
+func TestSortBefore(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Second)
+	schema := SchemaFor(&clockBlock{})
+	assert.True(t, schema.SortBefore(&clockBlock{Timestamp: &now}, &clockBlock{Timestamp: &later}))
+	assert.False(t, schema.SortBefore(&clockBlock{Timestamp: &later}, &clockBlock{Timestamp: &now}))
+	assert.False(t, schema.SortBefore(&clockBlock{Timestamp: &now}, &clockBlock{Timestamp: &now}))
+
+	assert.True(t, SchemaFor(&sequenceClockBlock{}).SortBefore(&sequenceClockBlock{Sequence: 1}, &sequenceClockBlock{Sequence: 2}))
+	assert.True(t, SchemaFor(&keyClockBlock{}).SortBefore(&keyClockBlock{Key: "a"}, &keyClockBlock{Key: "b"}))
+}
+
+func TestClientTimeAndSkewed(t *testing.T) {
+	now := time.Now()
+	block := &clockBlock{Timestamp: &now}
+	schema := SchemaFor(block)
+	assert.True(t, schema.HasClientTime())
+	assert.True(t, schema.HasSkewed())
+	schema.KeepClientTime(block)
+	assert.Equal(t, &now, block.ClientTimestamp)
+	schema.SetSkewed(block, true)
+	assert.True(t, block.Skewed)
+
+	// no-op for block types without such fields
+	plain := &Block{Timestamp: &now}
+	assert.False(t, SchemaFor(plain).HasClientTime())
+	SchemaFor(plain).KeepClientTime(plain)
+	SchemaFor(plain).SetSkewed(plain, true)
+}
+
+func TestValidateBlockTypeClockErrors(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	assert.Nil(t, ValidateBlockType(&clockBlock{}))
+
+	s := struct {
+		Timestamp    *time.Time `auditor:"sort"`
+		Client       string     `auditor:"clienttime"`
+		Skewed       string     `auditor:"skewed,hash"`
+		Flagged      bool       `auditor:"skewed"`
+		PreviousHash string     `auditor:"previoushash"`
+	}{}
+	err := ValidateBlockType(&s)
+	assert.Equal(t, []error{
+		errors.New("block type must have at most one field tagged with 'skewed', found: 2"),
+		errors.New("field Skewed tagged with 'skewed' must not be tagged with 'hash'"),
+		errors.New("field Client tagged with 'clienttime' must be *time.Time, but got: string"),
+		errors.New("field Skewed tagged with 'skewed' must be bool, but got: string"),
+	}, err.(*ValidationError).Errors)
+}
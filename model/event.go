@@ -0,0 +1,171 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// CloudEventsSpecVersion is the only supported version of CloudEvents specification
+	CloudEventsSpecVersion = "1.0"
+	// DefaultEventSource is used as source of CloudEvents rendered from events which have none
+	DefaultEventSource = "/auditor"
+	// DefaultEventType is used as type of CloudEvents rendered from events which have none
+	DefaultEventType = "auditor.event"
+)
+
+// Event is a production audit event: actor performed action on resource with outcome,
+// it is accepted and rendered as CloudEvent (see CloudEventBlock)
+type Event struct {
+	ID             string
+	Source         string     `auditor:"dynamodb_partition,mongodb_index"`
+	Type           string     `auditor:"mongodb_index"`
+	Timestamp      *time.Time `auditor:"sort,mongodb_index" validate:"nonzero"`
+	ActorID        string     `auditor:"mongodb_index"`
+	ActorType      string
+	ActorIP        string
+	ActorUserAgent string
+	Action         string `validate:"nonzero"`
+	ResourceType   string `auditor:"mongodb_index"`
+	ResourceID     string `auditor:"mongodb_index"`
+	Outcome        string
+	Reason         string
+	CorrelationID  string `auditor:"mongodb_index"`
+	Hash           string `auditor:"hash"`
+	PreviousHash   string `auditor:"previoushash"`
+}
+
+// CloudEvent is a CloudEvents 1.0 event in structured JSON format,
+// correlationid, hash, and previoushash are extension attributes
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            *time.Time      `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	CorrelationID   string          `json:"correlationid,omitempty"`
+	Hash            string          `json:"hash,omitempty"`
+	PreviousHash    string          `json:"previoushash,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// CloudEventBlock is implemented by block types which can be posted and read as CloudEvents
+type CloudEventBlock interface {
+	FromCloudEvent(event *CloudEvent) error
+	CloudEvent() (*CloudEvent, error)
+}
+
+// Validate returns error if required attributes are missing or event is of unsupported version or content type
+func (e *CloudEvent) Validate() error {
+	if e.SpecVersion != CloudEventsSpecVersion {
+		return fmt.Errorf("unsupported CloudEvents specversion: %v", e.SpecVersion)
+	}
+	for _, attribute := range [][2]string{{"id", e.ID}, {"source", e.Source}, {"type", e.Type}} {
+		if len(attribute[1]) == 0 {
+			return fmt.Errorf("CloudEvent must have %v attribute", attribute[0])
+		}
+	}
+	if len(e.DataContentType) > 0 && e.DataContentType != "application/json" {
+		return fmt.Errorf("unsupported CloudEvent datacontenttype: %v", e.DataContentType)
+	}
+	return nil
+}
+
+// eventData is data of CloudEvents rendered from Event
+type eventData struct {
+	Actor    *eventActor    `json:"actor,omitempty"`
+	Action   string         `json:"action,omitempty"`
+	Resource *eventResource `json:"resource,omitempty"`
+	Outcome  string         `json:"outcome,omitempty"`
+	Reason   string         `json:"reason,omitempty"`
+}
+
+type eventActor struct {
+	ID        string `json:"id,omitempty"`
+	Type      string `json:"type,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+}
+
+type eventResource struct {
+	Type string `json:"type,omitempty"`
+	ID   string `json:"id,omitempty"`
+}
+
+// FromCloudEvent sets fields of event from CloudEvent: context attributes are mapped to ID, Source, Type, Timestamp,
+// ResourceID (subject), and CorrelationID, data holds actor, action, resource, outcome, and reason,
+// hash and previoushash are ignored as they are set by store
+func (e *Event) FromCloudEvent(event *CloudEvent) error {
+	if err := event.Validate(); err != nil {
+		return err
+	}
+	data := eventData{}
+	if len(event.Data) > 0 {
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			return errors.New("CloudEvent data must be a JSON object with actor, action, resource, outcome, and reason")
+		}
+	}
+	*e = Event{
+		ID:            event.ID,
+		Source:        event.Source,
+		Type:          event.Type,
+		Timestamp:     event.Time,
+		ResourceID:    event.Subject,
+		CorrelationID: event.CorrelationID,
+		Action:        data.Action,
+		Outcome:       data.Outcome,
+		Reason:        data.Reason,
+	}
+	if data.Actor != nil {
+		e.ActorID, e.ActorType, e.ActorIP, e.ActorUserAgent = data.Actor.ID, data.Actor.Type, data.Actor.IP, data.Actor.UserAgent
+	}
+	if data.Resource != nil {
+		e.ResourceType = data.Resource.Type
+		if len(data.Resource.ID) > 0 {
+			e.ResourceID = data.Resource.ID
+		}
+	}
+	return nil
+}
+
+// CloudEvent renders event as CloudEvent, events without ID (for example recorded by auditor itself) use hash as ID
+func (e *Event) CloudEvent() (*CloudEvent, error) {
+	data := eventData{Action: e.Action, Outcome: e.Outcome, Reason: e.Reason}
+	if actor := (eventActor{e.ActorID, e.ActorType, e.ActorIP, e.ActorUserAgent}); actor != (eventActor{}) {
+		data.Actor = &actor
+	}
+	if resource := (eventResource{e.ResourceType, e.ResourceID}); resource != (eventResource{}) {
+		data.Resource = &resource
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	event := &CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              e.ID,
+		Source:          e.Source,
+		Type:            e.Type,
+		Subject:         e.ResourceID,
+		Time:            e.Timestamp,
+		DataContentType: "application/json",
+		CorrelationID:   e.CorrelationID,
+		Hash:            e.Hash,
+		PreviousHash:    e.PreviousHash,
+		Data:            encoded,
+	}
+	if len(event.ID) == 0 {
+		event.ID = e.Hash
+	}
+	if len(event.Source) == 0 {
+		event.Source = DefaultEventSource
+	}
+	if len(event.Type) == 0 {
+		event.Type = DefaultEventType
+	}
+	return event, nil
+}
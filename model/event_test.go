@@ -0,0 +1,99 @@
+package model
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testCloudEvent = `{
+  "specversion": "1.0",
+  "id": "a1b2c3",
+  "source": "/services/auth",
+  "type": "com.example.user.login",
+  "subject": "account-1",
+  "time": "2019-01-01T12:00:00Z",
+  "datacontenttype": "application/json",
+  "correlationid": "req-42",
+  "data": {
+    "actor": {"id": "user-7", "type": "user", "ip": "10.0.0.1", "userAgent": "curl/7.64"},
+    "action": "login",
+    "resource": {"type": "account"},
+    "outcome": "success",
+    "reason": "password"
+  }
+}`
+
+func TestValidateBlockTypeEvent(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	assert.Nil(t, ValidateBlockType(&Event{}))
+}
+
+func TestEventFromCloudEvent(t *testing.T) {
+	cloudEvent := &CloudEvent{}
+	assert.Nil(t, json.Unmarshal([]byte(testCloudEvent), cloudEvent))
+
+	event := &Event{Hash: "ignored"}
+	assert.Nil(t, event.FromCloudEvent(cloudEvent))
+	timestamp := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, &Event{
+		ID:             "a1b2c3",
+		Source:         "/services/auth",
+		Type:           "com.example.user.login",
+		Timestamp:      &timestamp,
+		ActorID:        "user-7",
+		ActorType:      "user",
+		ActorIP:        "10.0.0.1",
+		ActorUserAgent: "curl/7.64",
+		Action:         "login",
+		ResourceType:   "account",
+		ResourceID:     "account-1",
+		Outcome:        "success",
+		Reason:         "password",
+		CorrelationID:  "req-42",
+	}, event)
+
+	// rendered event carries the same attributes and data, hashes are extension attributes
+	event.Hash = "1234"
+	rendered, err := event.CloudEvent()
+	assert.Nil(t, err)
+	assert.Equal(t, "1234", rendered.Hash)
+	rendered.Hash = ""
+	expected := &CloudEvent{}
+	json.Unmarshal([]byte(testCloudEvent), expected)
+	assert.JSONEq(t, `{"actor": {"id": "user-7", "type": "user", "ip": "10.0.0.1", "userAgent": "curl/7.64"}, "action": "login", "resource": {"type": "account", "id": "account-1"}, "outcome": "success", "reason": "password"}`, string(rendered.Data))
+	rendered.Data, expected.Data = nil, nil
+	assert.Equal(t, expected, rendered)
+}
+
+func TestEventCloudEventDefaults(t *testing.T) {
+	event := &Event{Action: "erasure", Hash: "1234"}
+	rendered, err := event.CloudEvent()
+	assert.Nil(t, err)
+	assert.Equal(t, "1234", rendered.ID)
+	assert.Equal(t, DefaultEventSource, rendered.Source)
+	assert.Equal(t, DefaultEventType, rendered.Type)
+	assert.Equal(t, `{"action":"erasure"}`, string(rendered.Data))
+}
+
+func TestCloudEventValidate(t *testing.T) {
+	for expected, event := range map[string]*CloudEvent{
+		"unsupported CloudEvents specversion: 0.3":         {SpecVersion: "0.3", ID: "1", Source: "/s", Type: "t"},
+		"CloudEvent must have id attribute":                {SpecVersion: "1.0", Source: "/s", Type: "t"},
+		"CloudEvent must have source attribute":            {SpecVersion: "1.0", ID: "1", Type: "t"},
+		"CloudEvent must have type attribute":              {SpecVersion: "1.0", ID: "1", Source: "/s"},
+		"unsupported CloudEvent datacontenttype: text/xml": {SpecVersion: "1.0", ID: "1", Source: "/s", Type: "t", DataContentType: "text/xml"},
+	} {
+		err := (&Event{}).FromCloudEvent(event)
+		assert.NotNil(t, err)
+		if err != nil {
+			assert.Equal(t, expected, err.Error())
+		}
+	}
+
+	err := (&Event{}).FromCloudEvent(&CloudEvent{SpecVersion: "1.0", ID: "1", Source: "/s", Type: "t", Data: json.RawMessage(`"login"`)})
+	assert.Equal(t, "CloudEvent data must be a JSON object with actor, action, resource, outcome, and reason", err.Error())
+}
@@ -0,0 +1,36 @@
+package model
+
+import "reflect"
+
+// HasIdempotencyKey returns true if block type has fields tagged with idempotencykey and fingerprint
+func (s *BlockSchema) HasIdempotencyKey() bool {
+	return s.idempotency >= 0
+}
+
+// IdempotencyKeyField returns field tagged with idempotencykey, block type must have it
+func (s *BlockSchema) IdempotencyKeyField() reflect.StructField {
+	return s.Type.Field(s.idempotency)
+}
+
+// IdempotencyKey returns value of field tagged with idempotencykey, empty string when block type has no such field
+func (s *BlockSchema) IdempotencyKey(block interface{}) string {
+	if !s.HasIdempotencyKey() {
+		return ""
+	}
+	return s.field(block, s.idempotency).String()
+}
+
+// SetIdempotencyKey sets field tagged with idempotencykey, block type must have it
+func (s *BlockSchema) SetIdempotencyKey(block interface{}, key string) {
+	s.field(block, s.idempotency).SetString(key)
+}
+
+// Fingerprint returns value of field tagged with fingerprint
+func (s *BlockSchema) Fingerprint(block interface{}) string {
+	return s.field(block, s.fingerprint).String()
+}
+
+// SetFingerprint sets field tagged with fingerprint
+func (s *BlockSchema) SetFingerprint(block interface{}, fingerprint string) {
+	s.field(block, s.fingerprint).SetString(fingerprint)
+}
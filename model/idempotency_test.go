@@ -0,0 +1,52 @@
+package model
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type idempotentBlock struct {
+	Timestamp      *time.Time `auditor:"sort"`
+	IdempotencyKey string     `auditor:"idempotencykey"`
+	Fingerprint    string     `auditor:"fingerprint"`
+	Hash           string     `auditor:"hash"`
+	PreviousHash   string     `auditor:"previoushash"`
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	block := &idempotentBlock{}
+	schema := SchemaFor(block)
+	assert.True(t, schema.HasIdempotencyKey())
+	assert.Equal(t, "IdempotencyKey", schema.IdempotencyKeyField().Name)
+	schema.SetIdempotencyKey(block, "order-123")
+	schema.SetFingerprint(block, "abc")
+	assert.Equal(t, "order-123", schema.IdempotencyKey(block))
+	assert.Equal(t, "abc", schema.Fingerprint(block))
+
+	plain := &Block{}
+	assert.False(t, SchemaFor(plain).HasIdempotencyKey())
+	assert.Equal(t, "", SchemaFor(plain).IdempotencyKey(plain))
+}
+
+func TestValidateBlockTypeIdempotencyErrors(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	assert.Nil(t, ValidateBlockType(&idempotentBlock{}))
+
+	s := struct {
+		Timestamp    *time.Time `auditor:"sort"`
+		Key          int64      `auditor:"idempotencykey"`
+		Hash         string     `auditor:"hash"`
+		PreviousHash string     `auditor:"previoushash,idempotencykey"`
+	}{}
+	err := ValidateBlockType(&s)
+	assert.Equal(t, []error{
+		errors.New("block type must have at most one field tagged with 'idempotencykey', found: 2"),
+		errors.New("field Key tagged with 'idempotencykey' must be string, but got: int64"),
+		errors.New("field PreviousHash tagged with 'idempotencykey' must not be tagged with 'previoushash'"),
+		errors.New("block type must have both fields tagged with 'idempotencykey' and 'fingerprint' or none of them"),
+	}, err.(*ValidationError).Errors)
+}
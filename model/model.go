@@ -1,8 +1,9 @@
 package model
 
 import (
+	"errors"
+	"fmt"
 	"log"
-	"os"
 	"reflect"
 	"strings"
 	"time"
@@ -21,55 +22,68 @@ type Block struct {
 	PreviousHash string     `auditor:"previoushash"`
 }
 
-// ValidateBlockType validates if passed pointer to struct is a valid auditor block
-func ValidateBlockType(block interface{}) {
-	validateBlock(block)
-	hashField := GetTypeFieldsTaggedWith(reflect.TypeOf(block).Elem(), "hash")
-	if len(hashField) != 1 {
-		log.Panicf("block type must have one field tagged with 'hash', found: %v", len(hashField))
-	}
-	previousHashField := GetTypeFieldsTaggedWith(reflect.TypeOf(block).Elem(), "previoushash")
-	if len(previousHashField) != 1 {
-		log.Panicf("block type must have one field tagged with 'previoushash', found: %v", len(previousHashField))
-	}
-	sortField := GetTypeFieldsTaggedWith(reflect.TypeOf(block).Elem(), "sort")
-	if len(sortField) != 1 {
-		log.Panicf("block type must have one field tagged with 'sort', found: %v", len(sortField))
-	}
-	if os.Getenv("AUDITOR_STORE") == "dynamodb" {
-		partitionField := GetTypeFieldsTaggedWith(reflect.TypeOf(block).Elem(), "dynamodb_partition")
-		if len(partitionField) != 1 {
-			log.Panicf("when using DynamoDB block type must have one field tagged with 'dynamodb_partition', found: %v", len(partitionField))
-		}
-	}
+// Checkpoint is a Merkle tree root computed over the first Size blocks of the audit chain,
+// Hashes contains hashes of blocks added to the tree since previous checkpoint (From is index of the first one),
+// checkpoints are chained too
+type Checkpoint struct {
+	Log          string     `auditor:"dynamodb_partition"`
+	Timestamp    *time.Time `auditor:"sort"`
+	Root         string
+	From         int64
+	Size         int64
+	Hashes       []string
+	Hash         string `auditor:"hash"`
+	PreviousHash string `auditor:"previoushash"`
+}
+
+// Access records a read of the audit log, accesses are chained in their own store so that reads are tamper-evident too,
+// Chain is the name of chain which was read, Filters are query parameters of the request
+type Access struct {
+	Chain        string     `auditor:"dynamodb_partition,mongodb_index"`
+	Timestamp    *time.Time `auditor:"sort"`
+	Principal    string     `auditor:"principal,mongodb_index"`
+	Operation    string
+	Filters      string
+	Records      int64
+	RequestID    string
+	Hash         string `auditor:"hash"`
+	PreviousHash string `auditor:"previoushash"`
 }
 
+// validateBlock panics if block is not a pointer to struct
 func validateBlock(block interface{}) {
+	if err := checkBlock(block); err != nil {
+		log.Panic(err.Error())
+	}
+}
+
+func checkBlock(block interface{}) error {
 	if block == nil {
-		log.Panic("block must not be nil")
+		return errors.New("block must not be nil")
 	}
 	if reflect.TypeOf(block).Kind() != reflect.Ptr {
-		log.Panicf("block argument must be a pointer to struct, but got: %v", reflect.TypeOf(block).Kind())
+		return fmt.Errorf("block argument must be a pointer to struct, but got: %v", reflect.TypeOf(block).Kind())
 	}
 	if reflect.TypeOf(block).Elem().Kind() != reflect.Struct {
-		log.Panicf("block argument must be a pointer to struct, but got: %v", reflect.TypeOf(block).Elem().Kind())
+		return fmt.Errorf("block argument must be a pointer to struct, but got: %v", reflect.TypeOf(block).Elem().Kind())
 	}
+	return nil
 }
 
-// GetTypeFieldsTaggedWith gets a StructField tagged with a specific auditor value
+// GetTypeFieldsTaggedWith gets a StructField tagged with a specific auditor value,
+// fields are read from cached BlockSchema, returned slice must not be modified
 func GetTypeFieldsTaggedWith(t reflect.Type, tagValue string) []reflect.StructField {
-	fields := []reflect.StructField{}
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		tags := strings.Split(field.Tag.Get("auditor"), ",")
-		for _, tag := range tags {
-			if tag == tagValue {
-				fields = append(fields, field)
-			}
+	return SchemaOf(t).FieldsTaggedWith(tagValue)
+}
+
+func hasTag(field reflect.StructField, tagValue string) bool {
+	tags := strings.Split(field.Tag.Get("auditor"), ",")
+	for _, tag := range tags {
+		if tag == tagValue {
+			return true
 		}
 	}
-
-	return fields
+	return false
 }
 
 // GetFieldsTaggedWith gets a StructField tagged with a specific auditor value
@@ -112,28 +126,70 @@ func SetFieldValue(block interface{}, field reflect.StructField, value interface
 	return false
 }
 
-// ComputeAndSetHash computes and sets hash on given block, returns new hash or error
+// ComputeAndSetHash computes and sets hash on given block, returns new hash or error,
+// for blocks with redactable fields missing salts are generated first, version field is set to current version
 func ComputeAndSetHash(block interface{}) (string, error) {
-	validateBlock(block)
-	hash, err := hash.ComputeHash(block)
+	schema := SchemaFor(block)
+	setVersion(block)
+	if len(schema.FieldsTaggedWith("redactable")) > 0 {
+		if err := setSalts(block); err != nil {
+			return "", err
+		}
+	}
+	hash, err := ComputeHash(block)
 	if err != nil {
 		return "", err
 	}
-	hashField := GetFieldsTaggedWith(block, "hash")
-	SetFieldValue(block, hashField[0], hash)
+	schema.SetHash(block, hash)
 	return hash, nil
 }
 
+// ComputeHash computes hash of given block, for blocks with redactable fields
+// hash is computed over commitments of redactable fields instead of their values,
+// maps, interfaces, and structs containing them are hashed as canonical JSON,
+// blocks of old versions are hashed in the type of their version
+func ComputeHash(block interface{}) (string, error) {
+	validateBlock(block)
+	block, err := original(block)
+	if err != nil {
+		return "", err
+	}
+	schema := SchemaFor(block)
+	if len(schema.FieldsTaggedWith("redactable")) > 0 {
+		committed, err := commitmentsBlock(block)
+		if err != nil {
+			return "", err
+		}
+		block = committed
+	}
+	hashable, err := schema.hashable(block)
+	if err != nil {
+		return "", err
+	}
+	return hash.ComputeHash(hashable)
+}
+
+// VerifyHash recomputes hash of block read from store (according to its original version) and compares it with its hash field
+func VerifyHash(block interface{}) error {
+	schema := SchemaFor(block)
+	expected := schema.Hash(block)
+	cleared := reflect.New(schema.Type)
+	cleared.Elem().Set(reflect.ValueOf(block).Elem())
+	schema.SetHash(cleared.Interface(), "")
+	actual, err := ComputeHash(cleared.Interface())
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("block %v does not verify", expected)
+	}
+	return nil
+}
+
 // SetPreviousHash sets a PreviousHash field on a block from Hash field of previous one
 func SetPreviousHash(block, previousBlock interface{}) {
 	if previousBlock == nil {
 		return
 	}
-	validateBlock(block)
-	validateBlock(previousBlock)
-
-	hashField := GetFieldsTaggedWith(previousBlock, "hash")
-	previousHashField := GetFieldsTaggedWith(block, "previoushash")
-	previousHash := GetFieldValue(previousBlock, hashField[0])
-	SetFieldValue(block, previousHashField[0], previousHash)
+	SchemaFor(block).SetPreviousHash(block, SchemaFor(previousBlock).Hash(previousBlock))
 }
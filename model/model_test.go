@@ -1,7 +1,10 @@
 package model
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -16,22 +19,16 @@ type testBlock struct {
 }
 
 func TestValidateBlockTypeNilError(t *testing.T) {
-	assert.Panics(t, func() {
-		ValidateBlockType(nil)
-	})
+	assert.NotNil(t, ValidateBlockType(nil))
 }
 
 func TestValidateBlockTypeInvalidPointerError(t *testing.T) {
-	assert.Panics(t, func() {
-		ValidateBlockType(TestValidateBlockTypeInvalidTypeError)
-	})
+	assert.NotNil(t, ValidateBlockType(TestValidateBlockTypeInvalidTypeError))
 }
 
 func TestValidateBlockTypeInvalidTypeError(t *testing.T) {
-	assert.Panics(t, func() {
-		test := "string"
-		ValidateBlockType(&test)
-	})
+	test := "string"
+	assert.NotNil(t, ValidateBlockType(&test))
 }
 
 func TestValidateBlockTypeError1(t *testing.T) {
@@ -39,9 +36,7 @@ func TestValidateBlockTypeError1(t *testing.T) {
 	// missing hash field
 	s := struct {
 	}{}
-	assert.Panics(t, func() {
-		ValidateBlockType(&s)
-	})
+	assert.NotNil(t, ValidateBlockType(&s))
 }
 
 func TestValidateBlockTypeError2(t *testing.T) {
@@ -50,9 +45,7 @@ func TestValidateBlockTypeError2(t *testing.T) {
 	s := struct {
 		Hash string `auditor:"hash"`
 	}{}
-	assert.Panics(t, func() {
-		ValidateBlockType(&s)
-	})
+	assert.NotNil(t, ValidateBlockType(&s))
 }
 
 func TestValidateBlockTypeError3(t *testing.T) {
@@ -62,21 +55,54 @@ func TestValidateBlockTypeError3(t *testing.T) {
 		Hash         string `auditor:"hash"`
 		PreviousHash string `auditor:"previoushash"`
 	}{}
-	assert.Panics(t, func() {
-		ValidateBlockType(&s)
-	})
+	assert.NotNil(t, ValidateBlockType(&s))
 }
 
-func TestValidateBlockTypeDynamoDBError(t *testing.T) {
-	os.Setenv("AUDITOR_STORE", "dynamodb")
-	// missing dynamodb_partition field
+func TestValidateBlockTypeBackendRules(t *testing.T) {
+	RegisterRules("test", func(t reflect.Type) []error {
+		return ExactlyOne(t, "dynamodb_partition")
+	})
+	defer os.Setenv("AUDITOR_STORE", "")
 	s := struct {
 		Hash         string     `auditor:"hash"`
 		PreviousHash string     `auditor:"previoushash"`
 		Timestamp    *time.Time `auditor:"sort,mongodb_index"`
 	}{}
+	os.Setenv("AUDITOR_STORE", "")
+	assert.Nil(t, ValidateBlockType(&s))
+	// missing dynamodb_partition field
+	os.Setenv("AUDITOR_STORE", "test")
+	err := ValidateBlockType(&s)
+	assert.Equal(t, "block type struct is invalid: block type must have one field tagged with 'dynamodb_partition', found: 0", err.Error())
+}
+
+func TestValidateBlockTypeAllErrors(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	s := struct {
+		Hash         int       `auditor:"hash"`
+		previousHash string    `auditor:"previoushash"`
+		Timestamp    time.Time `auditor:"sort"`
+		Category     string    `auditor:"mongodb_idx"`
+	}{}
+	err := ValidateBlockType(&s)
+	validationError, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	assert.Equal(t, "struct", validationError.Type)
+	assert.Equal(t, []error{
+		fmt.Errorf("field Category has unknown auditor tag: 'mongodb_idx'"),
+		fmt.Errorf("field previousHash tagged with 'previoushash' must be exported"),
+		fmt.Errorf("field Hash tagged with 'hash' must be string, but got: int"),
+		fmt.Errorf("field Timestamp tagged with 'sort' must be one of *time.Time, int64, string, but got: time.Time"),
+	}, validationError.Errors)
+}
+
+func TestMustValidateBlockType(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	assert.NotPanics(t, func() {
+		MustValidateBlockType(&testBlock{})
+	})
 	assert.Panics(t, func() {
-		ValidateBlockType(&s)
+		MustValidateBlockType(&struct{}{})
 	})
 }
 
@@ -126,9 +152,89 @@ func TestComputeAndSetHash(t *testing.T) {
 	assert.Equal(t, hash, block.Hash)
 }
 
+func TestVerifyHashStoredTimes(t *testing.T) {
+	now := time.Now().In(time.FixedZone("CET", 3600))
+	details := map[string]interface{}{"id": 1}
+	for _, block := range []interface{}{&testBlock{Category: "login", Timestamp: &now}, &payloadBlock{Timestamp: &now, Details: details}} {
+		_, err := ComputeAndSetHash(block)
+		assert.Nil(t, err)
+		// hashing does not modify time of the block
+		assert.Equal(t, now, *SchemaFor(block).Sort(block).(*time.Time))
+
+		// JSON and stores decode times in UTC, MongoDB keeps milliseconds only
+		data, err := json.Marshal(block)
+		assert.Nil(t, err)
+		decoded := reflect.New(reflect.TypeOf(block).Elem()).Interface()
+		assert.Nil(t, DecodeBlock(data, decoded))
+		assert.Nil(t, VerifyHash(decoded))
+		stored := now.UTC().Truncate(time.Millisecond)
+		SchemaFor(decoded).SetSort(decoded, &stored)
+		assert.Nil(t, VerifyHash(decoded))
+
+		changed := now.Add(time.Millisecond)
+		SchemaFor(decoded).SetSort(decoded, &changed)
+		assert.NotNil(t, VerifyHash(decoded))
+	}
+}
+
 func TestSetPreviousHash(t *testing.T) {
 	block := &testBlock{}
 	previousBlock := &testBlock{Hash: "abcdef123"}
 	SetPreviousHash(block, previousBlock)
 	assert.Equal(t, previousBlock.Hash, block.PreviousHash)
 }
+
+func TestValidateBlockTypeEncryptNotStringError(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	s := struct {
+		Hash         string     `auditor:"hash"`
+		PreviousHash string     `auditor:"previoushash"`
+		Timestamp    *time.Time `auditor:"sort"`
+		Amount       int        `auditor:"encrypt"`
+	}{}
+	assert.NotNil(t, ValidateBlockType(&s))
+}
+
+func TestValidateBlockTypeEncryptHashError(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	s := struct {
+		Hash         string     `auditor:"hash,encrypt"`
+		PreviousHash string     `auditor:"previoushash"`
+		Timestamp    *time.Time `auditor:"sort"`
+	}{}
+	assert.NotNil(t, ValidateBlockType(&s))
+}
+
+func TestValidateBlockTypeSubjectError(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	s := struct {
+		Hash         string     `auditor:"hash"`
+		PreviousHash string     `auditor:"previoushash"`
+		Timestamp    *time.Time `auditor:"sort"`
+		Customer     string     `auditor:"subject"`
+		User         string     `auditor:"subject"`
+	}{}
+	assert.NotNil(t, ValidateBlockType(&s))
+}
+
+func TestValidateBlockTypeSubjectPartitionError(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	s := struct {
+		Hash         string     `auditor:"hash"`
+		PreviousHash string     `auditor:"previoushash"`
+		Timestamp    *time.Time `auditor:"sort"`
+		Customer     string     `auditor:"dynamodb_partition,subject"`
+	}{}
+	assert.NotNil(t, ValidateBlockType(&s))
+}
+
+func TestValidateBlockTypeSubjectEncryptError(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	s := struct {
+		Hash         string     `auditor:"hash"`
+		PreviousHash string     `auditor:"previoushash"`
+		Timestamp    *time.Time `auditor:"sort"`
+		Customer     string     `auditor:"subject,encrypt"`
+	}{}
+	assert.NotNil(t, ValidateBlockType(&s))
+}
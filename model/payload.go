@@ -0,0 +1,270 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+)
+
+var (
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	interfaceType  = reflect.TypeOf((*interface{})(nil)).Elem()
+	// payloadTypes lists types supported by payload field
+	payloadTypes = []reflect.Type{reflect.TypeOf(map[string]interface{}{}), rawMessageType}
+)
+
+// CanonicalJSON returns deterministic JSON encoding of value: object keys are sorted, whitespace is removed,
+// and numbers are encoded the way stores return them (integers which fit int64 exactly, other numbers as float64),
+// null values (nil, null) are encoded as empty slice, empty objects are encoded as {}
+func CanonicalJSON(value interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	generic, err := decodeJSON(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if generic == nil {
+		return []byte{}, nil
+	}
+	return json.Marshal(generic)
+}
+
+// DecodePayload decodes raw JSON payload into generic value which stores can persist natively,
+// empty payload is decoded as nil
+func DecodePayload(raw json.RawMessage) (interface{}, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, nil
+	}
+	return decodeJSON(raw)
+}
+
+// EncodePayload encodes generic value read from store back into raw JSON payload,
+// nil is encoded as empty payload
+func EncodePayload(value interface{}) (json.RawMessage, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
+
+// DecodeBlock decodes JSON into block, numbers in fields of interface types (payloads) are decoded the way DecodeNumbers does
+func DecodeBlock(data []byte, block interface{}) error {
+	if err := decodeAll(data, block); err != nil {
+		return err
+	}
+	return decodeNumbers(reflect.ValueOf(block).Elem())
+}
+
+func decodeJSON(encoded []byte) (interface{}, error) {
+	var generic interface{}
+	if err := decodeAll(encoded, &generic); err != nil {
+		return nil, err
+	}
+	return DecodeNumbers(generic)
+}
+
+// decodeAll decodes data which must hold a single JSON value, numbers are decoded as json.Number
+func decodeAll(data []byte, value interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(value); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid data after top-level value")
+	}
+	return nil
+}
+
+// number is implemented by json.Number and by numbers which stores decode as strings (DynamoDB)
+type number interface {
+	Int64() (int64, error)
+	Float64() (float64, error)
+}
+
+// DecodeNumbers replaces numbers decoded as strings (json.Number) in generic value with int64 when they are integers
+// which fit int64 and with float64 otherwise, integers above 2^53 would lose precision as float64
+func DecodeNumbers(value interface{}) (interface{}, error) {
+	v := reflect.New(interfaceType).Elem()
+	if value != nil {
+		v.Set(reflect.ValueOf(value))
+	}
+	if err := decodeNumbers(v); err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+func decodeNumbers(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if n, ok := v.Interface().(number); ok {
+			if i, err := n.Int64(); err == nil {
+				v.Set(reflect.ValueOf(i))
+				return nil
+			}
+			f, err := n.Float64()
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(f))
+			return nil
+		}
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		if err := decodeNumbers(elem); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return decodeNumbers(v.Elem())
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			if err := decodeNumbers(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := decodeNumbers(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				if err := decodeNumbers(v.Field(i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// RawPayloadFields returns payload fields of json.RawMessage type, stores convert them to and from native documents
+func (s *BlockSchema) RawPayloadFields() []reflect.StructField {
+	fields := []reflect.StructField{}
+	for _, field := range s.FieldsTaggedWith("payload") {
+		if field.Type == rawMessageType {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// needsCanonical returns true if gob serialization of type is not deterministic (or not possible),
+// that is if type is or contains a map or an interface
+func needsCanonical(t reflect.Type, visited map[reflect.Type]bool) bool {
+	if visited[t] {
+		return false
+	}
+	visited[t] = true
+	switch t.Kind() {
+	case reflect.Map, reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return needsCanonical(t.Elem(), visited)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" && needsCanonical(t.Field(i).Type, visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compileHashType builds type used for computing hash of blocks with payload fields or fields which need canonical encoding,
+// such fields are replaced by strings holding their canonical JSON, unexported fields are skipped as gob does
+func (s *BlockSchema) compileHashType() {
+	fields := []reflect.StructField{}
+	for i := 0; i < s.Type.NumField(); i++ {
+		field := s.Type.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		// salts are cleared before hashing, raw payloads are canonicalized so that key order does not matter
+		canonical := hasTag(field, "payload") || !hasTag(field, "salts") && needsCanonical(field.Type, map[reflect.Type]bool{})
+		if canonical {
+			field.Type = reflect.TypeOf("")
+		}
+		if !canonical && (field.Type == timeType || field.Type == timeType.Elem()) {
+			s.times = append(s.times, i)
+		}
+		field.Anonymous = false
+		field.Index = nil
+		field.Offset = 0
+		fields = append(fields, field)
+		s.hashFields = append(s.hashFields, i)
+		s.canonical = append(s.canonical, canonical)
+		s.hasCanonical = s.hasCanonical || canonical
+	}
+	if s.hasCanonical {
+		s.hashType = reflect.StructOf(fields)
+	}
+}
+
+// hashable returns block which is serialized when computing hash: block itself or a copy in which time fields
+// are in UTC truncated to milliseconds and payloads, maps, interfaces, and structs containing them are replaced by canonical JSON,
+// gob encodes location and nanoseconds of times which stores do not keep (MongoDB keeps milliseconds, stores and JSON decode UTC)
+func (s *BlockSchema) hashable(block interface{}) (interface{}, error) {
+	if !s.hasCanonical && len(s.times) == 0 {
+		return block, nil
+	}
+	v := reflect.ValueOf(block).Elem()
+	if !s.hasCanonical {
+		h := reflect.New(s.Type)
+		h.Elem().Set(v)
+		for _, index := range s.times {
+			canonicalTime(h.Elem().Field(index))
+		}
+		return h.Interface(), nil
+	}
+	h := reflect.New(s.hashType)
+	for i, index := range s.hashFields {
+		value := v.Field(index)
+		if !s.canonical[i] {
+			h.Elem().Field(i).Set(value)
+			canonicalTime(h.Elem().Field(i))
+			continue
+		}
+		encoded, err := CanonicalJSON(value.Interface())
+		if err != nil {
+			return nil, fmt.Errorf("could not encode field %v: %v", s.Type.Field(index).Name, err.Error())
+		}
+		h.Elem().Field(i).SetString(string(encoded))
+	}
+	return h.Interface(), nil
+}
+
+// canonicalTime replaces time or pointer to time in UTC truncated to milliseconds, other values are left as they are,
+// pointer is replaced so that time of the block is not modified
+func canonicalTime(value reflect.Value) {
+	switch t := value.Interface().(type) {
+	case time.Time:
+		value.Set(reflect.ValueOf(t.UTC().Truncate(time.Millisecond)))
+	case *time.Time:
+		if t != nil {
+			canonical := t.UTC().Truncate(time.Millisecond)
+			value.Set(reflect.ValueOf(&canonical))
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type payloadBlock struct {
+	Timestamp    *time.Time             `auditor:"sort"`
+	Details      map[string]interface{} `auditor:"payload"`
+	Hash         string                 `auditor:"hash"`
+	PreviousHash string                 `auditor:"previoushash"`
+}
+
+type rawPayloadBlock struct {
+	Timestamp    *time.Time      `auditor:"sort"`
+	Details      json.RawMessage `auditor:"payload"`
+	Hash         string          `auditor:"hash"`
+	PreviousHash string          `auditor:"previoushash"`
+}
+
+type request struct {
+	Method  string
+	Headers map[string]string
+}
+
+type nestedBlock struct {
+	Timestamp    *time.Time `auditor:"sort"`
+	Request      request
+	Hash         string `auditor:"hash"`
+	PreviousHash string `auditor:"previoushash"`
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	encoded, err := CanonicalJSON(json.RawMessage(`{ "b": [1, 2.0, {"y": true, "x": null}], "a": "text" }`))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":"text","b":[1,2,{"x":null,"y":true}]}`, string(encoded))
+
+	for _, empty := range []interface{}{nil, json.RawMessage(`null`), map[string]string(nil)} {
+		encoded, err := CanonicalJSON(empty)
+		assert.Nil(t, err)
+		assert.Empty(t, encoded)
+	}
+	// empty objects are not null
+	for _, object := range []interface{}{json.RawMessage(`{}`), map[string]interface{}{}} {
+		encoded, err := CanonicalJSON(object)
+		assert.Nil(t, err)
+		assert.Equal(t, `{}`, string(encoded))
+	}
+
+	// integers above 2^53 keep their precision
+	encoded, err = CanonicalJSON(json.RawMessage(`{"a": 9007199254740993, "b": 9007199254740992, "c": 1e3}`))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":9007199254740993,"b":9007199254740992,"c":1000}`, string(encoded))
+
+	_, err = CanonicalJSON(json.RawMessage(`{"a": 1e400}`))
+	assert.NotNil(t, err)
+	_, err = DecodePayload(json.RawMessage(`{} {}`))
+	assert.Equal(t, "invalid data after top-level value", err.Error())
+
+	_, err = CanonicalJSON(json.RawMessage(`{`))
+	assert.NotNil(t, err)
+}
+
+func TestComputeHashPayload(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	assert.Nil(t, ValidateBlockType(&payloadBlock{}))
+	assert.Nil(t, ValidateBlockType(&rawPayloadBlock{}))
+
+	now := time.Now()
+	details := map[string]interface{}{}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		details[key] = map[string]interface{}{"old": key, "new": float64(len(details))}
+	}
+	block := &payloadBlock{Timestamp: &now, Details: details}
+	hash, err := ComputeHash(block)
+	assert.Nil(t, err)
+	// map iteration order is random
+	for i := 0; i < 10; i++ {
+		again, err := ComputeHash(block)
+		assert.Nil(t, err)
+		assert.Equal(t, hash, again)
+	}
+
+	// key order and whitespace of raw payloads do not matter
+	raw1 := &rawPayloadBlock{Timestamp: &now, Details: json.RawMessage(`{"a": 1, "b": {"c": 2, "d": 3}}`)}
+	raw2 := &rawPayloadBlock{Timestamp: &now, Details: json.RawMessage(`{"b":{"d":3,"c":2},"a":1.0}`)}
+	hash1, err := ComputeHash(raw1)
+	assert.Nil(t, err)
+	hash2, err := ComputeHash(raw2)
+	assert.Nil(t, err)
+	assert.Equal(t, hash1, hash2)
+
+	raw2.Details = json.RawMessage(`{"a": 2}`)
+	hash2, err = ComputeHash(raw2)
+	assert.Nil(t, err)
+	assert.NotEqual(t, hash1, hash2)
+}
+
+func TestDecodeBlock(t *testing.T) {
+	block := &payloadBlock{}
+	err := DecodeBlock([]byte(`{"Details": {"id": 9007199254740993, "amount": 1.5, "items": [{"n": 2}]}}`), block)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"id": int64(9007199254740993), "amount": 1.5, "items": []interface{}{map[string]interface{}{"n": int64(2)}}}, block.Details)
+
+	assert.NotNil(t, DecodeBlock([]byte(`{"Details": {}} x`), block))
+}
+
+func TestComputeHashNestedStruct(t *testing.T) {
+	now := time.Now()
+	headers := map[string]string{"Accept": "*/*", "Content-Type": "application/json", "User-Agent": "curl", "X-Request-Id": "1"}
+	block := &nestedBlock{Timestamp: &now, Request: request{Method: "POST", Headers: headers}}
+	hash, err := ComputeAndSetHash(block)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		block.Hash = ""
+		again, err := ComputeHash(block)
+		assert.Nil(t, err)
+		assert.Equal(t, hash, again)
+	}
+}
+
+func TestComputeHashWithoutMaps(t *testing.T) {
+	// blocks without maps are hashed as before
+	block := &testBlock{Category: "category"}
+	hash, err := ComputeHash(block)
+	assert.Nil(t, err)
+	assert.False(t, SchemaFor(block).hasCanonical)
+	assert.False(t, SchemaFor(&redactableBlock{}).hasCanonical)
+	assert.NotEmpty(t, hash)
+}
+
+func TestValidateBlockTypePayloadErrors(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	s := struct {
+		Timestamp    *time.Time        `auditor:"sort"`
+		Details      map[string]string `auditor:"payload"`
+		Body         string            `auditor:"payload,encrypt"`
+		Hash         string            `auditor:"hash"`
+		PreviousHash string            `auditor:"previoushash"`
+	}{}
+	err := ValidateBlockType(&s)
+	assert.Equal(t, []error{
+		fmt.Errorf("field Details tagged with 'payload' must be one of map[string]interface {}, %v, but got: map[string]string", rawMessageType),
+		fmt.Errorf("field Body tagged with 'payload' must be one of map[string]interface {}, %v, but got: string", rawMessageType),
+		fmt.Errorf("field Body tagged with 'payload' must not be tagged with 'encrypt'"),
+	}, err.(*ValidationError).Errors)
+}
+
+func TestRawPayloadFields(t *testing.T) {
+	assert.Len(t, SchemaFor(&rawPayloadBlock{}).RawPayloadFields(), 1)
+	assert.Len(t, SchemaFor(&payloadBlock{}).RawPayloadFields(), 0)
+
+	payload, err := DecodePayload(json.RawMessage(`{"a": [1, "b"]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"a": []interface{}{int64(1), "b"}}, payload)
+	raw, err := EncodePayload(payload)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":[1,"b"]}`, string(raw))
+
+	payload, err = DecodePayload(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, payload)
+	raw, err = EncodePayload(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, raw)
+}
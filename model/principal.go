@@ -0,0 +1,14 @@
+package model
+
+// HasPrincipal returns true if block type has field tagged with principal
+func (s *BlockSchema) HasPrincipal() bool {
+	return s.principal >= 0
+}
+
+// SetPrincipal sets field tagged with principal to ID of authenticated caller,
+// it does nothing when block type has no such field
+func (s *BlockSchema) SetPrincipal(block interface{}, principal string) {
+	if s.HasPrincipal() {
+		s.field(block, s.principal).SetString(principal)
+	}
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type principalBlock struct {
+	Timestamp    *time.Time `auditor:"sort"`
+	Principal    string     `auditor:"principal"`
+	Hash         string     `auditor:"hash"`
+	PreviousHash string     `auditor:"previoushash"`
+}
+
+func TestSetPrincipal(t *testing.T) {
+	block := &principalBlock{Principal: "forged"}
+	schema := SchemaFor(block)
+	assert.True(t, schema.HasPrincipal())
+	schema.SetPrincipal(block, "billing-service")
+	assert.Equal(t, "billing-service", block.Principal)
+
+	// no-op for block types without principal field
+	plain := &Block{}
+	assert.False(t, SchemaFor(plain).HasPrincipal())
+	SchemaFor(plain).SetPrincipal(plain, "billing-service")
+}
+
+func TestValidateBlockTypePrincipalErrors(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	assert.Nil(t, ValidateBlockType(&principalBlock{}))
+
+	s := struct {
+		Timestamp    *time.Time `auditor:"sort"`
+		Principal    int        `auditor:"principal"`
+		Caller       string     `auditor:"principal,hash"`
+		PreviousHash string     `auditor:"previoushash"`
+	}{}
+	err := ValidateBlockType(&s)
+	assert.Equal(t, []error{
+		errors.New("block type must have at most one field tagged with 'principal', found: 2"),
+		errors.New("field Principal tagged with 'principal' must be string, but got: int"),
+		errors.New("field Caller tagged with 'principal' must not be tagged with 'hash'"),
+	}, err.(*ValidationError).Errors)
+}
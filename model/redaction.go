@@ -0,0 +1,155 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+const (
+	// RedactedPrefix is a prefix of values of redacted fields, it is followed by field commitment
+	RedactedPrefix = "redacted:"
+	saltSize       = 16
+)
+
+var saltsType = reflect.TypeOf(map[string]string{})
+
+// Commitment computes a salted commitment to a value of a field, salt is hex encoded
+func Commitment(salt, name, value string) (string, error) {
+	saltBytes, err := hex.DecodeString(salt)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(saltBytes)
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(value))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// IsRedacted returns true if value is a redacted field value
+func IsRedacted(value string) bool {
+	return strings.HasPrefix(value, RedactedPrefix)
+}
+
+// getSalts returns a copy of salts of given block so that callers can modify it
+func getSalts(block interface{}) map[string]string {
+	field := GetFieldsTaggedWith(block, "salts")[0]
+	salts := map[string]string{}
+	for name, salt := range GetFieldValue(block, field).(map[string]string) {
+		salts[name] = salt
+	}
+	return salts
+}
+
+// setSalts generates salts for not redacted fields which do not have one yet
+func setSalts(block interface{}) error {
+	salts := getSalts(block)
+	for _, field := range GetFieldsTaggedWith(block, "redactable") {
+		if _, ok := salts[field.Name]; ok || IsRedacted(GetFieldStringValue(block, field)) {
+			continue
+		}
+		salt := make([]byte, saltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return err
+		}
+		salts[field.Name] = hex.EncodeToString(salt)
+	}
+	SetFieldValue(block, GetFieldsTaggedWith(block, "salts")[0], salts)
+	return nil
+}
+
+// commitment returns commitment of a redactable field, for redacted fields it is stored in the value
+func commitment(block interface{}, salts map[string]string, field reflect.StructField) (string, error) {
+	value := GetFieldStringValue(block, field)
+	if IsRedacted(value) {
+		return strings.TrimPrefix(value, RedactedPrefix), nil
+	}
+	salt, ok := salts[field.Name]
+	if !ok {
+		return "", fmt.Errorf("field %v has no salt", field.Name)
+	}
+	return Commitment(salt, field.Name, value)
+}
+
+// commitmentsBlock returns a copy of block in which redactable fields are replaced by their commitments
+// and salts and hash are cleared, redacting a field does not change the copy
+func commitmentsBlock(block interface{}) (interface{}, error) {
+	salts := getSalts(block)
+	committed := reflect.New(reflect.TypeOf(block).Elem())
+	committed.Elem().Set(reflect.ValueOf(block).Elem())
+	for _, field := range GetFieldsTaggedWith(block, "redactable") {
+		c, err := commitment(block, salts, field)
+		if err != nil {
+			return nil, err
+		}
+		committed.Elem().FieldByName(field.Name).SetString(c)
+	}
+	committed.Elem().FieldByName(GetFieldsTaggedWith(block, "salts")[0].Name).Set(reflect.Zero(saltsType))
+	committed.Elem().FieldByName(GetFieldsTaggedWith(block, "hash")[0].Name).SetString("")
+	return committed.Interface(), nil
+}
+
+// NotRedactableError is returned when field to be redacted is not tagged with redactable
+type NotRedactableError struct {
+	Field string
+}
+
+func (e *NotRedactableError) Error() string {
+	return fmt.Sprintf("field %v is not redactable", e.Field)
+}
+
+// RedactedValueError is returned when redactable field of a new block has a value which looks like redacted one,
+// it would be taken as a commitment chosen by client instead of a commitment of the original value
+type RedactedValueError struct {
+	Field string
+}
+
+func (e *RedactedValueError) Error() string {
+	return fmt.Sprintf("field %v must not start with %v", e.Field, RedactedPrefix)
+}
+
+// ValidateNotRedacted returns RedactedValueError when any redactable field of a new block looks redacted
+func ValidateNotRedacted(block interface{}) error {
+	for _, field := range GetFieldsTaggedWith(block, "redactable") {
+		if IsRedacted(GetFieldStringValue(block, field)) {
+			return &RedactedValueError{Field: field.Name}
+		}
+	}
+	return nil
+}
+
+// Redact replaces values of given redactable fields with their commitments and removes their salts,
+// block hash is not changed, already redacted fields are skipped
+func Redact(block interface{}, fields []string) error {
+	validateBlock(block)
+	for _, name := range fields {
+		field, ok := reflect.TypeOf(block).Elem().FieldByName(name)
+		if !ok || !hasTag(field, "redactable") {
+			return &NotRedactableError{Field: name}
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	salts := getSalts(block)
+	for _, name := range fields {
+		field, _ := reflect.TypeOf(block).Elem().FieldByName(name)
+		if IsRedacted(GetFieldStringValue(block, field)) {
+			continue
+		}
+		c, err := commitment(block, salts, field)
+		if err != nil {
+			return err
+		}
+		SetFieldValue(block, field, RedactedPrefix+c)
+		delete(salts, field.Name)
+	}
+	SetFieldValue(block, GetFieldsTaggedWith(block, "salts")[0], salts)
+	return nil
+}
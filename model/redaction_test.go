@@ -0,0 +1,159 @@
+package model
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type redactableBlock struct {
+	Timestamp    *time.Time `auditor:"sort"`
+	Event        string
+	Email        string            `auditor:"redactable"`
+	Phone        string            `auditor:"redactable"`
+	Salts        map[string]string `auditor:"salts"`
+	Hash         string            `auditor:"hash"`
+	PreviousHash string            `auditor:"previoushash"`
+}
+
+func TestValidateBlockTypeRedactable(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	assert.Nil(t, ValidateBlockType(&redactableBlock{}))
+}
+
+func TestValidateBlockTypeRedactableErrors(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	// missing salts field
+	s1 := struct {
+		Timestamp    *time.Time `auditor:"sort"`
+		Email        string     `auditor:"redactable"`
+		Hash         string     `auditor:"hash"`
+		PreviousHash string     `auditor:"previoushash"`
+	}{}
+	// redactable field must be a string
+	s2 := struct {
+		Timestamp    *time.Time        `auditor:"sort"`
+		Age          int               `auditor:"redactable"`
+		Salts        map[string]string `auditor:"salts"`
+		Hash         string            `auditor:"hash"`
+		PreviousHash string            `auditor:"previoushash"`
+	}{}
+	// sort field cannot be redacted
+	s3 := struct {
+		Timestamp    string            `auditor:"sort,redactable"`
+		Salts        map[string]string `auditor:"salts"`
+		Hash         string            `auditor:"hash"`
+		PreviousHash string            `auditor:"previoushash"`
+	}{}
+	// salts field must be a map
+	s4 := struct {
+		Timestamp    *time.Time `auditor:"sort"`
+		Email        string     `auditor:"redactable"`
+		Salts        []string   `auditor:"salts"`
+		Hash         string     `auditor:"hash"`
+		PreviousHash string     `auditor:"previoushash"`
+	}{}
+	for _, s := range []interface{}{&s1, &s2, &s3, &s4} {
+		assert.NotNil(t, ValidateBlockType(s))
+	}
+}
+
+func TestComputeAndSetHashRedactable(t *testing.T) {
+	now := time.Now()
+	block := &redactableBlock{Timestamp: &now, Event: "signed up", Email: "john@example.com"}
+	hash, err := ComputeAndSetHash(block)
+	assert.Nil(t, err)
+	assert.Len(t, hash, 64)
+	// salts are generated for all redactable fields
+	assert.Len(t, block.Salts, 2)
+	assert.Len(t, block.Salts["Email"], 2*saltSize)
+
+	// hash can be recomputed and covers values of all fields
+	recomputed, err := ComputeHash(block)
+	assert.Nil(t, err)
+	assert.Equal(t, hash, recomputed)
+	block.Email = "jane@example.com"
+	changed, err := ComputeHash(block)
+	assert.Nil(t, err)
+	assert.NotEqual(t, hash, changed)
+	block.Email = "john@example.com"
+
+	// same values with different salts give different hashes
+	other := &redactableBlock{Timestamp: &now, Event: "signed up", Email: "john@example.com"}
+	otherHash, err := ComputeAndSetHash(other)
+	assert.Nil(t, err)
+	assert.NotEqual(t, hash, otherHash)
+}
+
+func TestRedact(t *testing.T) {
+	now := time.Now()
+	block := &redactableBlock{Timestamp: &now, Event: "signed up", Email: "john@example.com", Phone: "123"}
+	hash, err := ComputeAndSetHash(block)
+	assert.Nil(t, err)
+	salt := block.Salts["Email"]
+
+	err = Redact(block, []string{"Email"})
+	assert.Nil(t, err)
+	assert.True(t, IsRedacted(block.Email))
+	expected, err := Commitment(salt, "Email", "john@example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, RedactedPrefix+expected, block.Email)
+	assert.Equal(t, "123", block.Phone)
+	_, ok := block.Salts["Email"]
+	assert.False(t, ok)
+
+	// hash still verifies
+	recomputed, err := ComputeHash(block)
+	assert.Nil(t, err)
+	assert.Equal(t, hash, recomputed)
+
+	// redacting again is a no-op
+	err = Redact(block, []string{"Email", "Phone"})
+	assert.Nil(t, err)
+	recomputed, err = ComputeHash(block)
+	assert.Nil(t, err)
+	assert.Equal(t, hash, recomputed)
+	assert.Empty(t, block.Salts)
+
+	// computing hash of redacted block does not generate new salts
+	rehashed, err := ComputeAndSetHash(block)
+	assert.Nil(t, err)
+	assert.Equal(t, hash, rehashed)
+	assert.Empty(t, block.Salts)
+}
+
+func TestRedactErrors(t *testing.T) {
+	now := time.Now()
+	block := &redactableBlock{Timestamp: &now, Email: "john@example.com"}
+	_, err := ComputeAndSetHash(block)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "field Event is not redactable", Redact(block, []string{"Event"}).Error())
+	assert.Equal(t, "field Unknown is not redactable", Redact(block, []string{"Unknown"}).Error())
+	assert.Equal(t, &NotRedactableError{Field: "Category"}, Redact(&testBlock{}, []string{"Category"}))
+	assert.Nil(t, Redact(&testBlock{}, []string{}))
+
+	// salt lost
+	delete(block.Salts, "Email")
+	assert.Equal(t, "field Email has no salt", Redact(block, []string{"Email"}).Error())
+	_, err = ComputeHash(block)
+	assert.True(t, strings.Contains(err.Error(), "has no salt"))
+}
+
+func TestValidateNotRedacted(t *testing.T) {
+	now := time.Now()
+	block := &redactableBlock{Timestamp: &now, Event: "signed up", Email: "john@example.com"}
+	assert.Nil(t, ValidateNotRedacted(block))
+
+	block.Phone = RedactedPrefix + "0123456789abcdef"
+	assert.Equal(t, &RedactedValueError{Field: "Phone"}, ValidateNotRedacted(block))
+	assert.Equal(t, "field Phone must not start with redacted:", ValidateNotRedacted(block).Error())
+
+	// fields which are not redactable can hold any value
+	block.Phone = ""
+	block.Event = RedactedPrefix + "0123456789abcdef"
+	assert.Nil(t, ValidateNotRedacted(block))
+}
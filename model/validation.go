@@ -0,0 +1,268 @@
+package model
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule validates block type and returns all violations found
+type Rule func(t reflect.Type) []error
+
+// ValidationError lists all violations found in a block type
+type ValidationError struct {
+	Type   string
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("block type %v is invalid: %v", e.Type, strings.Join(messages, "; "))
+}
+
+var (
+	knownTags = []string{"hash", "previoushash", "sort", "dynamodb_partition", "mongodb_index", "encrypt", "subject", "redactable", "salts", "payload", "version", "clienttime", "skewed", "principal", "idempotencykey", "fingerprint"}
+	timeType  = reflect.TypeOf(&time.Time{})
+	int64Type = reflect.TypeOf(int64(0))
+	// sortTypes lists types supported by sort field
+	sortTypes = []reflect.Type{timeType, int64Type, reflect.TypeOf("")}
+
+	rules        = []Rule{tagsRule, chainRule, encryptRule, subjectRule, redactableRule, payloadRule, versionRule, clockRule, principalRule, idempotencyRule}
+	backendRules = make(map[string][]Rule)
+	backendLock  = &sync.Mutex{}
+)
+
+// RegisterRules registers rules which are applied in addition to common rules when AUDITOR_STORE is set to backend,
+// store packages register their rules in init functions
+func RegisterRules(backend string, rules ...Rule) {
+	backendLock.Lock()
+	defer backendLock.Unlock()
+	backendRules[backend] = append(backendRules[backend], rules...)
+}
+
+// ValidateBlockType validates if passed pointer to struct is a valid auditor block,
+// returns ValidationError listing all violations of common rules and rules of backend set in AUDITOR_STORE
+func ValidateBlockType(block interface{}) error {
+	if err := checkBlock(block); err != nil {
+		return err
+	}
+	t := reflect.TypeOf(block).Elem()
+	backendLock.Lock()
+	all := append(append([]Rule{}, rules...), backendRules[os.Getenv("AUDITOR_STORE")]...)
+	backendLock.Unlock()
+
+	errors := []error{}
+	for _, rule := range all {
+		errors = append(errors, rule(t)...)
+	}
+	if len(errors) > 0 {
+		name := t.Name()
+		if len(name) == 0 {
+			name = "struct"
+		}
+		return &ValidationError{Type: name, Errors: errors}
+	}
+	return nil
+}
+
+// MustValidateBlockType is like ValidateBlockType but panics if block type is invalid
+func MustValidateBlockType(block interface{}) {
+	if err := ValidateBlockType(block); err != nil {
+		panic(err.Error())
+	}
+}
+
+// ExactlyOne returns error if there is not exactly one field tagged with tag
+func ExactlyOne(t reflect.Type, tag string) []error {
+	if fields := GetTypeFieldsTaggedWith(t, tag); len(fields) != 1 {
+		return []error{fmt.Errorf("block type must have one field tagged with '%v', found: %v", tag, len(fields))}
+	}
+	return nil
+}
+
+// Exported returns errors for all unexported fields tagged with tag
+func Exported(t reflect.Type, tag string) []error {
+	errors := []error{}
+	for _, field := range GetTypeFieldsTaggedWith(t, tag) {
+		if len(field.PkgPath) > 0 {
+			errors = append(errors, fmt.Errorf("field %v tagged with '%v' must be exported", field.Name, tag))
+		}
+	}
+	return errors
+}
+
+// OfType returns errors for all fields tagged with tag which are not of any of expected types
+func OfType(t reflect.Type, tag string, expected ...reflect.Type) []error {
+	names := make([]string, len(expected))
+	for i, e := range expected {
+		names[i] = e.String()
+	}
+	description := names[0]
+	if len(names) > 1 {
+		description = fmt.Sprintf("one of %v", strings.Join(names, ", "))
+	}
+	errors := []error{}
+	for _, field := range GetTypeFieldsTaggedWith(t, tag) {
+		if !isOneOf(field.Type, expected) {
+			errors = append(errors, fmt.Errorf("field %v tagged with '%v' must be %v, but got: %v", field.Name, tag, description, field.Type))
+		}
+	}
+	return errors
+}
+
+func isOneOf(t reflect.Type, types []reflect.Type) bool {
+	for _, other := range types {
+		if t == other {
+			return true
+		}
+	}
+	return false
+}
+
+// notTaggedWith returns errors for all fields tagged with tag which are also tagged with any of others
+func notTaggedWith(t reflect.Type, tag string, others ...string) []error {
+	errors := []error{}
+	for _, field := range GetTypeFieldsTaggedWith(t, tag) {
+		for _, other := range others {
+			if hasTag(field, other) {
+				errors = append(errors, fmt.Errorf("field %v tagged with '%v' must not be tagged with '%v'", field.Name, tag, other))
+			}
+		}
+	}
+	return errors
+}
+
+// tagsRule reports misspelled tags
+func tagsRule(t reflect.Type) []error {
+	errors := []error{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value, ok := field.Tag.Lookup("auditor")
+		if !ok {
+			continue
+		}
+		for _, tag := range strings.Split(value, ",") {
+			if len(tag) > 0 && !isKnownTag(tag) {
+				errors = append(errors, fmt.Errorf("field %v has unknown auditor tag: '%v'", field.Name, tag))
+			}
+		}
+	}
+	return errors
+}
+
+func isKnownTag(tag string) bool {
+	for _, known := range knownTags {
+		if tag == known {
+			return true
+		}
+	}
+	return false
+}
+
+func chainRule(t reflect.Type) []error {
+	errors := []error{}
+	for _, tag := range []string{"hash", "previoushash", "sort"} {
+		errors = append(errors, ExactlyOne(t, tag)...)
+		errors = append(errors, Exported(t, tag)...)
+	}
+	errors = append(errors, OfType(t, "hash", reflect.TypeOf(""))...)
+	errors = append(errors, OfType(t, "previoushash", reflect.TypeOf(""))...)
+	errors = append(errors, OfType(t, "sort", sortTypes...)...)
+	return errors
+}
+
+func encryptRule(t reflect.Type) []error {
+	errors := OfType(t, "encrypt", reflect.TypeOf(""))
+	errors = append(errors, Exported(t, "encrypt")...)
+	return append(errors, notTaggedWith(t, "encrypt", "hash", "previoushash", "sort", "dynamodb_partition")...)
+}
+
+// subjectRule requires at most one string subject field, subjects are replaced by pseudonyms in saved blocks
+// so they cannot be used to find blocks
+func subjectRule(t reflect.Type) []error {
+	errors := []error{}
+	if fields := GetTypeFieldsTaggedWith(t, "subject"); len(fields) > 1 {
+		errors = append(errors, fmt.Errorf("block type must have at most one field tagged with 'subject', found: %v", len(fields)))
+	}
+	errors = append(errors, OfType(t, "subject", reflect.TypeOf(""))...)
+	errors = append(errors, Exported(t, "subject")...)
+	return append(errors, notTaggedWith(t, "subject", "encrypt", "hash", "previoushash", "sort", "dynamodb_partition")...)
+}
+
+func redactableRule(t reflect.Type) []error {
+	errors := OfType(t, "redactable", reflect.TypeOf(""))
+	errors = append(errors, Exported(t, "redactable")...)
+	errors = append(errors, notTaggedWith(t, "redactable", "hash", "previoushash", "sort", "dynamodb_partition", "subject")...)
+	salts := GetTypeFieldsTaggedWith(t, "salts")
+	if len(GetTypeFieldsTaggedWith(t, "redactable")) > 0 && len(salts) != 1 {
+		errors = append(errors, fmt.Errorf("block type with redactable fields must have one field tagged with 'salts', found: %v", len(salts)))
+	}
+	errors = append(errors, OfType(t, "salts", saltsType)...)
+	return append(errors, Exported(t, "salts")...)
+}
+
+func payloadRule(t reflect.Type) []error {
+	errors := OfType(t, "payload", payloadTypes...)
+	errors = append(errors, Exported(t, "payload")...)
+	return append(errors, notTaggedWith(t, "payload", "hash", "previoushash", "sort", "dynamodb_partition", "mongodb_index", "encrypt", "subject", "redactable", "salts")...)
+}
+
+func versionRule(t reflect.Type) []error {
+	errors := []error{}
+	if fields := GetTypeFieldsTaggedWith(t, "version"); len(fields) > 1 {
+		errors = append(errors, fmt.Errorf("block type must have at most one field tagged with 'version', found: %v", len(fields)))
+	}
+	errors = append(errors, OfType(t, "version", int64Type)...)
+	errors = append(errors, Exported(t, "version")...)
+	return append(errors, notTaggedWith(t, "version", "hash", "previoushash", "sort", "dynamodb_partition", "encrypt", "subject", "redactable", "salts", "payload")...)
+}
+
+func clockRule(t reflect.Type) []error {
+	errors := []error{}
+	for _, tag := range []string{"clienttime", "skewed"} {
+		if fields := GetTypeFieldsTaggedWith(t, tag); len(fields) > 1 {
+			errors = append(errors, fmt.Errorf("block type must have at most one field tagged with '%v', found: %v", tag, len(fields)))
+		}
+		errors = append(errors, Exported(t, tag)...)
+		errors = append(errors, notTaggedWith(t, tag, "hash", "previoushash", "sort", "dynamodb_partition", "encrypt", "subject", "redactable", "salts", "payload", "version")...)
+	}
+	// client time is a copy of sort value sent by client
+	if sort := GetTypeFieldsTaggedWith(t, "sort"); len(sort) == 1 {
+		errors = append(errors, OfType(t, "clienttime", sort[0].Type)...)
+	}
+	return append(errors, OfType(t, "skewed", reflect.TypeOf(true))...)
+}
+
+func principalRule(t reflect.Type) []error {
+	errors := []error{}
+	if fields := GetTypeFieldsTaggedWith(t, "principal"); len(fields) > 1 {
+		errors = append(errors, fmt.Errorf("block type must have at most one field tagged with 'principal', found: %v", len(fields)))
+	}
+	errors = append(errors, OfType(t, "principal", reflect.TypeOf(""))...)
+	errors = append(errors, Exported(t, "principal")...)
+	return append(errors, notTaggedWith(t, "principal", "hash", "previoushash", "sort", "dynamodb_partition", "encrypt", "subject", "redactable", "salts", "payload", "version", "clienttime", "skewed")...)
+}
+
+func idempotencyRule(t reflect.Type) []error {
+	errors := []error{}
+	for _, tag := range []string{"idempotencykey", "fingerprint"} {
+		if fields := GetTypeFieldsTaggedWith(t, tag); len(fields) > 1 {
+			errors = append(errors, fmt.Errorf("block type must have at most one field tagged with '%v', found: %v", tag, len(fields)))
+		}
+		errors = append(errors, OfType(t, tag, reflect.TypeOf(""))...)
+		errors = append(errors, Exported(t, tag)...)
+		errors = append(errors, notTaggedWith(t, tag, "hash", "previoushash", "sort", "dynamodb_partition", "encrypt", "subject", "redactable", "salts", "payload", "version", "clienttime", "skewed", "principal")...)
+	}
+	// fingerprint of payload is compared when key is repeated
+	keys, fingerprints := GetTypeFieldsTaggedWith(t, "idempotencykey"), GetTypeFieldsTaggedWith(t, "fingerprint")
+	if (len(keys) == 0) != (len(fingerprints) == 0) {
+		errors = append(errors, fmt.Errorf("block type must have both fields tagged with 'idempotencykey' and 'fingerprint' or none of them"))
+	}
+	return errors
+}
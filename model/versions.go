@@ -0,0 +1,148 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Upcaster populates fields added in the next version of block type, block is a pointer to current block type
+// which already has values of all fields of the old version
+type Upcaster func(block interface{}) error
+
+// version is an old version of block type
+type version struct {
+	number   int64
+	oldType  reflect.Type
+	upcaster Upcaster
+}
+
+var (
+	versions     = make(map[reflect.Type][]*version)
+	versionsLock = &sync.RWMutex{}
+)
+
+// RegisterVersion registers old version of block type t: struct type in which blocks of that version were saved and hashed
+// and upcaster which converts them into the next version (can be nil if new fields keep zero values),
+// old type must have the same name as t had then (gob serialization includes it), all its exported fields must exist in t
+// with the same types, and t must have a field tagged with version, version 0 is reserved for blocks saved before t had it
+func RegisterVersion(t reflect.Type, number int64, old reflect.Type, upcaster Upcaster) error {
+	if len(GetTypeFieldsTaggedWith(t, "version")) != 1 {
+		return fmt.Errorf("block type %v must have one field tagged with 'version'", t)
+	}
+	if number < 0 {
+		return fmt.Errorf("version must not be negative, but got: %v", number)
+	}
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Field(i)
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+		current, ok := t.FieldByName(field.Name)
+		if !ok || current.Type != field.Type {
+			return fmt.Errorf("field %v of version %v must exist in block type %v with type %v", field.Name, number, t, field.Type)
+		}
+	}
+
+	versionsLock.Lock()
+	defer versionsLock.Unlock()
+	for _, v := range versions[t] {
+		if v.number == number {
+			return fmt.Errorf("version %v of block type %v is already registered", number, t)
+		}
+	}
+	// registered versions are read without lock thus a new slice is created
+	updated := append(append([]*version{}, versions[t]...), &version{number: number, oldType: old, upcaster: upcaster})
+	sort.Slice(updated, func(i, j int) bool { return updated[i].number < updated[j].number })
+	versions[t] = updated
+	return nil
+}
+
+// registeredVersions returns old versions of block type sorted by number, returned slice must not be modified
+func registeredVersions(t reflect.Type) []*version {
+	versionsLock.RLock()
+	defer versionsLock.RUnlock()
+	return versions[t]
+}
+
+// CurrentVersion returns version of blocks saved now: one more than the latest registered old version,
+// 1 when there are no old versions (0 is never current, blocks saved before version field was added have it)
+func CurrentVersion(t reflect.Type) int64 {
+	registered := registeredVersions(t)
+	if len(registered) == 0 {
+		return 1
+	}
+	return registered[len(registered)-1].number + 1
+}
+
+// blockVersion returns version of block, blocks saved before version field was added are version 0
+func blockVersion(block interface{}, field reflect.StructField) int64 {
+	return reflect.ValueOf(block).Elem().FieldByIndex(field.Index).Int()
+}
+
+// setVersion sets version field of a block being saved to current version
+func setVersion(block interface{}) {
+	if fields := SchemaFor(block).FieldsTaggedWith("version"); len(fields) == 1 {
+		reflect.ValueOf(block).Elem().FieldByIndex(fields[0].Index).SetInt(CurrentVersion(reflect.TypeOf(block).Elem()))
+	}
+}
+
+// Upcast converts block of an old version into the current shape by running upcasters of all versions
+// between its version and the current one, version field keeps the original version so that hash can be verified
+func Upcast(block interface{}) error {
+	t := reflect.TypeOf(block).Elem()
+	registered := registeredVersions(t)
+	if len(registered) == 0 {
+		return nil
+	}
+	number := blockVersion(block, SchemaOf(t).FieldsTaggedWith("version")[0])
+	for _, v := range registered {
+		if v.number < number || v.upcaster == nil {
+			continue
+		}
+		if err := v.upcaster(block); err != nil {
+			return fmt.Errorf("could not upcast block from version %v: %v", v.number, err.Error())
+		}
+	}
+	return nil
+}
+
+// UpcastAll upcasts all blocks in result which is a pointer to slice of blocks
+func UpcastAll(result interface{}) error {
+	slicev := reflect.ValueOf(result).Elem()
+	if len(registeredVersions(slicev.Type().Elem())) == 0 {
+		return nil
+	}
+	for i := 0; i < slicev.Len(); i++ {
+		if err := Upcast(slicev.Index(i).Addr().Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// original returns block in the shape it was hashed in: for old versions a copy of block in old version type
+func original(block interface{}) (interface{}, error) {
+	t := reflect.TypeOf(block).Elem()
+	registered := registeredVersions(t)
+	if len(registered) == 0 {
+		return block, nil
+	}
+	number := blockVersion(block, SchemaOf(t).FieldsTaggedWith("version")[0])
+	if number == CurrentVersion(t) {
+		return block, nil
+	}
+	for _, v := range registered {
+		if v.number == number {
+			old := reflect.New(v.oldType)
+			for i := 0; i < v.oldType.NumField(); i++ {
+				if field := v.oldType.Field(i); len(field.PkgPath) == 0 {
+					old.Elem().Field(i).Set(reflect.ValueOf(block).Elem().FieldByName(field.Name))
+				}
+			}
+			return old.Interface(), nil
+		}
+	}
+	return nil, fmt.Errorf("version %v of block type %v is not registered", number, t)
+}
@@ -0,0 +1,140 @@
+package model
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// versionedBlock is version 2, Version field was added in version 1 and Severity in version 2
+type versionedBlock struct {
+	Timestamp    *time.Time `auditor:"sort"`
+	Version      int64      `auditor:"version"`
+	Category     string
+	Severity     string
+	Hash         string `auditor:"hash"`
+	PreviousHash string `auditor:"previoushash"`
+}
+
+func TestVersions(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	assert.Nil(t, ValidateBlockType(&versionedBlock{}))
+
+	// version 0 as it was declared when its blocks were saved, before version field was added
+	type versionedBlock0 struct {
+		Timestamp    *time.Time `auditor:"sort"`
+		Category     string
+		Hash         string `auditor:"hash"`
+		PreviousHash string `auditor:"previoushash"`
+	}
+	// version 1, its blocks were saved before any old version was registered
+	type versionedBlock1 struct {
+		Timestamp    *time.Time `auditor:"sort"`
+		Version      int64      `auditor:"version"`
+		Category     string
+		Hash         string `auditor:"hash"`
+		PreviousHash string `auditor:"previoushash"`
+	}
+	now := time.Now()
+	old := &versionedBlock0{Timestamp: &now, Category: "security"}
+	hash, err := ComputeAndSetHash(old)
+	assert.Nil(t, err)
+	old1 := &versionedBlock1{Timestamp: &now, Category: "security"}
+	_, err = ComputeAndSetHash(old1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), old1.Version)
+
+	blockType := reflect.TypeOf(versionedBlock{})
+	assert.Equal(t, int64(1), CurrentVersion(blockType))
+	assert.Nil(t, RegisterVersion(blockType, 0, reflect.TypeOf(versionedBlock0{}), nil))
+	assert.Equal(t, int64(1), CurrentVersion(blockType))
+	err = RegisterVersion(blockType, 1, reflect.TypeOf(versionedBlock1{}), func(block interface{}) error {
+		b := block.(*versionedBlock)
+		if b.Category == "security" {
+			b.Severity = "high"
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), CurrentVersion(blockType))
+
+	// old blocks read from store into current type
+	blocks := []versionedBlock{
+		{Timestamp: old.Timestamp, Category: old.Category, Hash: old.Hash},
+		{Timestamp: old1.Timestamp, Version: old1.Version, Category: old1.Category, Hash: old1.Hash},
+	}
+	assert.Nil(t, UpcastAll(&blocks))
+	assert.Equal(t, "high", blocks[0].Severity)
+	assert.Equal(t, int64(0), blocks[0].Version)
+	assert.Equal(t, "high", blocks[1].Severity)
+	assert.Equal(t, int64(1), blocks[1].Version)
+	// hashes are computed according to versions 0 and 1
+	assert.Nil(t, VerifyHash(&blocks[0]))
+	assert.Nil(t, VerifyHash(&blocks[1]))
+	computed, err := ComputeHash(&versionedBlock{Timestamp: old.Timestamp, Category: old.Category})
+	assert.Nil(t, err)
+	assert.Equal(t, hash, computed)
+
+	blocks[0].Category = "tampered"
+	assert.NotNil(t, VerifyHash(&blocks[0]))
+
+	// new blocks are saved as the current version
+	block := &versionedBlock{Timestamp: &now, Category: "security", Severity: "low"}
+	_, err = ComputeAndSetHash(block)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), block.Version)
+	assert.Nil(t, Upcast(block))
+	assert.Equal(t, "low", block.Severity)
+	assert.Nil(t, VerifyHash(block))
+
+	block.Version = 3
+	assert.NotNil(t, VerifyHash(block))
+}
+
+func TestUpcastError(t *testing.T) {
+	type upcastBlock struct {
+		Timestamp    *time.Time `auditor:"sort"`
+		Version      int64      `auditor:"version"`
+		Hash         string     `auditor:"hash"`
+		PreviousHash string     `auditor:"previoushash"`
+	}
+	type upcastBlock1 struct {
+		Timestamp *time.Time
+	}
+	blockType := reflect.TypeOf(upcastBlock{})
+	err := RegisterVersion(blockType, 1, reflect.TypeOf(upcastBlock1{}), func(block interface{}) error {
+		return errors.New("missing data")
+	})
+	assert.Nil(t, err)
+	err = Upcast(&upcastBlock{Version: 1})
+	assert.Equal(t, "could not upcast block from version 1: missing data", err.Error())
+	assert.Nil(t, Upcast(&upcastBlock{Version: 2}))
+}
+
+func TestRegisterVersionErrors(t *testing.T) {
+	type oldBlock struct {
+		Category string
+	}
+	err := RegisterVersion(reflect.TypeOf(testBlock{}), 1, reflect.TypeOf(oldBlock{}), nil)
+	assert.Equal(t, "block type model.testBlock must have one field tagged with 'version'", err.Error())
+
+	type currentBlock struct {
+		Version  int64 `auditor:"version"`
+		Category int
+	}
+	err = RegisterVersion(reflect.TypeOf(currentBlock{}), -1, reflect.TypeOf(oldBlock{}), nil)
+	assert.Equal(t, "version must not be negative, but got: -1", err.Error())
+	err = RegisterVersion(reflect.TypeOf(currentBlock{}), 1, reflect.TypeOf(oldBlock{}), nil)
+	assert.Equal(t, "field Category of version 1 must exist in block type model.currentBlock with type string", err.Error())
+
+	type renamedBlock struct {
+		Version int64 `auditor:"version"`
+	}
+	assert.Nil(t, RegisterVersion(reflect.TypeOf(renamedBlock{}), 1, reflect.TypeOf(struct{}{}), nil))
+	err = RegisterVersion(reflect.TypeOf(renamedBlock{}), 1, reflect.TypeOf(struct{}{}), nil)
+	assert.Equal(t, "version 1 of block type model.renamedBlock is already registered", err.Error())
+}
@@ -0,0 +1,152 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/checkpoint"
+	"github.com/lukaszbudnik/auditor/encryption"
+	"github.com/lukaszbudnik/auditor/export"
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store"
+)
+
+// types maps type names which can be used in schema to Go types
+var types = map[string]reflect.Type{
+	"string":            reflect.TypeOf(""),
+	"int64":             reflect.TypeOf(int64(0)),
+	"float64":           reflect.TypeOf(float64(0)),
+	"bool":              reflect.TypeOf(false),
+	"time":              reflect.TypeOf(&time.Time{}),
+	"[]string":          reflect.TypeOf([]string{}),
+	"map[string]string": reflect.TypeOf(map[string]string{}),
+	"object":            reflect.TypeOf(map[string]interface{}{}),
+}
+
+// Field describes a single block field, Auditor contains values of auditor tag (sort, hash, etc.)
+// and Validate contains validation rules in gopkg.in/validator.v2 format
+type Field struct {
+	Name     string
+	Type     string
+	Auditor  []string
+	Validate string
+}
+
+// Schema describes block type
+type Schema struct {
+	Fields []Field
+}
+
+// namePattern restricts block type names so that they can be used as routes and collection or table names
+var namePattern = regexp.MustCompile("^[a-z][a-z0-9_]{0,47}$")
+
+// reserved names are used by default audit chain, by checkpoints, by export endpoint, and by subject keys
+var reserved = []string{store.DefaultName, checkpoint.Name, export.Name, encryption.SubjectsName}
+
+// Enabled returns true when AUDITOR_SCHEMA is set
+func Enabled() bool {
+	return len(os.Getenv("AUDITOR_SCHEMA")) > 0
+}
+
+// TypesEnabled returns true when AUDITOR_BLOCK_TYPES is set
+func TypesEnabled() bool {
+	return len(os.Getenv("AUDITOR_BLOCK_TYPES")) > 0
+}
+
+// Load loads schema from JSON file and builds block type
+func Load(path string) (reflect.Type, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	schema := &Schema{}
+	if err := json.Unmarshal(bytes, schema); err != nil {
+		return nil, err
+	}
+	return Build(schema)
+}
+
+// LoadTypes loads named schemas from JSON file and builds block types
+func LoadTypes(path string) (map[string]reflect.Type, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	schemas := make(map[string]*Schema)
+	if err := json.Unmarshal(bytes, &schemas); err != nil {
+		return nil, err
+	}
+	types := make(map[string]reflect.Type)
+	for name, schema := range schemas {
+		if err := validateName(name); err != nil {
+			return nil, err
+		}
+		blockType, err := Build(schema)
+		if validationError, ok := err.(*model.ValidationError); ok {
+			validationError.Type = name
+			return nil, validationError
+		}
+		if err != nil {
+			return nil, fmt.Errorf("block type %v: %v", name, err.Error())
+		}
+		types[name] = blockType
+	}
+	return types, nil
+}
+
+func validateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("block type name must match %v, but got: %v", namePattern, name)
+	}
+	for _, r := range reserved {
+		if name == r {
+			return fmt.Errorf("block type name is reserved: %v", name)
+		}
+	}
+	return nil
+}
+
+// Build builds block type from schema, block type is validated using model.ValidateBlockType
+// and model.ValidationError is returned if it is invalid
+func Build(schema *Schema) (reflect.Type, error) {
+	if len(schema.Fields) == 0 {
+		return nil, fmt.Errorf("schema must have at least one field")
+	}
+	names := make(map[string]bool)
+	fields := []reflect.StructField{}
+	for _, f := range schema.Fields {
+		if !token.IsIdentifier(f.Name) || !token.IsExported(f.Name) {
+			return nil, fmt.Errorf("field name must be an exported Go identifier, but got: %v", f.Name)
+		}
+		if names[f.Name] {
+			return nil, fmt.Errorf("duplicate field: %v", f.Name)
+		}
+		names[f.Name] = true
+		t, ok := types[f.Type]
+		if !ok {
+			return nil, fmt.Errorf("field %v has unknown type: %v", f.Name, f.Type)
+		}
+		// values are quoted so that quotes and backslashes in validation rules do not corrupt the tag
+		tags := []string{}
+		if len(f.Auditor) > 0 {
+			tags = append(tags, "auditor:"+strconv.Quote(strings.Join(f.Auditor, ",")))
+		}
+		if len(f.Validate) > 0 {
+			tags = append(tags, "validate:"+strconv.Quote(f.Validate))
+		}
+		fields = append(fields, reflect.StructField{Name: f.Name, Type: t, Tag: reflect.StructTag(strings.Join(tags, " "))})
+	}
+	blockType := reflect.StructOf(fields)
+	if err := model.ValidateBlockType(reflect.New(blockType).Interface()); err != nil {
+		return nil, err
+	}
+	return blockType, nil
+}
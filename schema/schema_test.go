@@ -0,0 +1,141 @@
+package schema
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/validator.v2"
+)
+
+const testSchema = `{
+  "Fields": [
+    {"Name": "Customer", "Type": "string", "Auditor": ["dynamodb_partition", "mongodb_index"]},
+    {"Name": "Timestamp", "Type": "time", "Auditor": ["sort", "mongodb_index"], "Validate": "nonzero"},
+    {"Name": "Amount", "Type": "float64"},
+    {"Name": "Event", "Type": "string", "Validate": "nonzero"},
+    {"Name": "Hash", "Type": "string", "Auditor": ["hash"]},
+    {"Name": "PreviousHash", "Type": "string", "Auditor": ["previoushash"]}
+  ]
+}`
+
+func writeSchema(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "schema")
+	assert.Nil(t, err)
+	file.WriteString(content)
+	file.Close()
+	return file.Name()
+}
+
+func TestLoad(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	path := writeSchema(t, testSchema)
+	defer os.Remove(path)
+
+	blockType, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, blockType.NumField())
+	amount, _ := blockType.FieldByName("Amount")
+	assert.Equal(t, reflect.TypeOf(float64(0)), amount.Type)
+
+	block := reflect.New(blockType).Interface()
+	sort := model.GetFieldsTaggedWith(block, "sort")
+	assert.Len(t, sort, 1)
+	assert.Equal(t, "Timestamp", sort[0].Name)
+	assert.Len(t, model.GetFieldsTaggedWith(block, "mongodb_index"), 2)
+
+	// validation rules are applied
+	assert.NotNil(t, validator.Validate(block))
+	now := time.Now()
+	model.SetFieldValue(block, sort[0], &now)
+	event, _ := blockType.FieldByName("Event")
+	model.SetFieldValue(block, event, "something happened")
+	assert.Nil(t, validator.Validate(block))
+
+	// dynamic blocks can be hashed
+	h, err := model.ComputeAndSetHash(block)
+	assert.Nil(t, err)
+	assert.Len(t, h, 64)
+}
+
+func TestLoadErrors(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	_, err := Load("/does/not/exist.json")
+	assert.NotNil(t, err)
+
+	invalid := map[string]string{
+		`{"Fields": []}`: "schema must have at least one field",
+		`{"Fields": [{"Name": "event", "Type": "string"}]}`:                                      "field name must be an exported Go identifier, but got: event",
+		`{"Fields": [{"Name": "Event", "Type": "string"}, {"Name": "Event", "Type": "string"}]}`: "duplicate field: Event",
+		`{"Fields": [{"Name": "Event", "Type": "uint8"}]}`:                                       "field Event has unknown type: uint8",
+		`{"Fields": [{"Name": "Event", "Type": "string"}]}`:                                      "block type struct is invalid: block type must have one field tagged with 'hash', found: 0; block type must have one field tagged with 'previoushash', found: 0; block type must have one field tagged with 'sort', found: 0",
+	}
+	for content, message := range invalid {
+		path := writeSchema(t, content)
+		_, err := Load(path)
+		os.Remove(path)
+		assert.Equal(t, message, err.Error())
+	}
+}
+
+func TestLoadQuotedTags(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	path := writeSchema(t, `{
+  "Fields": [
+    {"Name": "Event", "Type": "string", "Validate": "regexp=^\"[a-z`+"`"+`]+\"$"},
+    {"Name": "Timestamp", "Type": "int64", "Auditor": ["sort"]},
+    {"Name": "Hash", "Type": "string", "Auditor": ["hash"]},
+    {"Name": "PreviousHash", "Type": "string", "Auditor": ["previoushash"]}
+  ]
+}`)
+	defer os.Remove(path)
+
+	blockType, err := Load(path)
+	assert.Nil(t, err)
+	event, _ := blockType.FieldByName("Event")
+	assert.Equal(t, "regexp=^\"[a-z`]+\"$", event.Tag.Get("validate"))
+
+	block := reflect.New(blockType).Interface()
+	model.SetFieldValue(block, event, "\"a`b\"")
+	assert.Nil(t, validator.Validate(block))
+	model.SetFieldValue(block, event, "ab")
+	assert.NotNil(t, validator.Validate(block))
+}
+
+func TestLoadTypes(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	path := writeSchema(t, fmt.Sprintf(`{"user_actions": %v, "data_access": %v}`, testSchema, testSchema))
+	defer os.Remove(path)
+
+	types, err := LoadTypes(path)
+	assert.Nil(t, err)
+	assert.Len(t, types, 2)
+	assert.Equal(t, 6, types["user_actions"].NumField())
+	assert.Equal(t, 6, types["data_access"].NumField())
+}
+
+func TestLoadTypesErrors(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	_, err := LoadTypes("/does/not/exist.json")
+	assert.NotNil(t, err)
+
+	invalid := map[string]string{
+		fmt.Sprintf(`{"UserActions": %v}`, testSchema):                        "block type name must match ^[a-z][a-z0-9_]{0,47}$, but got: UserActions",
+		fmt.Sprintf(`{"audit": %v}`, testSchema):                              "block type name is reserved: audit",
+		fmt.Sprintf(`{"checkpoint": %v}`, testSchema):                         "block type name is reserved: checkpoint",
+		fmt.Sprintf(`{"export": %v}`, testSchema):                             "block type name is reserved: export",
+		fmt.Sprintf(`{"subjects": %v}`, testSchema):                           "block type name is reserved: subjects",
+		`{"user_actions": {"Fields": [{"Name": "Event", "Type": "string"}]}}`: "block type user_actions is invalid: block type must have one field tagged with 'hash', found: 0; block type must have one field tagged with 'previoushash', found: 0; block type must have one field tagged with 'sort', found: 0",
+	}
+	for content, message := range invalid {
+		path := writeSchema(t, content)
+		_, err := LoadTypes(path)
+		os.Remove(path)
+		assert.Equal(t, message, err.Error())
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store"
+	"github.com/lukaszbudnik/migrator/common"
+)
+
+// AccessName is a name of collection (MongoDB) or table (DynamoDB) which holds access chain
+const AccessName = "access"
+
+var accessType = reflect.TypeOf(model.Access{})
+
+// AccessLogEnabled returns true when AUDITOR_ACCESS_LOG is set to true
+func AccessLogEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("AUDITOR_ACCESS_LOG"))
+	return enabled
+}
+
+// accessLog records reads of a chain in access chain, nil accessLog records nothing
+type accessLog struct {
+	store store.Store
+	chain string
+}
+
+func newAccessLog(store store.Store, chain string) *accessLog {
+	if store == nil {
+		return nil
+	}
+	return &accessLog{store: store, chain: chain}
+}
+
+// record appends access block to access chain, reads must not be answered when it fails
+func (a *accessLog) record(r *http.Request, operation string, filters url.Values, records int) error {
+	if a == nil {
+		return nil
+	}
+	access := &model.Access{Chain: a.chain, Operation: operation, Filters: filters.Encode(), Records: int64(records)}
+	model.SchemaOf(accessType).SetSort(access, sortNow(accessType))
+	access.RequestID, _ = r.Context().Value(common.RequestIDKey{}).(string)
+	setPrincipal(r, access)
+	if err := saveSequenced(a.store, access); err != nil {
+		common.LogError(r.Context(), "Could not record access: %v", err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/auth"
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingStore fails every Save
+type failingStore struct {
+	mockRecordStore
+}
+
+func (s *failingStore) Save(block interface{}) error {
+	return errors.New("access store unavailable")
+}
+
+func TestAccessLogEnabled(t *testing.T) {
+	defer os.Setenv("AUDITOR_ACCESS_LOG", "")
+	assert.False(t, AccessLogEnabled())
+	os.Setenv("AUDITOR_ACCESS_LOG", "true")
+	assert.True(t, AccessLogEnabled())
+	os.Setenv("AUDITOR_ACCESS_LOG", "yes please")
+	assert.False(t, AccessLogEnabled())
+}
+
+func TestAccessBlockType(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	assert.Nil(t, model.ValidateBlockType(&model.Access{}))
+}
+
+func TestAccessRecordedOnRead(t *testing.T) {
+	now := time.Now()
+	audit := []model.Block{{Customer: "billing", Timestamp: &now, Event: "paid", Hash: "a"}, {Customer: "billing", Timestamp: &now, Event: "refunded", Hash: "b"}}
+	access := &mockRecordStore{}
+	router := registerHandlers(newMockStoreWithAudit(audit)(), &Config{Access: access})
+
+	for i := 0; i < 2; i++ {
+		req := newAuthorizedRequest("alice", http.MethodGet, "http://example.com/audit?limit=10&Customer=billing", "")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	assert.Len(t, access.records, 2)
+	record := access.records[1].(model.Access)
+	assert.Equal(t, auth.DefaultChain, record.Chain)
+	assert.Equal(t, "alice", record.Principal)
+	assert.Equal(t, "read", record.Operation)
+	assert.Equal(t, "Customer=billing&limit=10", record.Filters)
+	assert.Equal(t, int64(2), record.Records)
+	assert.Equal(t, "123", record.RequestID)
+	assert.NotNil(t, record.Timestamp)
+	// accesses are chained
+	assert.Equal(t, access.records[0].(model.Access).Hash, record.PreviousHash)
+	assert.NotEmpty(t, record.Hash)
+
+	// writes are not recorded
+	router.ServeHTTP(httptest.NewRecorder(), newAuthorizedRequest("alice", http.MethodPost, "http://example.com/audit", newJSONInput().String()))
+	assert.Len(t, access.records, 2)
+}
+
+func TestAccessRecordedOnProof(t *testing.T) {
+	checkpointer, audit := newTestCheckpointer(t, 3)
+	access := &mockRecordStore{}
+	handler := makeProofHandler(checkpointer, newAccessLog(access, auth.DefaultChain))
+
+	req, _ := newTestRequest(http.MethodGet, fmt.Sprintf("http://example.com/audit/%v/proof", audit[1].Hash), nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, access.records, 1)
+	record := access.records[0].(model.Access)
+	assert.Equal(t, "proof", record.Operation)
+	assert.Equal(t, "hash="+audit[1].Hash, record.Filters)
+	assert.Equal(t, int64(1), record.Records)
+	assert.Equal(t, "", record.Principal)
+}
+
+func TestAccessRecordError(t *testing.T) {
+	now := time.Now()
+	audit := []model.Block{{Customer: "billing", Timestamp: &now, Event: "paid", Hash: "a"}}
+	handler := makeHandlerWithOptions(auditHandler, newMockStoreWithAudit(audit)(), defaultBlockType, &handlerOptions{access: newAccessLog(&failingStore{}, auth.DefaultChain)})
+
+	req, _ := newTestRequest(http.MethodGet, "http://example.com/audit", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	// blocks are not returned when access could not be recorded
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "paid")
+}
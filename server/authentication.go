@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/lukaszbudnik/auditor/auth"
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/migrator/common"
+)
+
+const authenticateChallenge = `Bearer realm="auditor", ApiKey realm="auditor"`
+
+// authenticating rejects requests which are not authenticated with 401 and stores authenticated principal in request context,
+// when authenticator is nil all requests are passed
+func authenticating(authenticator auth.Authenticator, next http.Handler) http.Handler {
+	if authenticator == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := authenticator.Authenticate(r)
+		if err != nil {
+			common.LogError(r.Context(), "Authentication failed: %v", err.Error())
+			w.Header().Set("WWW-Authenticate", authenticateChallenge)
+			errorDefaultResponse(w, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), principal)))
+	})
+}
+
+// setPrincipal sets field tagged with principal to ID of authenticated caller, or clears it when request
+// was not authenticated, so that clients cannot record blocks on behalf of others
+func setPrincipal(r *http.Request, block interface{}) {
+	id := ""
+	if principal := auth.FromContext(r.Context()); principal != nil {
+		id = principal.ID
+	}
+	model.SchemaFor(block).SetPrincipal(block, id)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lukaszbudnik/auditor/auth"
+	"github.com/lukaszbudnik/auditor/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPrincipalBlockType(t *testing.T) reflect.Type {
+	blockType, err := schema.Build(&schema.Schema{Fields: []schema.Field{
+		{Name: "Timestamp", Type: "time", Auditor: []string{"sort"}, Validate: "nonzero"},
+		{Name: "Principal", Type: "string", Auditor: []string{"principal"}},
+		{Name: "Event", Type: "string"},
+		{Name: "Hash", Type: "string", Auditor: []string{"hash"}},
+		{Name: "PreviousHash", Type: "string", Auditor: []string{"previoushash"}},
+	}})
+	assert.Nil(t, err)
+	return blockType
+}
+
+func TestAuthentication(t *testing.T) {
+	keys, err := auth.NewAPIKeys(map[string]string{"billing-service": auth.HashAPIKey("secret")})
+	assert.Nil(t, err)
+	ms := &mockRecordStore{}
+	handler := NewHandler(ms, &Config{BlockType: newPrincipalBlockType(t), Authenticator: keys})
+	post := func(key string) *httptest.ResponseRecorder {
+		body := bytes.NewBufferString(`{"Timestamp": "2019-01-01T12:00:00Z", "Principal": "forged", "Event": "login"}`)
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/audit", body)
+		if len(key) > 0 {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, key := range []string{"", "wrong"} {
+		w := post(key)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, authenticateChallenge, w.Header().Get("WWW-Authenticate"))
+		assert.Equal(t, `{"ErrorMessage":"Unauthorized"}`, strings.TrimSpace(w.Body.String()))
+	}
+	assert.Len(t, ms.records, 0)
+
+	// principal is always set by server
+	w := post("secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, ms.records, 1)
+	assert.Equal(t, "billing-service", reflect.ValueOf(ms.records[0]).FieldByName("Principal").String())
+}
+
+func TestPrincipalClearedWithoutAuthentication(t *testing.T) {
+	ms := &mockRecordStore{}
+	router := registerHandlers(ms, &Config{BlockType: newPrincipalBlockType(t)})
+	w := postBlock(router, `{"Timestamp": "2019-01-01T12:00:00Z", "Principal": "forged", "Event": "login"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", reflect.ValueOf(ms.records[0]).FieldByName("Principal").String())
+}
+
+func TestAuthenticationPrincipalInContext(t *testing.T) {
+	var principal *auth.Principal
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = auth.FromContext(r.Context())
+	})
+	w := httptest.NewRecorder()
+	authenticating(auth.Any{}, next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/audit", nil))
+	// no authenticator understands request
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Nil(t, principal)
+
+	keys, _ := auth.NewAPIKeys(map[string]string{"auditor": auth.HashAPIKey("secret")})
+	req := httptest.NewRequest(http.MethodGet, "/audit", nil)
+	req.Header.Set("Authorization", "ApiKey secret")
+	authenticating(auth.Any{keys}, next).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, &auth.Principal{ID: "auditor", Method: "apikey"}, principal)
+}
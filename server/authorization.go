@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/lukaszbudnik/auditor/auth"
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/migrator/common"
+)
+
+// authorizer checks requests to a chain against policy, nil authorizer allows all requests
+type authorizer struct {
+	policy *auth.Policy
+	chain  string
+}
+
+func newAuthorizer(policy *auth.Policy, chain string) *authorizer {
+	if policy == nil {
+		return nil
+	}
+	return &authorizer{policy: policy, chain: chain}
+}
+
+// authorize checks if principal may perform action on partition and category of block and logs decision,
+// when anyCategory is set category of block is ignored
+func (a *authorizer) authorize(r *http.Request, action auth.Action, block interface{}, anyCategory bool) bool {
+	if a == nil {
+		return true
+	}
+	return a.decide(r, a.request(r, action, block, anyCategory))
+}
+
+// decide authorizes request and logs decision
+func (a *authorizer) decide(r *http.Request, request auth.Request) bool {
+	decision := a.policy.Authorize(request)
+	if decision.Allowed {
+		common.LogInfo(r.Context(), "Authorization: %v", decision)
+	} else {
+		common.LogError(r.Context(), "Authorization: %v", decision)
+	}
+	return decision.Allowed
+}
+
+// allows checks if principal may read block without logging decision, it is used for every block of a page
+func (a *authorizer) allows(r *http.Request, block interface{}) bool {
+	if a == nil {
+		return true
+	}
+	return a.policy.Authorize(a.request(r, auth.Read, block, false)).Allowed
+}
+
+// filter removes blocks which principal is not allowed to read, blocks is a pointer to slice
+func (a *authorizer) filter(r *http.Request, blocks interface{}) {
+	if a == nil {
+		return
+	}
+	slice := reflect.ValueOf(blocks).Elem()
+	allowed := reflect.MakeSlice(slice.Type(), 0, slice.Len())
+	for i := 0; i < slice.Len(); i++ {
+		block := slice.Index(i).Addr().Interface()
+		if a.allows(r, block) {
+			allowed = reflect.Append(allowed, slice.Index(i))
+		}
+	}
+	if filtered := slice.Len() - allowed.Len(); filtered > 0 {
+		common.LogInfo(r.Context(), "Authorization: %v of %v blocks filtered out", filtered, slice.Len())
+	}
+	slice.Set(allowed)
+}
+
+func (a *authorizer) request(r *http.Request, action auth.Action, block interface{}, anyCategory bool) auth.Request {
+	request := auth.Request{Principal: auth.FromContext(r.Context()), Action: action, Chain: a.chain, AnyCategory: anyCategory}
+	schema := model.SchemaFor(block)
+	if schema.HasPartition() {
+		request.Partition = fmt.Sprint(schema.Partition(block))
+	}
+	if field, ok := schema.Type.FieldByName("Category"); ok && field.Type.Kind() == reflect.String {
+		request.Category = model.GetFieldStringValue(block, field)
+	}
+	return request
+}
+
+func forbiddenResponse(w http.ResponseWriter) {
+	errorDefaultResponse(w, http.StatusForbidden)
+}
+
+// requireUnrestricted wraps handler of requests which affect all chains, partitions, and categories,
+// they need permission to perform action which is not restricted to any of them
+func (a *authorizer) requireUnrestricted(action auth.Action, next http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		request := auth.Request{Principal: auth.FromContext(r.Context()), Action: action, Unrestricted: true}
+		if !a.decide(r, request) {
+			forbiddenResponse(w)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/auth"
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPolicy() *auth.Policy {
+	return &auth.Policy{Permissions: []auth.Permission{
+		{Principals: []string{"billing-service"}, Actions: []auth.Action{auth.Write}, Partitions: []string{"billing"}},
+		{Principals: []string{"billing-service"}, Actions: []auth.Action{auth.Read}, Partitions: []string{"billing"}, Categories: []string{"payment"}},
+		{Principals: []string{"alice"}, Actions: []auth.Action{auth.Read}},
+	}}
+}
+
+func newAuthorizedRequest(principal, method, url, body string) *http.Request {
+	req, _ := newTestRequest(method, url, bytes.NewBufferString(body))
+	if len(principal) > 0 {
+		req = req.WithContext(auth.NewContext(req.Context(), &auth.Principal{ID: principal}))
+	}
+	return req
+}
+
+func TestAuthorizationPost(t *testing.T) {
+	ms := newMockStore()
+	handler := makeHandlerWithOptions(auditHandler, ms, defaultBlockType, &handlerOptions{authorizer: newAuthorizer(newTestPolicy(), auth.DefaultChain)})
+	timestamp := time.Now().Format(time.RFC3339Nano)
+
+	for _, test := range []struct {
+		principal string
+		customer  string
+		status    int
+	}{
+		{"billing-service", "billing", http.StatusOK},
+		{"billing-service", "shipping", http.StatusForbidden},
+		{"alice", "billing", http.StatusForbidden},
+		{"", "billing", http.StatusForbidden},
+	} {
+		body := `{"Customer": "` + test.customer + `", "Category": "payment", "Event": "paid", "Timestamp": "` + timestamp + `"}`
+		w := httptest.NewRecorder()
+		handler(w, newAuthorizedRequest(test.principal, http.MethodPost, "http://example.com/audit", body))
+		assert.Equal(t, test.status, w.Code, test)
+	}
+	assert.Len(t, ms.(*mockStore).audit, 1)
+}
+
+func TestAuthorizationGet(t *testing.T) {
+	now := time.Now()
+	audit := []model.Block{
+		{Customer: "billing", Timestamp: &now, Category: "payment", Event: "paid", Hash: "a"},
+		{Customer: "billing", Timestamp: &now, Category: "refund", Event: "refunded", Hash: "b"},
+	}
+	handler := makeHandlerWithOptions(auditHandler, newMockStoreWithAudit(audit)(), defaultBlockType, &handlerOptions{authorizer: newAuthorizer(newTestPolicy(), auth.DefaultChain)})
+	get := func(principal, url string) (int, []string) {
+		w := httptest.NewRecorder()
+		handler(w, newAuthorizedRequest(principal, http.MethodGet, url, ""))
+		blocks := []model.Block{}
+		json.Unmarshal(w.Body.Bytes(), &blocks)
+		hashes := []string{}
+		for _, block := range blocks {
+			hashes = append(hashes, block.Hash)
+		}
+		return w.Code, hashes
+	}
+
+	// blocks of categories principal cannot read are filtered out
+	status, hashes := get("billing-service", "http://example.com/audit?Customer=billing")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"a"}, hashes)
+
+	status, _ = get("billing-service", "http://example.com/audit?Customer=shipping")
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, hashes = get("alice", "http://example.com/audit?Customer=billing")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"a", "b"}, hashes)
+
+	status, _ = get("", "http://example.com/audit")
+	assert.Equal(t, http.StatusForbidden, status)
+}
+
+func TestAuthorizationAdmin(t *testing.T) {
+	now := time.Now()
+	ms := newMockStoreWithAudit([]model.Block{{Customer: "billing", Timestamp: &now, Event: "signed up", Hash: "abc"}})()
+	router := registerHandlers(ms, &Config{Policy: newTestPolicy()})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newAuthorizedRequest("alice", http.MethodPost, "http://example.com/audit/abc/redact?Customer=billing", `{"Fields": ["Event"], "Reason": "GDPR request"}`))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"Forbidden"}`, strings.TrimSpace(w.Body.String()))
+	assert.Len(t, ms.(*mockStore).redacted, 0)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newAuthorizedRequest("alice", http.MethodDelete, "http://example.com/subjects/alice", ""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAuthorizationAdminScopedToStoredBlock(t *testing.T) {
+	now := time.Now()
+	ms := newMockStoreWithAudit([]model.Block{
+		{Customer: "shipping", Timestamp: &now, Event: "signed up", Hash: "abc"},
+		{Customer: "billing", Timestamp: &now, Event: "signed up", Hash: "def"},
+	})()
+	policy := &auth.Policy{Permissions: []auth.Permission{
+		{Principals: []string{"billing-admin"}, Actions: []auth.Action{auth.Admin}, Partitions: []string{"billing"}},
+		{Principals: []string{"security-admin"}, Actions: []auth.Action{auth.Admin}},
+	}}
+	router := registerHandlers(ms, &Config{Policy: policy})
+	redact := func(principal, hash string) int {
+		w := httptest.NewRecorder()
+		url := "http://example.com/audit/" + hash + "/redact?Customer=billing"
+		router.ServeHTTP(w, newAuthorizedRequest(principal, http.MethodPost, url, `{"Fields": ["Event"], "Reason": "GDPR request"}`))
+		return w.Code
+	}
+
+	// partition sent in query does not matter, block abc is stored in shipping partition
+	assert.Equal(t, http.StatusForbidden, redact("billing-admin", "abc"))
+	assert.Len(t, ms.(*mockStore).redacted, 0)
+	assert.Equal(t, http.StatusOK, redact("billing-admin", "def"))
+	assert.Equal(t, http.StatusOK, redact("security-admin", "abc"))
+	// redaction is recorded in partition of redacted block
+	assert.Equal(t, "shipping", ms.(*mockStore).audit[3].Customer)
+
+	// erasure shreds subject keys used by all partitions
+	erase := func(principal string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newAuthorizedRequest(principal, http.MethodDelete, "http://example.com/subjects/alice?Customer=billing", ""))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusForbidden, erase("billing-admin"))
+	// subject keys are not configured in this test
+	assert.Equal(t, http.StatusNotFound, erase("security-admin"))
+}
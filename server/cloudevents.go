@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/model"
+)
+
+const (
+	cloudEventsContentType      = "application/cloudevents+json"
+	cloudEventsBatchContentType = "application/cloudevents-batch+json"
+)
+
+var errCloudEventsNotSupported = errors.New("block type does not support CloudEvents")
+
+// isCloudEvent returns true if request carries CloudEvent in structured or binary mode
+func isCloudEvent(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == cloudEventsContentType || len(r.Header.Get("ce-specversion")) > 0
+}
+
+// acceptsCloudEvents returns true if client asked for blocks rendered as CloudEvents batch
+func acceptsCloudEvents(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), cloudEventsBatchContentType)
+}
+
+// decodeCloudEvent sets block from CloudEvent sent in structured mode (whole event in body)
+// or binary mode (attributes in ce- headers, data in body)
+func decodeCloudEvent(r *http.Request, body []byte, block interface{}) error {
+	eventBlock, ok := block.(model.CloudEventBlock)
+	if !ok {
+		return errCloudEventsNotSupported
+	}
+	event := &model.CloudEvent{}
+	if len(r.Header.Get("ce-specversion")) > 0 {
+		event.SpecVersion = r.Header.Get("ce-specversion")
+		event.ID = r.Header.Get("ce-id")
+		event.Source = r.Header.Get("ce-source")
+		event.Type = r.Header.Get("ce-type")
+		event.Subject = r.Header.Get("ce-subject")
+		event.CorrelationID = r.Header.Get("ce-correlationid")
+		event.DataContentType, _, _ = mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if s := r.Header.Get("ce-time"); len(s) > 0 {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return err
+			}
+			event.Time = &t
+		}
+		event.Data = body
+	} else if err := json.Unmarshal(body, event); err != nil {
+		return err
+	}
+	return eventBlock.FromCloudEvent(event)
+}
+
+// renderCloudEvents renders blocks (pointer to slice of blocks) as CloudEvents
+func renderCloudEvents(blocks interface{}) ([]*model.CloudEvent, error) {
+	slicev := reflect.ValueOf(blocks).Elem()
+	events := make([]*model.CloudEvent, 0, slicev.Len())
+	for i := 0; i < slicev.Len(); i++ {
+		eventBlock, ok := slicev.Index(i).Addr().Interface().(model.CloudEventBlock)
+		if !ok {
+			return nil, errCloudEventsNotSupported
+		}
+		event, err := eventBlock.CloudEvent()
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
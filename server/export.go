@@ -0,0 +1,179 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/auth"
+	"github.com/lukaszbudnik/auditor/export"
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store"
+	"github.com/lukaszbudnik/migrator/common"
+)
+
+const exportPageSize int64 = 100
+
+// exportHandler streams blocks newest first, from (inclusive) and to (exclusive) query parameters limit the range of sort values,
+// when export fails after streaming started trailer is not written, trailer is signed when export key is set
+func exportHandler(w http.ResponseWriter, r *http.Request, auditStore store.Store, blockType reflect.Type, options *handlerOptions) {
+	if r.Method != http.MethodGet {
+		common.LogError(r.Context(), "Wrong method: %v", r.Method)
+		errorDefaultResponse(w, http.StatusMethodNotAllowed)
+		return
+	}
+	common.LogInfo(r.Context(), "Start")
+	format, err := export.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	schema := model.SchemaOf(blockType)
+	last := newBlock(blockType)
+	setPartition(r, last)
+	to, err := sortParam(r, schema, "to")
+	if err != nil {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if to != nil {
+		schema.SetSort(last, to)
+	}
+	var from interface{}
+	if value, err := sortParam(r, schema, "from"); err != nil {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusBadRequest, err.Error())
+		return
+	} else if value != nil {
+		from = newBlock(blockType)
+		schema.SetSort(from, value)
+	}
+	if !options.authorizer.authorize(r, auth.Read, last, true) {
+		forbiddenResponse(w)
+		return
+	}
+
+	// first page is read before streaming starts so that errors can still be reported with status code
+	page := newBlocks(blockType)
+	if err := auditStore.Read(page, exportPageSize, last); err != nil {
+		errorInternalServerErrorResponse(w, err)
+		return
+	}
+
+	// export is recorded before the first byte is written, number of exported blocks is not known yet
+	if err := options.access.record(r, "export", r.URL.Query(), -1); err != nil {
+		errorInternalServerErrorResponse(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	var out io.Writer = w
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Vary", "Accept-Encoding")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+	writer := export.NewWriter(out, format, blockType)
+	trailer := &export.Trailer{}
+	// previousHash is previous hash of the last written block, blocks which are not exported are written as skipped
+	var previousHash string
+	for {
+		blocks := reflect.ValueOf(page).Elem()
+		more := int64(blocks.Len()) == exportPageSize
+		for i := 0; i < blocks.Len(); i++ {
+			block := blocks.Index(i).Addr().Interface()
+			last = block
+			if from != nil && schema.SortBefore(block, from) {
+				more = false
+				break
+			}
+			// blocks of other partitions are linked into the same chain (DynamoDB)
+			if len(previousHash) > 0 && previousHash != schema.Hash(block) {
+				if err := skipGap(auditStore, blockType, writer, trailer, previousHash, schema.Hash(block)); err != nil {
+					common.LogError(r.Context(), "Could not write export: %v", err.Error())
+					return
+				}
+			}
+			var err error
+			if options.authorizer.allows(r, block) {
+				err = writer.Write(block)
+				trailer.Count++
+			} else {
+				err = writer.Skip(&export.Skipped{Hash: schema.Hash(block), PreviousHash: schema.PreviousHash(block)})
+				trailer.Skipped++
+			}
+			if err != nil {
+				common.LogError(r.Context(), "Could not write export: %v", err.Error())
+				return
+			}
+			if len(trailer.Head) == 0 {
+				trailer.Head = schema.Hash(block)
+			}
+			previousHash = schema.PreviousHash(block)
+		}
+		if !more {
+			break
+		}
+		page = newBlocks(blockType)
+		if err := auditStore.Read(page, exportPageSize, last); err != nil {
+			common.LogError(r.Context(), "Could not read export page: %v", err.Error())
+			return
+		}
+	}
+
+	trailer.Timestamp = time.Now().UTC()
+	if options.exportKey != nil {
+		export.Sign(options.exportKey, trailer)
+	}
+	if err := writer.Close(trailer); err != nil {
+		common.LogError(r.Context(), "Could not write export trailer: %v", err.Error())
+		return
+	}
+	common.LogInfo(r.Context(), "Exported %v blocks, skipped %v blocks", trailer.Count, trailer.Skipped)
+}
+
+// skipGap follows the chain by hash from previousHash to block with hash and writes blocks in between as skipped
+func skipGap(auditStore store.Store, blockType reflect.Type, writer export.Writer, trailer *export.Trailer, previousHash, hash string) error {
+	finder, ok := auditStore.(store.Finder)
+	if !ok {
+		return fmt.Errorf("block %v is not linked to previous hash %v", hash, previousHash)
+	}
+	schema := model.SchemaOf(blockType)
+	for previousHash != hash {
+		if len(previousHash) == 0 {
+			return fmt.Errorf("block %v not found in the chain", hash)
+		}
+		block := newBlock(blockType)
+		schema.SetHash(block, previousHash)
+		// encrypted store implements Finder even when underlying store does not
+		if err := finder.FindByHash(block); err == store.ErrFindNotSupported {
+			return fmt.Errorf("block %v is not linked to previous hash %v", hash, previousHash)
+		} else if err != nil {
+			return err
+		}
+		if err := writer.Skip(&export.Skipped{Hash: previousHash, PreviousHash: schema.PreviousHash(block)}); err != nil {
+			return err
+		}
+		trailer.Skipped++
+		previousHash = schema.PreviousHash(block)
+	}
+	return nil
+}
+
+// sortParam parses query parameter as a value of sort field, returns nil when parameter is not set
+func sortParam(r *http.Request, schema *model.BlockSchema, param string) (interface{}, error) {
+	value := r.URL.Query().Get(param)
+	if len(value) == 0 {
+		return nil, nil
+	}
+	sort, err := schema.ParseSort(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v: %v", param, err.Error())
+	}
+	return sort, nil
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/auth"
+	"github.com/lukaszbudnik/auditor/export"
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/stretchr/testify/assert"
+)
+
+var exportStart = time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newExportStore(n int) *mockPagingStore {
+	ms := &mockPagingStore{}
+	for i := 0; i < n; i++ {
+		timestamp := exportStart.Add(time.Duration(i) * time.Minute)
+		ms.Save(&model.Block{Customer: "billing", Timestamp: &timestamp, Event: "paid"})
+	}
+	return ms
+}
+
+func getExport(router http.Handler, url string, header ...string) *httptest.ResponseRecorder {
+	req := newAuthorizedRequest("alice", http.MethodGet, url, "")
+	for i := 0; i < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestExport(t *testing.T) {
+	ms := newExportStore(250)
+	access := &mockRecordStore{}
+	router := registerHandlers(ms, &Config{Access: access})
+
+	w := getExport(router, "http://example.com/audit/export?Customer=billing")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	// chain is read in pages
+	assert.Equal(t, 3, ms.reads)
+
+	trailer, err := export.Verify(w.Body, defaultBlockType, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(250), trailer.Count)
+	assert.Equal(t, ms.records[249].(model.Block).Hash, trailer.Head)
+	assert.Empty(t, trailer.Signature)
+
+	assert.Len(t, access.records, 1)
+	record := access.records[0].(model.Access)
+	assert.Equal(t, "export", record.Operation)
+	assert.Equal(t, "Customer=billing", record.Filters)
+	// export is recorded before streaming starts
+	assert.Equal(t, int64(-1), record.Records)
+}
+
+func TestExportRange(t *testing.T) {
+	router := registerHandlers(newExportStore(250), &Config{})
+	from, to := exportStart.Add(50*time.Minute).Format(time.RFC3339Nano), exportStart.Add(200*time.Minute).Format(time.RFC3339Nano)
+
+	w := getExport(router, "http://example.com/audit/export?from="+from+"&to="+to)
+	assert.Equal(t, http.StatusOK, w.Code)
+	trailer, err := export.Verify(w.Body, defaultBlockType, nil)
+	assert.Nil(t, err)
+	// from is inclusive, to is exclusive
+	assert.Equal(t, int64(150), trailer.Count)
+}
+
+func TestExportSignedGzip(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	router := registerHandlers(newExportStore(10), &Config{ExportKey: private})
+
+	w := getExport(router, "http://example.com/audit/export?format=jsonl", "Accept-Encoding", "gzip")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/jsonl", w.Header().Get("Content-Type"))
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+	reader, err := gzip.NewReader(w.Body)
+	assert.Nil(t, err)
+	trailer, err := export.Verify(reader, defaultBlockType, public)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), trailer.Count)
+}
+
+func TestExportCSV(t *testing.T) {
+	router := registerHandlers(newExportStore(2), &Config{})
+	w := getExport(router, "http://example.com/audit/export?format=csv")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 4)
+	assert.Equal(t, "Customer,Timestamp,Category,Subcategory,Event,Hash,PreviousHash", lines[0])
+	assert.True(t, strings.HasPrefix(lines[3], `# {"Head":`))
+}
+
+func TestExportChain(t *testing.T) {
+	chain := &Chain{Store: newExportStore(5), BlockType: defaultBlockType}
+	router := registerHandlers(newExportStore(1), &Config{Chains: map[string]*Chain{"payments": chain}})
+	w := getExport(router, "http://example.com/audit/payments/export")
+	assert.Equal(t, http.StatusOK, w.Code)
+	trailer, err := export.Verify(w.Body, defaultBlockType, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), trailer.Count)
+}
+
+func TestExportSkipped(t *testing.T) {
+	ms := &mockPagingStore{partitioned: true}
+	for i := 0; i < 6; i++ {
+		timestamp := exportStart.Add(time.Duration(i) * time.Minute)
+		// every third block belongs to other partition, every other block of billing partition is a login
+		block := &model.Block{Customer: "billing", Timestamp: &timestamp, Category: "payment", Event: "paid"}
+		if i%3 == 2 {
+			block.Customer = "shipping"
+		} else if i%2 == 0 {
+			block.Category = "login"
+		}
+		ms.Save(block)
+	}
+	policy := &auth.Policy{Permissions: []auth.Permission{{Principals: []string{"alice"}, Actions: []auth.Action{auth.Read}, Partitions: []string{"billing"}, Categories: []string{"payment"}}}}
+	router := registerHandlers(ms, &Config{Policy: policy})
+
+	w := getExport(router, "http://example.com/audit/export?Customer=billing")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "shipping")
+	assert.NotContains(t, w.Body.String(), "login")
+	trailer, err := export.Verify(strings.NewReader(w.Body.String()), defaultBlockType, nil)
+	assert.Nil(t, err)
+	// blocks 3 and 1 are exported, 4 and 0 are filtered out, 2 belongs to other partition
+	assert.Equal(t, int64(2), trailer.Count)
+	assert.Equal(t, int64(3), trailer.Skipped)
+	assert.Equal(t, ms.records[4].(model.Block).Hash, trailer.Head)
+	assert.Contains(t, w.Body.String(), `{"Skipped":{"Hash":"`+ms.records[2].(model.Block).Hash+`"`)
+}
+
+func TestExportErrors(t *testing.T) {
+	policy := &auth.Policy{Permissions: []auth.Permission{{Principals: []string{"alice"}, Actions: []auth.Action{auth.Read}, Partitions: []string{"billing"}}}}
+	router := registerHandlers(newExportStore(1), &Config{Policy: policy})
+
+	for url, status := range map[string]int{
+		"http://example.com/audit/export?Customer=billing&format=xml":                 http.StatusBadRequest,
+		"http://example.com/audit/export?Customer=billing&from=yesterday":             http.StatusBadRequest,
+		"http://example.com/audit/export?Customer=shipping":                           http.StatusForbidden,
+		"http://example.com/audit/export?Customer=billing&to=2019-01-01T13:00:00.00Z": http.StatusOK,
+	} {
+		assert.Equal(t, status, getExport(router, url).Code, url)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newAuthorizedRequest("alice", http.MethodPost, "http://example.com/audit/export", ""))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	// read errors are reported before streaming starts
+	w = getExport(registerHandlers(newMockStoreWithError(1)(), &Config{}), "http://example.com/audit/export")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// nothing is exported when export cannot be recorded
+	w = getExport(registerHandlers(newExportStore(1), &Config{Access: &failingStore{}}), "http://example.com/audit/export")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "paid")
+}
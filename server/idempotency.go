@@ -0,0 +1,125 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store"
+	"github.com/lukaszbudnik/migrator/common"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// minFingerprintSecretSize is the minimum size of secret used to compute fingerprints
+const minFingerprintSecretSize = 32
+
+var errFingerprintSecretNotSet = errors.New("idempotency keys require AUDITOR_IDEMPOTENCY_SECRET")
+
+// FingerprintSecretFromEnv loads secret used to compute fingerprints of blocks from file set in AUDITOR_IDEMPOTENCY_SECRET,
+// returns nil when it is not set
+func FingerprintSecretFromEnv() ([]byte, error) {
+	path := os.Getenv("AUDITOR_IDEMPOTENCY_SECRET")
+	if len(path) == 0 {
+		return nil, nil
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret := []byte(strings.TrimSpace(string(bytes)))
+	if len(secret) < minFingerprintSecretSize {
+		return nil, fmt.Errorf("secret must have at least %v bytes", minFingerprintSecretSize)
+	}
+	return secret, nil
+}
+
+// idempotent sets idempotency key sent in header and fingerprint of posted block,
+// it returns true when response was already written: key is invalid or block with the same key was already saved
+func idempotent(w http.ResponseWriter, r *http.Request, auditStore store.Store, block interface{}, secret []byte) bool {
+	schema := model.SchemaFor(block)
+	if key := r.Header.Get(idempotencyKeyHeader); len(key) > 0 {
+		if !schema.HasIdempotencyKey() {
+			errorResponseWithStatusAndErrorMessage(w, http.StatusBadRequest, "block type does not support idempotency keys")
+			return true
+		}
+		schema.SetIdempotencyKey(block, key)
+	}
+	if len(schema.IdempotencyKey(block)) == 0 {
+		if schema.HasIdempotencyKey() {
+			// fingerprint is set only by server
+			schema.SetFingerprint(block, "")
+		}
+		return false
+	}
+	if _, ok := auditStore.(store.Deduplicator); !ok {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusNotImplemented, store.ErrIdempotencyNotSupported.Error())
+		return true
+	}
+	if secret == nil {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusNotImplemented, errFingerprintSecretNotSet.Error())
+		return true
+	}
+	fingerprint, err := computeFingerprint(secret, block)
+	if err != nil {
+		errorInternalServerErrorResponse(w, err)
+		return true
+	}
+	schema.SetFingerprint(block, fingerprint)
+	return replay(w, r, auditStore, block)
+}
+
+// computeFingerprint returns HMAC-SHA-256 of block as sent by client, fields set by server later on are not included,
+// fingerprint is stored in plain text so it is keyed, otherwise encrypted, redacted, or erased values could be brute forced from it
+func computeFingerprint(secret []byte, block interface{}) (string, error) {
+	schema := model.SchemaFor(block)
+	schema.SetFingerprint(block, "")
+	schema.SetSkewed(block, false)
+	data, err := json.Marshal(block)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// replay responds with block saved with the same idempotency key as block, or with 409 when blocks have
+// different fingerprints, it returns false when there is no such block
+func replay(w http.ResponseWriter, r *http.Request, auditStore store.Store, block interface{}) bool {
+	schema := model.SchemaFor(block)
+	existing := newBlock(schema.Type)
+	if schema.HasPartition() {
+		schema.SetPartition(existing, schema.Partition(block))
+	}
+	schema.SetIdempotencyKey(existing, schema.IdempotencyKey(block))
+
+	err := auditStore.(store.Deduplicator).FindByIdempotencyKey(existing)
+	if err == store.ErrBlockNotFound {
+		return false
+	}
+	if err == store.ErrIdempotencyNotSupported {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusNotImplemented, err.Error())
+		return true
+	}
+	if err != nil {
+		errorInternalServerErrorResponse(w, err)
+		return true
+	}
+	if schema.Fingerprint(existing) != schema.Fingerprint(block) {
+		common.LogError(r.Context(), "Idempotency key %v reused with different block", schema.IdempotencyKey(block))
+		errorResponseWithStatusAndErrorMessage(w, http.StatusConflict, "idempotency key was already used with different block")
+		return true
+	}
+	common.LogInfo(r.Context(), "Idempotency key %v already saved", schema.IdempotencyKey(block))
+	okResponseWithBlock(w, existing)
+	return true
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lukaszbudnik/auditor/schema"
+	"github.com/lukaszbudnik/auditor/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func newIdempotentBlockType(t *testing.T) reflect.Type {
+	blockType, err := schema.Build(&schema.Schema{Fields: []schema.Field{
+		{Name: "Timestamp", Type: "time", Auditor: []string{"sort"}, Validate: "nonzero"},
+		{Name: "Event", Type: "string"},
+		{Name: "IdempotencyKey", Type: "string", Auditor: []string{"idempotencykey"}},
+		{Name: "Fingerprint", Type: "string", Auditor: []string{"fingerprint"}},
+		{Name: "Hash", Type: "string", Auditor: []string{"hash"}},
+		{Name: "PreviousHash", Type: "string", Auditor: []string{"previoushash"}},
+	}})
+	assert.Nil(t, err)
+	return blockType
+}
+
+var testFingerprintSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func postIdempotent(router http.Handler, key, body string) *httptest.ResponseRecorder {
+	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit", bytes.NewBufferString(body))
+	if len(key) > 0 {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuditIdempotencyKey(t *testing.T) {
+	ms := &mockDedupStore{}
+	router := registerHandlers(ms, &Config{BlockType: newIdempotentBlockType(t), FingerprintSecret: testFingerprintSecret})
+
+	first := postIdempotent(router, "key-1", `{"Timestamp": "2019-01-01T12:00:00Z", "Event": "login"}`)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Len(t, ms.records, 1)
+	assert.Equal(t, "key-1", reflect.ValueOf(ms.records[0]).FieldByName("IdempotencyKey").String())
+	assert.Len(t, reflect.ValueOf(ms.records[0]).FieldByName("Fingerprint").String(), 64)
+
+	// retry returns original hashes
+	retry := postIdempotent(router, "key-1", `{"Timestamp": "2019-01-01T12:00:00Z", "Event": "login"}`)
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Len(t, ms.records, 1)
+
+	// key sent in tagged field, fingerprint sent by client is ignored
+	retry = postIdempotent(router, "", `{"Timestamp": "2019-01-01T12:00:00Z", "Event": "login", "IdempotencyKey": "key-1", "Fingerprint": "forged"}`)
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Len(t, ms.records, 1)
+
+	conflict := postIdempotent(router, "key-1", `{"Timestamp": "2019-01-01T12:00:00Z", "Event": "logout"}`)
+	assert.Equal(t, http.StatusConflict, conflict.Code)
+	assert.Len(t, ms.records, 1)
+
+	// blocks without key are not deduplicated
+	for i := 0; i < 2; i++ {
+		w := postIdempotent(router, "", `{"Timestamp": "2019-01-01T12:00:00Z", "Event": "login", "Fingerprint": "forged"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Len(t, ms.records, 3)
+	assert.Equal(t, "", reflect.ValueOf(ms.records[2]).FieldByName("Fingerprint").String())
+}
+
+func TestAuditIdempotencyKeyConcurrentSave(t *testing.T) {
+	ms := &mockDedupStore{}
+	router := registerHandlers(ms, &Config{BlockType: newIdempotentBlockType(t), FingerprintSecret: testFingerprintSecret})
+	first := postIdempotent(router, "key-1", `{"Timestamp": "2019-01-01T12:00:00Z", "Event": "login"}`)
+	assert.Equal(t, http.StatusOK, first.Code)
+
+	// lookup misses block saved concurrently, Save fails with duplicate key and block is looked up again
+	ms.race = true
+	retry := postIdempotent(router, "key-1", `{"Timestamp": "2019-01-01T12:00:00Z", "Event": "login"}`)
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Len(t, ms.records, 1)
+}
+
+func TestAuditIdempotencyKeyNotSupported(t *testing.T) {
+	// block type without idempotencykey field
+	router := registerHandlers(&mockRecordStore{}, &Config{BlockType: newPrincipalBlockType(t)})
+	w := postIdempotent(router, "key-1", `{"Timestamp": "2019-01-01T12:00:00Z", "Event": "login"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// store without unique idempotency keys
+	ms := &mockRecordStore{}
+	router = registerHandlers(ms, &Config{BlockType: newIdempotentBlockType(t), FingerprintSecret: testFingerprintSecret})
+	w = postIdempotent(router, "key-1", `{"Timestamp": "2019-01-01T12:00:00Z", "Event": "login"}`)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"`+store.ErrIdempotencyNotSupported.Error()+`"}`, strings.TrimSpace(w.Body.String()))
+	assert.Len(t, ms.records, 0)
+
+	// fingerprint secret not set
+	dedup := &mockDedupStore{}
+	router = registerHandlers(dedup, &Config{BlockType: newIdempotentBlockType(t)})
+	w = postIdempotent(router, "key-1", `{"Timestamp": "2019-01-01T12:00:00Z", "Event": "login"}`)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"`+errFingerprintSecretNotSet.Error()+`"}`, strings.TrimSpace(w.Body.String()))
+	assert.Len(t, dedup.records, 0)
+}
+
+func TestComputeFingerprintIsKeyed(t *testing.T) {
+	block := reflect.New(newIdempotentBlockType(t)).Interface()
+	assert.Nil(t, json.Unmarshal([]byte(`{"Timestamp": "2019-01-01T12:00:00Z", "Event": "user@example.com"}`), block))
+	data, _ := json.Marshal(block)
+	plain := sha256.Sum256(data)
+
+	fingerprint, err := computeFingerprint(testFingerprintSecret, block)
+	assert.Nil(t, err)
+	assert.NotEqual(t, hex.EncodeToString(plain[:]), fingerprint)
+	other, err := computeFingerprint([]byte("another secret of at least 32 bytes"), block)
+	assert.Nil(t, err)
+	assert.NotEqual(t, fingerprint, other)
+	again, _ := computeFingerprint(testFingerprintSecret, block)
+	assert.Equal(t, fingerprint, again)
+}
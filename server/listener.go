@@ -0,0 +1,178 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const unixPrefix = "unix:"
+
+// Listener configures address server listens on and TLS, TLS is enabled when CertFile and KeyFile are set,
+// mutual TLS when ClientCAFile is set too: clients must present certificates signed by one of its CAs,
+// certificate, key, and client CA files are reloaded when they change
+type Listener struct {
+	// Address is host:port or unix:/path/to/socket, defaults to :8080
+	Address       string
+	CertFile      string
+	KeyFile       string
+	ClientCAFile  string
+	MinTLSVersion uint16
+}
+
+var tlsVersions = map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+// ListenerFromEnv creates Listener from AUDITOR_LISTEN, AUDITOR_TLS_CERT, AUDITOR_TLS_KEY, AUDITOR_TLS_CLIENT_CA,
+// and AUDITOR_TLS_MIN_VERSION (1.2 or 1.3, defaults to 1.2)
+func ListenerFromEnv() (*Listener, error) {
+	listener := &Listener{
+		Address:      os.Getenv("AUDITOR_LISTEN"),
+		CertFile:     os.Getenv("AUDITOR_TLS_CERT"),
+		KeyFile:      os.Getenv("AUDITOR_TLS_KEY"),
+		ClientCAFile: os.Getenv("AUDITOR_TLS_CLIENT_CA"),
+	}
+	if s := os.Getenv("AUDITOR_TLS_MIN_VERSION"); len(s) > 0 {
+		var ok bool
+		if listener.MinTLSVersion, ok = tlsVersions[s]; !ok {
+			return nil, fmt.Errorf("invalid AUDITOR_TLS_MIN_VERSION: %v", s)
+		}
+	}
+	if (len(listener.CertFile) == 0) != (len(listener.KeyFile) == 0) {
+		return nil, errors.New("AUDITOR_TLS_CERT and AUDITOR_TLS_KEY must be set together")
+	}
+	if len(listener.ClientCAFile) > 0 && !listener.tls() {
+		return nil, errors.New("AUDITOR_TLS_CLIENT_CA requires AUDITOR_TLS_CERT and AUDITOR_TLS_KEY")
+	}
+	return listener, nil
+}
+
+func (l *Listener) tls() bool {
+	return len(l.CertFile) > 0 && len(l.KeyFile) > 0
+}
+
+// listen opens listener, for TLS it is wrapped in TLS listener
+func (l *Listener) listen() (net.Listener, error) {
+	network, address := "tcp", ":"+defaultPort
+	if len(l.Address) > 0 {
+		address = l.Address
+	}
+	if strings.HasPrefix(address, unixPrefix) {
+		network, address = "unix", strings.TrimPrefix(address, unixPrefix)
+		// socket left by previous process which did not exit cleanly
+		if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if !l.tls() {
+		return ln, nil
+	}
+	config, err := l.tlsConfig()
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return tls.NewListener(ln, config), nil
+}
+
+// tlsConfig returns TLS config which reads certificates through reloader
+func (l *Listener) tlsConfig() (*tls.Config, error) {
+	reloader := &certReloader{listener: l}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion: l.minTLSVersion(),
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return reloader.config(), nil
+		},
+	}, nil
+}
+
+func (l *Listener) minTLSVersion() uint16 {
+	if l.MinTLSVersion > 0 {
+		return l.MinTLSVersion
+	}
+	return tls.VersionTLS12
+}
+
+// certReloader keeps TLS config built from the latest version of certificate, key, and client CA files
+type certReloader struct {
+	listener *Listener
+	lock     sync.Mutex
+	modified time.Time
+	current  *tls.Config
+}
+
+// config returns TLS config, files are checked on every handshake and reloaded when any of them changed,
+// if reload fails previous config is used
+func (r *certReloader) config() *tls.Config {
+	if err := r.reload(); err != nil {
+		log.Printf("ERROR Could not reload TLS certificates, using previous ones: %v", err.Error())
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.current
+}
+
+func (r *certReloader) reload() error {
+	modified, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.current != nil && modified.Equal(r.modified) {
+		return nil
+	}
+	certificate, err := tls.LoadX509KeyPair(r.listener.CertFile, r.listener.KeyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{MinVersion: r.listener.minTLSVersion(), Certificates: []tls.Certificate{certificate}}
+	if len(r.listener.ClientCAFile) > 0 {
+		pem, err := ioutil.ReadFile(r.listener.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %v", r.listener.ClientCAFile)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if r.current != nil {
+		log.Printf("INFO auditor reloaded TLS certificates")
+	}
+	r.current, r.modified = config, modified
+	return nil
+}
+
+// lastModified returns the latest modification time of certificate, key, and client CA files
+func (r *certReloader) lastModified() (time.Time, error) {
+	latest := time.Time{}
+	for _, file := range []string{r.listener.CertFile, r.listener.KeyFile, r.listener.ClientCAFile} {
+		if len(file) == 0 {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPEM     []byte
+	keyPEM      []byte
+}
+
+// newTestCertificate creates certificate signed by parent, self-signed CA when parent is nil
+func newTestCertificate(t *testing.T, name string, parent *testCertificate, usage x509.ExtKeyUsage) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.ExtKeyUsage = nil
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.certificate, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	certificate, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCertificate) clientCertificate() tls.Certificate {
+	certificate, _ := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	return certificate
+}
+
+func serveOK(t *testing.T, listener *Listener) net.Listener {
+	ln, err := listener.listen()
+	assert.Nil(t, err)
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	return ln
+}
+
+func TestListenerFromEnv(t *testing.T) {
+	variables := []string{"AUDITOR_LISTEN", "AUDITOR_TLS_CERT", "AUDITOR_TLS_KEY", "AUDITOR_TLS_CLIENT_CA", "AUDITOR_TLS_MIN_VERSION"}
+	set := func(values ...string) {
+		for i, name := range variables {
+			os.Setenv(name, values[i])
+		}
+	}
+	defer set("", "", "", "", "")
+
+	set("unix:/var/run/auditor.sock", "cert.pem", "key.pem", "ca.pem", "1.3")
+	listener, err := ListenerFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, &Listener{Address: "unix:/var/run/auditor.sock", CertFile: "cert.pem", KeyFile: "key.pem", ClientCAFile: "ca.pem", MinTLSVersion: tls.VersionTLS13}, listener)
+
+	for expected, values := range map[string][]string{
+		"invalid AUDITOR_TLS_MIN_VERSION: 1.0":                                {"", "cert.pem", "key.pem", "", "1.0"},
+		"AUDITOR_TLS_CERT and AUDITOR_TLS_KEY must be set together":           {"", "cert.pem", "", "", ""},
+		"AUDITOR_TLS_CLIENT_CA requires AUDITOR_TLS_CERT and AUDITOR_TLS_KEY": {"", "", "", "ca.pem", ""},
+	} {
+		set(values...)
+		_, err := ListenerFromEnv()
+		assert.Equal(t, expected, err.Error())
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditor")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "auditor.sock")
+
+	ln := serveOK(t, &Listener{Address: "unix:" + socket})
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+	}}}
+	response, err := client.Get("http://auditor/audit")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response.Body.Close()
+	ln.Close()
+}
+
+func TestListenMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditor")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	ca := newTestCertificate(t, "ca", nil, x509.ExtKeyUsageAny)
+	server := newTestCertificate(t, "server", ca, x509.ExtKeyUsageServerAuth)
+	client := newTestCertificate(t, "client", ca, x509.ExtKeyUsageClientAuth)
+	listener := &Listener{
+		Address:      "127.0.0.1:0",
+		CertFile:     filepath.Join(dir, "cert.pem"),
+		KeyFile:      filepath.Join(dir, "key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+	}
+	ioutil.WriteFile(listener.CertFile, server.certPEM, 0600)
+	ioutil.WriteFile(listener.KeyFile, server.keyPEM, 0600)
+	ioutil.WriteFile(listener.ClientCAFile, ca.certPEM, 0600)
+
+	ln := serveOK(t, listener)
+	defer ln.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.certificate)
+	get := func(certificates ...tls.Certificate) (*http.Response, error) {
+		config := &tls.Config{RootCAs: roots, Certificates: certificates}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+		return client.Get("https://" + ln.Addr().String() + "/audit")
+	}
+
+	response, err := get(client.clientCertificate())
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "server", response.TLS.PeerCertificates[0].Subject.CommonName)
+
+	// client certificate is required
+	_, err = get()
+	assert.NotNil(t, err)
+
+	// certificate is reloaded without restart
+	renewed := newTestCertificate(t, "renewed", ca, x509.ExtKeyUsageServerAuth)
+	ioutil.WriteFile(listener.CertFile, renewed.certPEM, 0600)
+	ioutil.WriteFile(listener.KeyFile, renewed.keyPEM, 0600)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(listener.KeyFile, later, later)
+	response, err = get(client.clientCertificate())
+	assert.Nil(t, err)
+	assert.Equal(t, "renewed", response.TLS.PeerCertificates[0].Subject.CommonName)
+
+	// broken files are not loaded, previous certificate is used
+	ioutil.WriteFile(listener.KeyFile, []byte("broken"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(listener.KeyFile, later, later)
+	response, err = get(client.clientCertificate())
+	assert.Nil(t, err)
+	assert.Equal(t, "renewed", response.TLS.PeerCertificates[0].Subject.CommonName)
+}
+
+func TestListenTLSErrors(t *testing.T) {
+	_, err := (&Listener{Address: "127.0.0.1:0", CertFile: "/non/existing/cert.pem", KeyFile: "/non/existing/key.pem"}).listen()
+	assert.NotNil(t, err)
+	_, err = (&Listener{Address: "256.0.0.1:0"}).listen()
+	assert.NotNil(t, err)
+}
+
+// slowStore blocks Save until released
+type slowStore struct {
+	mockStore
+	saving  chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStore) Save(block interface{}) error {
+	close(s.saving)
+	<-s.release
+	return s.mockStore.Save(block)
+}
+
+func TestServerShutdownDrainsRequests(t *testing.T) {
+	store := &slowStore{mockStore: mockStore{errorThreshold: -1}, saving: make(chan struct{}), release: make(chan struct{})}
+	srv, err := Start(store, &Config{Listener: &Listener{Address: "127.0.0.1:0"}})
+	assert.Nil(t, err)
+	url := "http://" + srv.Addr().String() + "/audit"
+
+	responses := make(chan int)
+	go func() {
+		response, err := http.Post(url, "application/json", newJSONInput())
+		if err != nil {
+			responses <- 0
+			return
+		}
+		responses <- response.StatusCode
+	}()
+	<-store.saving
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- srv.Shutdown(context.Background())
+	}()
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown must wait for in-flight request")
+	case <-time.After(100 * time.Millisecond):
+	}
+	// new requests are not accepted
+	_, err = http.Get(url)
+	assert.NotNil(t, err)
+
+	close(store.release)
+	assert.Equal(t, http.StatusOK, <-responses)
+	assert.Nil(t, <-shutdown)
+	assert.Nil(t, <-srv.Done())
+	assert.Len(t, store.audit, 1)
+}
+
+func TestStartError(t *testing.T) {
+	_, err := Start(newMockStore(), &Config{Listener: &Listener{Address: "256.0.0.1:0"}})
+	assert.NotNil(t, err)
+}
@@ -2,16 +2,28 @@ package server
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/lukaszbudnik/auditor/auth"
+	"github.com/lukaszbudnik/auditor/checkpoint"
+	"github.com/lukaszbudnik/auditor/encryption"
+	"github.com/lukaszbudnik/auditor/export"
 	"github.com/lukaszbudnik/auditor/model"
 	"github.com/lukaszbudnik/auditor/store"
+	"github.com/lukaszbudnik/auditor/validation"
 	"github.com/lukaszbudnik/migrator/common"
 	"gopkg.in/validator.v2"
 )
@@ -21,6 +33,47 @@ const (
 	requestIDHeader string = "X-Request-Id"
 )
 
+// defaultBlockType is used when block schema is not configured
+var defaultBlockType = reflect.TypeOf(model.Block{})
+
+func newBlock(blockType reflect.Type) interface{} {
+	return reflect.New(blockType).Interface()
+}
+
+func newBlocks(blockType reflect.Type) interface{} {
+	blocks := reflect.New(reflect.SliceOf(blockType))
+	blocks.Elem().Set(reflect.MakeSlice(reflect.SliceOf(blockType), 0, 0))
+	return blocks.Interface()
+}
+
+// newRecord creates block recording operation performed by auditor itself,
+// Category and Event (Action and Reason for model.Event) fields are set only when block type has them
+func newRecord(blockType reflect.Type, category, event string) interface{} {
+	block := newBlock(blockType)
+	model.SchemaOf(blockType).SetSort(block, sortNow(blockType))
+	for name, value := range map[string]string{"Category": category, "Event": event, "Action": category, "Reason": event} {
+		if field, ok := blockType.FieldByName(name); ok && field.Type.Kind() == reflect.String {
+			model.SetFieldValue(block, field, value)
+		}
+	}
+	return block
+}
+
+// sortNow returns current time in UTC at millisecond precision (the way stores return it) as a value of sort field type:
+// time, nanoseconds since epoch for int64, or RFC3339Nano UTC timestamp for string,
+// blocks created by server are saved with saveSequenced which replaces int64 values with head + 1
+func sortNow(blockType reflect.Type) interface{} {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	switch model.SchemaOf(blockType).SortField().Type.Kind() {
+	case reflect.Int64:
+		return now.UnixNano()
+	case reflect.String:
+		return now.UTC().Format(time.RFC3339Nano)
+	default:
+		return &now
+	}
+}
+
 func getLimit(r *http.Request) int64 {
 	s := r.URL.Query().Get("limit")
 	limit, err := strconv.ParseInt(s, 10, 64)
@@ -31,16 +84,20 @@ func getLimit(r *http.Request) int64 {
 }
 
 func getLastBlock(r *http.Request, result interface{}) {
-	t := r.URL.Query().Get("sort")
-	time, err := time.Parse(time.RFC3339Nano, t)
+	schema := model.SchemaFor(result)
+	sort, err := schema.ParseSort(r.URL.Query().Get("sort"))
 	if err == nil {
-		fields := model.GetFieldsTaggedWith(result, "sort")
-		model.SetFieldValue(result, fields[0], &time)
+		schema.SetSort(result, sort)
 	}
-	fields := model.GetFieldsTaggedWith(result, "dynamodb_partition")
-	if len(fields) > 0 {
-		partition := r.URL.Query().Get(fields[0].Name)
-		model.SetFieldValue(result, fields[0], partition)
+	setPartition(r, result)
+}
+
+// setPartition sets field tagged with dynamodb_partition from query parameter of the same name
+func setPartition(r *http.Request, block interface{}) {
+	schema := model.SchemaFor(block)
+	if schema.HasPartition() {
+		partition := r.URL.Query().Get(schema.PartitionField().Name)
+		schema.SetPartition(block, partition)
 	}
 }
 
@@ -62,6 +119,42 @@ func errorInternalServerErrorResponse(w http.ResponseWriter, err error) {
 	errorResponseWithStatusAndErrorMessage(w, http.StatusInternalServerError, err.Error())
 }
 
+func unprocessableEntityResponse(w http.ResponseWriter, violations []validation.Violation) {
+	errorResponse(w, http.StatusUnprocessableEntity, struct {
+		ErrorMessage string
+		Violations   []validation.Violation
+	}{http.StatusText(http.StatusUnprocessableEntity), violations})
+}
+
+// validateTagRules maps errors of validate tags to names of their rules
+var validateTagRules = map[error]string{
+	validator.ErrZeroValue: "nonzero",
+	validator.ErrMin:       "min",
+	validator.ErrMax:       "max",
+	validator.ErrLen:       "len",
+	validator.ErrRegexp:    "regexp",
+}
+
+// tagViolations converts errors of validate tags into violations sorted by field, it returns false when err is not a validator.ErrorMap
+func tagViolations(err error) ([]validation.Violation, bool) {
+	errorMap, ok := err.(validator.ErrorMap)
+	if !ok {
+		return []validation.Violation{}, err == nil
+	}
+	violations := []validation.Violation{}
+	for field, fieldErrors := range errorMap {
+		for _, fieldError := range fieldErrors {
+			rule, ok := validateTagRules[fieldError]
+			if !ok {
+				rule = "validate"
+			}
+			violations = append(violations, validation.Violation{Field: field, Rule: rule, Message: fieldError.Error()})
+		}
+	}
+	sort.Slice(violations, func(i, j int) bool { return violations[i].Field < violations[j].Field })
+	return violations, true
+}
+
 func jsonResponse(w http.ResponseWriter, response interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -74,6 +167,11 @@ func okResponseWithMessage(w http.ResponseWriter, hash, previousHash string) {
 	}{hash, previousHash})
 }
 
+func okResponseWithBlock(w http.ResponseWriter, block interface{}) {
+	schema := model.SchemaFor(block)
+	okResponseWithMessage(w, schema.Hash(block), schema.PreviousHash(block))
+}
+
 func tracing(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// requestID
@@ -89,13 +187,33 @@ func tracing(next http.Handler) http.Handler {
 	})
 }
 
-func makeHandler(handler func(http.ResponseWriter, *http.Request, store.Store), store store.Store) http.HandlerFunc {
+// handlerOptions holds optional components used by handlers of a chain, all of them can be nil
+type handlerOptions struct {
+	rules             *validation.Rules
+	timestamps        *Timestamps
+	checkpointer      *checkpoint.Checkpointer
+	authorizer        *authorizer
+	access            *accessLog
+	exportKey         ed25519.PrivateKey
+	fingerprintSecret []byte
+}
+
+// makeHandler creates handler of a chain which does not use any of optional components
+func makeHandler(handler func(http.ResponseWriter, *http.Request, store.Store, reflect.Type, *handlerOptions), store store.Store, blockType reflect.Type) http.HandlerFunc {
+	return makeHandlerWithOptions(handler, store, blockType, nil)
+}
+
+// makeHandlerWithOptions creates handler of a chain, options can be nil
+func makeHandlerWithOptions(handler func(http.ResponseWriter, *http.Request, store.Store, reflect.Type, *handlerOptions), store store.Store, blockType reflect.Type, options *handlerOptions) http.HandlerFunc {
+	if options == nil {
+		options = &handlerOptions{}
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		handler(w, r, store)
+		handler(w, r, store, blockType, options)
 	}
 }
 
-func auditHandler(w http.ResponseWriter, r *http.Request, store store.Store) {
+func auditHandler(w http.ResponseWriter, r *http.Request, store store.Store, blockType reflect.Type, options *handlerOptions) {
 	if r.Method != http.MethodGet && r.Method != http.MethodPost {
 		common.LogError(r.Context(), "Wrong method: %v", r.Method)
 		errorDefaultResponse(w, http.StatusMethodNotAllowed)
@@ -103,30 +221,54 @@ func auditHandler(w http.ResponseWriter, r *http.Request, store store.Store) {
 	}
 	common.LogInfo(r.Context(), "Start")
 	if r.Method == http.MethodGet {
-		auditGetHandler(w, r, store)
+		auditGetHandler(w, r, store, blockType, options)
 	}
 	if r.Method == http.MethodPost {
-		auditPostHandler(w, r, store)
+		auditPostHandler(w, r, store, blockType, options)
 	}
 }
 
-func auditGetHandler(w http.ResponseWriter, r *http.Request, store store.Store) {
+func auditGetHandler(w http.ResponseWriter, r *http.Request, store store.Store, blockType reflect.Type, options *handlerOptions) {
 	limit := getLimit(r)
 
-	lastBlock := &model.Block{}
+	lastBlock := newBlock(blockType)
 	getLastBlock(r, lastBlock)
+	if !options.authorizer.authorize(r, auth.Read, lastBlock, true) {
+		forbiddenResponse(w)
+		return
+	}
 
-	audit := []model.Block{}
-	err := store.Read(&audit, limit, lastBlock)
+	audit := newBlocks(blockType)
+	err := store.Read(audit, limit, lastBlock)
 	if err != nil {
 		errorInternalServerErrorResponse(w, err)
 		return
 	}
+	options.authorizer.filter(r, audit)
+	if err := options.access.record(r, "read", r.URL.Query(), reflect.ValueOf(audit).Elem().Len()); err != nil {
+		errorInternalServerErrorResponse(w, err)
+		return
+	}
+
+	if acceptsCloudEvents(r) {
+		events, err := renderCloudEvents(audit)
+		if err == errCloudEventsNotSupported {
+			errorResponseWithStatusAndErrorMessage(w, http.StatusNotAcceptable, err.Error())
+			return
+		}
+		if err != nil {
+			errorInternalServerErrorResponse(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", cloudEventsBatchContentType)
+		json.NewEncoder(w).Encode(events)
+		return
+	}
 
 	jsonResponse(w, audit)
 }
 
-func auditPostHandler(w http.ResponseWriter, r *http.Request, store store.Store) {
+func auditPostHandler(w http.ResponseWriter, r *http.Request, auditStore store.Store, blockType reflect.Type, options *handlerOptions) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		common.LogError(r.Context(), "Error reading request: %v", err.Error())
@@ -134,54 +276,469 @@ func auditPostHandler(w http.ResponseWriter, r *http.Request, store store.Store)
 		return
 	}
 
-	block := &model.Block{}
-	err = json.Unmarshal(body, block)
+	block := newBlock(blockType)
+	if isCloudEvent(r) {
+		err = decodeCloudEvent(r, body, block)
+	} else {
+		err = model.DecodeBlock(body, block)
+	}
+	if err == errCloudEventsNotSupported {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	if err == nil {
+		// only redaction can set commitments of redactable fields
+		err = model.ValidateNotRedacted(block)
+	}
 	if err != nil {
 		common.LogError(r.Context(), "Bad request: %v", err.Error())
 		errorResponseWithStatusAndErrorMessage(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	if !options.authorizer.authorize(r, auth.Write, block, false) {
+		forbiddenResponse(w)
+		return
+	}
+	setPrincipal(r, block)
+	if idempotent(w, r, auditStore, block, options.fingerprintSecret) {
+		return
+	}
+	options.timestamps.stamp(block)
 	err = validator.Validate(block)
-	if err != nil {
+	tagged, ok := tagViolations(err)
+	if err != nil && (options.rules == nil || !ok) {
 		common.LogError(r.Context(), "Validation error: %v", err.Error())
 		errorResponseWithStatusAndErrorMessage(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	if options.rules != nil {
+		violations, err := options.rules.Validate(block)
+		if err != nil {
+			errorInternalServerErrorResponse(w, err)
+			return
+		}
+		// when rules are enabled errors of validate tags are reported together with rule violations
+		violations = append(tagged, violations...)
+		if len(violations) > 0 {
+			common.LogError(r.Context(), "Validation rules violated: %v", violations)
+			unprocessableEntityResponse(w, violations)
+			return
+		}
+	}
+	skew, err := options.timestamps.skew(block, auditStore)
+	if err != nil {
+		errorInternalServerErrorResponse(w, err)
+		return
+	}
+	if len(skew) > 0 {
+		common.LogError(r.Context(), "Clock skew detected: %v", skew)
+		if options.timestamps.Mode == RejectSkewed {
+			unprocessableEntityResponse(w, skew)
+			return
+		}
+	}
+	// flag is set only by server
+	model.SchemaFor(block).SetSkewed(block, len(skew) > 0)
+
+	err = options.timestamps.save(auditStore, block)
+	if err == store.ErrDuplicateKey && replay(w, r, auditStore, block) {
+		// block with the same idempotency key was saved concurrently
+		return
+	}
+	if err == encryption.ErrSubjectErased {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		errorInternalServerErrorResponse(w, err)
+		return
+	}
+
+	okResponseWithBlock(w, block)
+}
+
+// makeBlockHandler creates handler for /audit/{hash}/{operation} requests, redaction requires admin permission, options can be nil
+func makeBlockHandler(store store.Store, blockType reflect.Type, options *handlerOptions) http.HandlerFunc {
+	if options == nil {
+		options = &handlerOptions{}
+	}
+	proof := makeProofHandler(options.checkpointer, options.access)
+	redact := makeHandlerWithOptions(redactHandler, store, blockType, options)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(strings.TrimRight(r.URL.Path, "/"), "/redact") {
+			redact(w, r)
+			return
+		}
+		proof(w, r)
+	}
+}
+
+// redactHandler authorizes redaction against partition and category of the stored block, not the ones sent in request
+func redactHandler(w http.ResponseWriter, r *http.Request, auditStore store.Store, blockType reflect.Type, options *handlerOptions) {
+	// expected path is /audit/{hash}/redact
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[2] != "redact" {
+		errorDefaultResponse(w, http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		common.LogError(r.Context(), "Wrong method: %v", r.Method)
+		errorDefaultResponse(w, http.StatusMethodNotAllowed)
+		return
+	}
+	common.LogInfo(r.Context(), "Start")
+	redactor, ok := auditStore.(store.Redactor)
+	if !ok {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusNotImplemented, store.ErrRedactionNotSupported.Error())
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		common.LogError(r.Context(), "Error reading request: %v", err.Error())
+		errorInternalServerErrorResponse(w, err)
+		return
+	}
+	request := struct {
+		Fields []string
+		Reason string
+	}{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		common.LogError(r.Context(), "Bad request: %v", err.Error())
+		errorResponseWithStatusAndErrorMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(request.Fields) == 0 || len(request.Reason) == 0 {
+		common.LogError(r.Context(), "Bad request: fields and reason are required")
+		errorResponseWithStatusAndErrorMessage(w, http.StatusBadRequest, "Fields and Reason are required")
+		return
+	}
+
+	hash := parts[1]
+	schema := model.SchemaOf(blockType)
+	stored := newBlock(blockType)
+	schema.SetHash(stored, hash)
+	setPartition(r, stored)
+	err = redactor.FindByHash(stored)
+	if err == store.ErrBlockNotFound {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err == store.ErrFindNotSupported {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusNotImplemented, err.Error())
+		return
+	}
+	if err != nil {
+		errorInternalServerErrorResponse(w, err)
+		return
+	}
+	if !options.authorizer.authorize(r, auth.Admin, stored, false) {
+		forbiddenResponse(w)
+		return
+	}
+
+	block := newBlock(blockType)
+	schema.SetHash(block, hash)
+	setPartition(r, block)
+	err = redactor.Redact(block, request.Fields)
+	if _, ok := err.(*model.NotRedactableError); ok {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err == store.ErrBlockNotFound {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		common.LogError(r.Context(), "Could not redact block: %v", err.Error())
+		errorInternalServerErrorResponse(w, err)
+		return
+	}
+
+	// redaction is recorded as its own block
+	redaction := newRecord(blockType, "redaction", fmt.Sprintf("block %v fields %v redacted: %v", hash, strings.Join(request.Fields, ","), request.Reason))
+	if schema.HasPartition() {
+		schema.SetPartition(redaction, schema.Partition(stored))
+	}
+	setPrincipal(r, redaction)
+	if err := saveSequenced(auditStore, redaction); err != nil {
+		common.LogError(r.Context(), "Block redacted but could not record redaction: %v", err.Error())
+		errorInternalServerErrorResponse(w, err)
+		return
+	}
+
+	okResponseWithBlock(w, redaction)
+}
+
+func makeCheckpointHandler(handler func(http.ResponseWriter, *http.Request, *checkpoint.Checkpointer), checkpointer *checkpoint.Checkpointer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, checkpointer)
+	}
+}
 
-	err = store.Save(block)
+// makeProofHandler creates handler for /audit/{hash}/proof requests, access can be nil
+func makeProofHandler(checkpointer *checkpoint.Checkpointer, access *accessLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proofHandler(w, r, checkpointer, access)
+	}
+}
+
+func proofHandler(w http.ResponseWriter, r *http.Request, checkpointer *checkpoint.Checkpointer, access *accessLog) {
+	// expected path is /audit/{hash}/proof
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[2] != "proof" {
+		errorDefaultResponse(w, http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		common.LogError(r.Context(), "Wrong method: %v", r.Method)
+		errorDefaultResponse(w, http.StatusMethodNotAllowed)
+		return
+	}
+	common.LogInfo(r.Context(), "Start")
+	if checkpointer == nil {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusNotFound, "checkpoints are not enabled")
+		return
+	}
+	proof, err := checkpointer.Proof(parts[1])
+	if err == checkpoint.ErrNotCheckpointed {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusNotFound, err.Error())
+		return
+	}
 	if err != nil {
 		errorInternalServerErrorResponse(w, err)
 		return
 	}
+	if err := access.record(r, "proof", url.Values{"hash": {parts[1]}}, 1); err != nil {
+		errorInternalServerErrorResponse(w, err)
+		return
+	}
 
-	hashField := model.GetFieldsTaggedWith(block, "hash")
-	hash := model.GetFieldStringValue(block, hashField[0])
+	jsonResponse(w, proof)
+}
 
-	previousHashField := model.GetFieldsTaggedWith(block, "previoushash")
-	previousHash := model.GetFieldStringValue(block, previousHashField[0])
+func consistencyHandler(w http.ResponseWriter, r *http.Request, checkpointer *checkpoint.Checkpointer) {
+	if r.Method != http.MethodGet {
+		common.LogError(r.Context(), "Wrong method: %v", r.Method)
+		errorDefaultResponse(w, http.StatusMethodNotAllowed)
+		return
+	}
+	common.LogInfo(r.Context(), "Start")
+	if checkpointer == nil {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusNotFound, "checkpoints are not enabled")
+		return
+	}
+	from, err1 := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+	to, err2 := strconv.ParseInt(r.URL.Query().Get("to"), 10, 64)
+	if err1 != nil || err2 != nil || from > to {
+		common.LogError(r.Context(), "Bad request: from: %v to: %v", r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+		errorResponseWithStatusAndErrorMessage(w, http.StatusBadRequest, "from and to must be sizes of checkpoints and from must not be greater than to")
+		return
+	}
+	proof, err := checkpointer.Consistency(from, to)
+	if err == checkpoint.ErrCheckpointNotFound {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		errorInternalServerErrorResponse(w, err)
+		return
+	}
+
+	jsonResponse(w, proof)
+}
+
+func makeErasureHandler(handler func(http.ResponseWriter, *http.Request, store.Store, reflect.Type, *encryption.SubjectKeys), store store.Store, blockType reflect.Type, subjects *encryption.SubjectKeys) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, store, blockType, subjects)
+	}
+}
+
+func erasureHandler(w http.ResponseWriter, r *http.Request, store store.Store, blockType reflect.Type, subjects *encryption.SubjectKeys) {
+	// expected path is /subjects/{subject}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || len(parts[1]) == 0 {
+		errorDefaultResponse(w, http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		common.LogError(r.Context(), "Wrong method: %v", r.Method)
+		errorDefaultResponse(w, http.StatusMethodNotAllowed)
+		return
+	}
+	common.LogInfo(r.Context(), "Start")
+	if subjects == nil {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusNotFound, "subject keys are not enabled")
+		return
+	}
+	subject := parts[1]
+	// erasure block is saved in partition sent in query parameter, it cannot be derived from subject which is not kept in blocks
+	schema := model.SchemaOf(blockType)
+	if schema.HasPartition() && len(r.URL.Query().Get(schema.PartitionField().Name)) == 0 {
+		errorResponseWithStatusAndErrorMessage(w, http.StatusBadRequest, schema.PartitionField().Name+" is required")
+		return
+	}
+	// neither subject nor its pseudonym is kept in erasure block, erasure is recorded with random token returned to the caller
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		errorInternalServerErrorResponse(w, err)
+		return
+	}
+	// erasure is recorded before subject key is destroyed, when it cannot be recorded key is kept,
+	// when key cannot be destroyed request can be repeated
+	block := newRecord(blockType, "erasure", fmt.Sprintf("subject erasure %x", token))
+	setPartition(r, block)
+	setPrincipal(r, block)
+	if err := saveSequenced(store, block); err != nil {
+		common.LogError(r.Context(), "Could not record erasure: %v", err.Error())
+		errorInternalServerErrorResponse(w, err)
+		return
+	}
+	if err := subjects.Erase(subject); err != nil {
+		common.LogError(r.Context(), "Erasure recorded but could not erase subject key: %v", err.Error())
+		errorInternalServerErrorResponse(w, err)
+		return
+	}
+
+	jsonResponse(w, struct {
+		Hash         string
+		PreviousHash string
+		Token        string
+	}{schema.Hash(block), schema.PreviousHash(block), fmt.Sprintf("%x", token)})
+}
+
+// Chain is an additional block type served at /audit/{name}, every chain is backed by its own store
+type Chain struct {
+	Store     store.Store
+	BlockType reflect.Type
+	Rules     *validation.Rules
+}
 
-	okResponseWithMessage(w, hash, previousHash)
+// Config holds optional components used by the server, all of them can be nil,
+// when BlockType is nil model.Block is used
+type Config struct {
+	BlockType         reflect.Type
+	Chains            map[string]*Chain
+	Checkpointer      *checkpoint.Checkpointer
+	Subjects          *encryption.SubjectKeys
+	Rules             *validation.Rules
+	Timestamps        *Timestamps
+	Listener          *Listener
+	Authenticator     auth.Authenticator
+	Policy            *auth.Policy
+	Access            store.Store
+	ExportKey         ed25519.PrivateKey
+	FingerprintSecret []byte
 }
 
-func registerHandlers(store store.Store) *http.ServeMux {
+// rewritePrefix replaces prefix of request path so that handlers of additional chains
+// can parse paths the same way handlers of the default chain do
+func rewritePrefix(prefix, replacement string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = replacement + strings.TrimPrefix(r.URL.Path, prefix)
+		r2.URL.RawPath = ""
+		handler.ServeHTTP(w, r2)
+	})
+}
+
+func registerHandlers(store store.Store, config *Config) *http.ServeMux {
+	blockType := config.BlockType
+	if blockType == nil {
+		blockType = defaultBlockType
+	}
 	router := http.NewServeMux()
 	router.Handle("/", http.NotFoundHandler())
-	router.Handle("/audit", makeHandler(auditHandler, store))
+	options := &handlerOptions{
+		rules:             config.Rules,
+		timestamps:        config.Timestamps,
+		checkpointer:      config.Checkpointer,
+		authorizer:        newAuthorizer(config.Policy, auth.DefaultChain),
+		access:            newAccessLog(config.Access, auth.DefaultChain),
+		exportKey:         config.ExportKey,
+		fingerprintSecret: config.FingerprintSecret,
+	}
+	router.Handle("/audit", makeHandlerWithOptions(auditHandler, store, blockType, options))
+	router.Handle("/audit/", makeBlockHandler(store, blockType, options))
+	router.Handle("/audit/"+export.Name, makeHandlerWithOptions(exportHandler, store, blockType, options))
+	router.Handle("/checkpoints/consistency", makeCheckpointHandler(consistencyHandler, config.Checkpointer))
+	// subject keys are shared by all chains, erasure requires admin permission not restricted to any chain, partition, or category
+	router.Handle("/subjects/", options.authorizer.requireUnrestricted(auth.Admin, makeErasureHandler(erasureHandler, store, blockType, config.Subjects)))
+	// checkpoints are created only for the default chain
+	for name, chain := range config.Chains {
+		prefix := "/audit/" + name
+		options := &handlerOptions{
+			rules:             chain.Rules,
+			timestamps:        config.Timestamps,
+			authorizer:        newAuthorizer(config.Policy, name),
+			access:            newAccessLog(config.Access, name),
+			exportKey:         config.ExportKey,
+			fingerprintSecret: config.FingerprintSecret,
+		}
+		router.Handle(prefix, makeHandlerWithOptions(auditHandler, chain.Store, chain.BlockType, options))
+		router.Handle(prefix+"/", rewritePrefix(prefix, "/audit", makeBlockHandler(chain.Store, chain.BlockType, options)))
+		router.Handle(prefix+"/"+export.Name, makeHandlerWithOptions(exportHandler, chain.Store, chain.BlockType, options))
+	}
 	return router
 }
 
-// Start starts simple Auditor API
-func Start(store store.Store) (*http.Server, error) {
-	log.Printf("INFO auditor starting on port %s", defaultPort)
+// NewHandler creates handler serving Auditor API, it can be mounted in another HTTP server
+func NewHandler(store store.Store, config *Config) http.Handler {
+	return tracing(authenticating(config.Authenticator, registerHandlers(store, config)))
+}
 
-	router := registerHandlers(store)
+// Server is a running Auditor API
+type Server struct {
+	server   *http.Server
+	listener net.Listener
+	done     chan error
+}
 
-	server := &http.Server{
-		Addr:    ":" + defaultPort,
-		Handler: tracing(router),
+// Start starts Auditor API in a background goroutine, it returns when server is listening
+// or with error when listener could not be opened
+func Start(store store.Store, config *Config) (*Server, error) {
+	listener := config.Listener
+	if listener == nil {
+		listener = &Listener{}
 	}
+	ln, err := listener.listen()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("INFO auditor listening on %v (TLS: %v, client certificates: %v)", ln.Addr(), listener.tls(), len(listener.ClientCAFile) > 0)
 
-	err := server.ListenAndServe()
+	s := &Server{
+		server:   &http.Server{Handler: NewHandler(store, config)},
+		listener: ln,
+		done:     make(chan error, 1),
+	}
+	go func() {
+		err := s.server.Serve(ln)
+		if err == http.ErrServerClosed {
+			err = nil
+		}
+		s.done <- err
+		close(s.done)
+	}()
+	return s, nil
+}
+
+// Addr returns address server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Done returns channel which receives error when server stops serving, nil when it was shut down
+func (s *Server) Done() <-chan error {
+	return s.done
+}
 
-	return server, err
+// Shutdown stops accepting new requests and waits until in-flight requests complete (including store writes
+// and locks they hold) or ctx expires, store is not closed as it is owned by caller
+func (s *Server) Shutdown(ctx context.Context) error {
+	log.Printf("INFO auditor shutting down")
+	return s.server.Shutdown(ctx)
 }
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// discardStore computes hashes like real stores do but does not keep blocks
+type discardStore struct {
+	mockStore
+}
+
+func (ds *discardStore) Save(block interface{}) error {
+	if err := ds.mockStore.Save(block); err != nil {
+		return err
+	}
+	ds.audit = ds.audit[len(ds.audit)-1:]
+	return nil
+}
+
+func BenchmarkAuditPost(b *testing.B) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	handler := makeHandler(auditHandler, &discardStore{}, defaultBlockType)
+	input := []byte(fmt.Sprintf(`{"Customer": "abc", "Timestamp": "%v", "Category": "restapi", "Event": "record updated"}`, time.Now().Format(time.RFC3339Nano)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, _ := newTestRequest(http.MethodPost, "http://example.com/audit", bytes.NewReader(input))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusOK {
+			b.Fatalf("unexpected status: %v", w.Code)
+		}
+	}
+}
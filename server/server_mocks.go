@@ -5,12 +5,14 @@ import (
 	"reflect"
 
 	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store"
 )
 
 type mockStore struct {
 	errorThreshold int
 	counter        int
 	audit          []model.Block
+	redacted       []string
 }
 
 func (ms *mockStore) Save(block interface{}) error {
@@ -33,7 +35,7 @@ func (ms *mockStore) Read(result interface{}, limit int64, last interface{}) err
 
 	resultv := reflect.ValueOf(result)
 	slicev := resultv.Elem()
-	slicev = slicev.Slice(0, slicev.Cap())
+	slicev = slicev.Slice(0, 0)
 
 	for _, b := range ms.audit {
 		slicev = reflect.Append(slicev, reflect.ValueOf(b))
@@ -44,5 +46,174 @@ func (ms *mockStore) Read(result interface{}, limit int64, last interface{}) err
 	return nil
 }
 
+func (ms *mockStore) FindByHash(block interface{}) error {
+	hash := block.(*model.Block).Hash
+	for i := range ms.audit {
+		if ms.audit[i].Hash == hash {
+			*block.(*model.Block) = ms.audit[i]
+			return nil
+		}
+	}
+	return store.ErrBlockNotFound
+}
+
+func (ms *mockStore) Redact(block interface{}, fields []string) error {
+	hash := block.(*model.Block).Hash
+	for i := range ms.audit {
+		if ms.audit[i].Hash == hash {
+			ms.redacted = append(ms.redacted, fields...)
+			*block.(*model.Block) = ms.audit[i]
+			return nil
+		}
+	}
+	return store.ErrBlockNotFound
+}
+
 func (ms *mockStore) Close() {
 }
+
+type mockCheckpointStore struct {
+	checkpoints []model.Checkpoint
+}
+
+func (ms *mockCheckpointStore) Save(block interface{}) error {
+	if len(ms.checkpoints) > 0 {
+		model.SetPreviousHash(block, &ms.checkpoints[len(ms.checkpoints)-1])
+	}
+	model.ComputeAndSetHash(block)
+	ms.checkpoints = append(ms.checkpoints, *block.(*model.Checkpoint))
+	return nil
+}
+
+func (ms *mockCheckpointStore) Read(result interface{}, limit int64, last interface{}) error {
+	checkpoints := result.(*[]model.Checkpoint)
+	for i := len(ms.checkpoints) - 1; i >= 0; i-- {
+		*checkpoints = append(*checkpoints, ms.checkpoints[i])
+	}
+	return nil
+}
+
+func (ms *mockCheckpointStore) Close() {
+}
+
+// mockRecordStore stores blocks of any type
+type mockRecordStore struct {
+	records []interface{}
+}
+
+func (ms *mockRecordStore) Save(block interface{}) error {
+	if len(ms.records) > 0 {
+		previous := reflect.New(reflect.TypeOf(block).Elem())
+		previous.Elem().Set(reflect.ValueOf(ms.records[len(ms.records)-1]))
+		model.SetPreviousHash(block, previous.Interface())
+	}
+	model.ComputeAndSetHash(block)
+	ms.records = append(ms.records, reflect.ValueOf(block).Elem().Interface())
+	return nil
+}
+
+func (ms *mockRecordStore) Read(result interface{}, limit int64, last interface{}) error {
+	slicev := reflect.ValueOf(result).Elem()
+	for i := len(ms.records) - 1; i >= 0; i-- {
+		slicev = reflect.Append(slicev, reflect.ValueOf(ms.records[i]))
+	}
+	reflect.ValueOf(result).Elem().Set(slicev)
+	return nil
+}
+
+func (ms *mockRecordStore) Close() {
+}
+
+// mockSequencerStore is mockRecordStore which implements store.Sequencer
+type mockSequencerStore struct {
+	mockRecordStore
+}
+
+func (ms *mockSequencerStore) SaveSequenced(block interface{}, sequence func(block, head interface{})) error {
+	var head interface{}
+	if len(ms.records) > 0 {
+		previous := reflect.New(reflect.TypeOf(block).Elem())
+		previous.Elem().Set(reflect.ValueOf(ms.records[len(ms.records)-1]))
+		head = previous.Interface()
+	}
+	sequence(block, head)
+	return ms.Save(block)
+}
+
+// mockDedupStore is mockRecordStore with unique idempotency keys
+type mockDedupStore struct {
+	mockRecordStore
+	// race makes FindByIdempotencyKey miss blocks as if they were saved concurrently
+	race bool
+}
+
+func (ms *mockDedupStore) Save(block interface{}) error {
+	key := model.SchemaFor(block).IdempotencyKey(block)
+	if _, ok := ms.find(block); len(key) > 0 && ok {
+		return store.ErrDuplicateKey
+	}
+	return ms.mockRecordStore.Save(block)
+}
+
+func (ms *mockDedupStore) FindByIdempotencyKey(block interface{}) error {
+	found, ok := ms.find(block)
+	if ms.race || !ok {
+		ms.race = false
+		return store.ErrBlockNotFound
+	}
+	reflect.ValueOf(block).Elem().Set(found.Elem())
+	return nil
+}
+
+func (ms *mockDedupStore) find(block interface{}) (reflect.Value, bool) {
+	schema := model.SchemaFor(block)
+	for i := range ms.records {
+		record := reflect.New(schema.Type)
+		record.Elem().Set(reflect.ValueOf(ms.records[i]))
+		if schema.IdempotencyKey(record.Interface()) == schema.IdempotencyKey(block) {
+			return record, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// mockPagingStore is mockRecordStore which honours limit and last arguments of Read,
+// when partitioned is set it reads only blocks of the partition of last, the way DynamoDB does
+type mockPagingStore struct {
+	mockRecordStore
+	reads       int
+	partitioned bool
+}
+
+func (ms *mockPagingStore) Read(result interface{}, limit int64, last interface{}) error {
+	ms.reads++
+	slicev := reflect.ValueOf(result).Elem()
+	schema := model.SchemaOf(slicev.Type().Elem())
+	for i := len(ms.records) - 1; i >= 0 && int64(slicev.Len()) < limit; i-- {
+		record := reflect.New(schema.Type)
+		record.Elem().Set(reflect.ValueOf(ms.records[i]))
+		if last != nil && schema.HasSort(last) && !schema.SortBefore(record.Interface(), last) {
+			continue
+		}
+		if ms.partitioned && schema.Partition(record.Interface()) != schema.Partition(last) {
+			continue
+		}
+		slicev = reflect.Append(slicev, record.Elem())
+	}
+	reflect.ValueOf(result).Elem().Set(slicev)
+	return nil
+}
+
+// FindByHash finds block by hash in all partitions
+func (ms *mockPagingStore) FindByHash(block interface{}) error {
+	schema := model.SchemaFor(block)
+	for i := range ms.records {
+		record := reflect.New(schema.Type)
+		record.Elem().Set(reflect.ValueOf(ms.records[i]))
+		if schema.Hash(record.Interface()) == schema.Hash(block) {
+			reflect.ValueOf(block).Elem().Set(record.Elem())
+			return nil
+		}
+	}
+	return store.ErrBlockNotFound
+}
@@ -3,17 +3,25 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/lukaszbudnik/auditor/checkpoint"
+	"github.com/lukaszbudnik/auditor/encryption"
 	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/schema"
 	"github.com/lukaszbudnik/auditor/store"
+	"github.com/lukaszbudnik/auditor/store/storetest"
+	"github.com/lukaszbudnik/auditor/validation"
 	"github.com/lukaszbudnik/migrator/common"
 	"github.com/stretchr/testify/assert"
 )
@@ -111,7 +119,7 @@ func TestGetLastBlockError(t *testing.T) {
 
 func TestRegisterHandlers(t *testing.T) {
 	mockStore := newMockStore()
-	router := registerHandlers(mockStore)
+	router := registerHandlers(mockStore, &Config{})
 	assert.NotNil(t, router)
 }
 
@@ -132,7 +140,7 @@ func TestAuditMethodNotAllowed(t *testing.T) {
 		req, _ := newTestRequest(httpMethod, "http://example.com/audit", nil)
 
 		w := httptest.NewRecorder()
-		handler := makeHandler(auditHandler, newMockStore())
+		handler := makeHandler(auditHandler, newMockStore(), defaultBlockType)
 		handler(w, req)
 
 		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
@@ -144,7 +152,7 @@ func TestAuditGet(t *testing.T) {
 	time, _ := time.Parse(time.RFC3339Nano, "2019-01-03T08:09:09.611985+01:00")
 	audit := []model.Block{}
 	audit = append(audit, model.Block{Customer: "a", Timestamp: &time, Event: "some event", Category: "cat", Subcategory: "subcat", Hash: "1234567890abcdef", PreviousHash: "0987654321xyzghj"})
-	handler := makeHandler(auditHandler, newMockStoreWithAudit(audit)())
+	handler := makeHandler(auditHandler, newMockStoreWithAudit(audit)(), defaultBlockType)
 
 	req, _ := newTestRequest(http.MethodGet, "http://example.com/audit", nil)
 	w := httptest.NewRecorder()
@@ -156,7 +164,7 @@ func TestAuditGet(t *testing.T) {
 }
 
 func TestAuditGetReadError(t *testing.T) {
-	handler := makeHandler(auditHandler, newMockStoreWithError(1)())
+	handler := makeHandler(auditHandler, newMockStoreWithError(1)(), defaultBlockType)
 
 	req, _ := newTestRequest(http.MethodGet, "http://example.com/audit", nil)
 	w := httptest.NewRecorder()
@@ -172,7 +180,7 @@ func TestAuditPost(t *testing.T) {
 	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit", json)
 
 	w := httptest.NewRecorder()
-	handler := makeHandler(auditHandler, newMockStore())
+	handler := makeHandler(auditHandler, newMockStore(), defaultBlockType)
 	handler(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
@@ -182,7 +190,7 @@ func TestAuditPost(t *testing.T) {
 func TestAuditPostPreviousHash(t *testing.T) {
 	audit := []model.Block{}
 	audit = append(audit, model.Block{Hash: "1234567890abcdef"})
-	handler := makeHandler(auditHandler, newMockStoreWithAudit(audit)())
+	handler := makeHandler(auditHandler, newMockStoreWithAudit(audit)(), defaultBlockType)
 
 	json := newJSONInput()
 	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit", json)
@@ -198,7 +206,7 @@ func TestAuditPostRequestIOError(t *testing.T) {
 	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit", errReader(0))
 
 	w := httptest.NewRecorder()
-	handler := makeHandler(auditHandler, newMockStore())
+	handler := makeHandler(auditHandler, newMockStore(), defaultBlockType)
 	handler(w, req)
 
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
@@ -211,7 +219,7 @@ func TestAuditPostJSONError(t *testing.T) {
 	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit", bytes.NewBufferString(json))
 
 	w := httptest.NewRecorder()
-	handler := makeHandler(auditHandler, newMockStore())
+	handler := makeHandler(auditHandler, newMockStore(), defaultBlockType)
 	handler(w, req)
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
@@ -219,13 +227,36 @@ func TestAuditPostJSONError(t *testing.T) {
 	assert.Equal(t, `{"ErrorMessage":"invalid character ']' after object key"}`, strings.TrimSpace(w.Body.String()))
 }
 
+type redactableBlock struct {
+	Timestamp    *time.Time        `auditor:"sort"`
+	Email        string            `auditor:"redactable"`
+	Salts        map[string]string `auditor:"salts"`
+	Hash         string            `auditor:"hash"`
+	PreviousHash string            `auditor:"previoushash"`
+}
+
+func TestAuditPostRedactedValue(t *testing.T) {
+	ms := &mockRecordStore{}
+	router := registerHandlers(ms, &Config{BlockType: reflect.TypeOf(redactableBlock{})})
+
+	// client cannot choose commitment of a value which was never bound to the block
+	w := postBlock(router, `{"Timestamp": "2019-01-01T12:00:00Z", "Email": "redacted:0123456789abcdef"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"field Email must not start with redacted:"}`, strings.TrimSpace(w.Body.String()))
+	assert.Len(t, ms.records, 0)
+
+	w = postBlock(router, `{"Timestamp": "2019-01-01T12:00:00Z", "Email": "john@example.com"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, ms.records, 1)
+}
+
 func TestAuditPostValidationError(t *testing.T) {
 	// Timestamp is required
 	json := fmt.Sprintf(`{"Event": "new event"}`)
 	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit", bytes.NewBufferString(json))
 
 	w := httptest.NewRecorder()
-	handler := makeHandler(auditHandler, newMockStore())
+	handler := makeHandler(auditHandler, newMockStore(), defaultBlockType)
 	handler(w, req)
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
@@ -233,12 +264,43 @@ func TestAuditPostValidationError(t *testing.T) {
 	assert.Equal(t, `{"ErrorMessage":"Timestamp: zero value"}`, strings.TrimSpace(w.Body.String()))
 }
 
+func TestAuditPostRulesViolated(t *testing.T) {
+	rules := &validation.Rules{
+		Required:   []string{"Customer"},
+		Properties: map[string]*validation.Property{"Category": {Enum: []interface{}{"login", "logout"}}},
+	}
+	json := fmt.Sprintf(`{"Event": "new event", "Category": "signup", "Timestamp": "%v"}`, time.Now().Format(time.RFC3339Nano))
+	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit", bytes.NewBufferString(json))
+
+	w := httptest.NewRecorder()
+	handler := makeHandlerWithOptions(auditHandler, newMockStore(), defaultBlockType, &handlerOptions{rules: rules})
+	handler(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, "application/json", w.HeaderMap["Content-Type"][0])
+	assert.Equal(t, `{"ErrorMessage":"Unprocessable Entity","Violations":[{"Field":"Category","Rule":"enum","Message":"must be one of: login, logout"},{"Field":"Customer","Rule":"required","Message":"is required"}]}`, strings.TrimSpace(w.Body.String()))
+
+	// errors of validate tags are listed together with rule violations
+	req, _ = newTestRequest(http.MethodPost, "http://example.com/audit", bytes.NewBufferString(`{"Event": "new event", "Category": "signup"}`))
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"Unprocessable Entity","Violations":[{"Field":"Timestamp","Rule":"nonzero","Message":"zero value"},{"Field":"Category","Rule":"enum","Message":"must be one of: login, logout"},{"Field":"Customer","Rule":"required","Message":"is required"}]}`, strings.TrimSpace(w.Body.String()))
+
+	json = fmt.Sprintf(`{"Event": "new event", "Category": "login", "Customer": "abc", "Timestamp": "%v"}`, time.Now().Format(time.RFC3339Nano))
+	req, _ = newTestRequest(http.MethodPost, "http://example.com/audit", bytes.NewBufferString(json))
+	w = httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
 func TestAuditPostStoreReadError(t *testing.T) {
 	json := newJSONInput()
 	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit", json)
 
 	w := httptest.NewRecorder()
-	handler := makeHandler(auditHandler, newMockStoreWithError(1)())
+	handler := makeHandler(auditHandler, newMockStoreWithError(1)(), defaultBlockType)
 	handler(w, req)
 
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
@@ -251,10 +313,746 @@ func TestAuditPostStoreSaveError(t *testing.T) {
 	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit", json)
 
 	w := httptest.NewRecorder()
-	handler := makeHandler(auditHandler, newMockStoreWithError(1)())
+	handler := makeHandler(auditHandler, newMockStoreWithError(1)(), defaultBlockType)
 	handler(w, req)
 
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 	assert.Equal(t, "application/json", w.HeaderMap["Content-Type"][0])
 	assert.Equal(t, `{"ErrorMessage":"Error 1"}`, strings.TrimSpace(w.Body.String()))
 }
+
+func newTestCheckpointer(t *testing.T, count int) (*checkpoint.Checkpointer, []model.Block) {
+	audit := newMockStore()
+	for i := 0; i < count; i++ {
+		timestamp := time.Now()
+		audit.Save(&model.Block{Timestamp: &timestamp, Event: fmt.Sprintf("event %v", i)})
+	}
+	checkpointer, err := checkpoint.New(audit, &mockCheckpointStore{}, &model.Block{}, count, 0)
+	assert.Nil(t, err)
+	err = checkpointer.Run()
+	assert.Nil(t, err)
+	return checkpointer, audit.(*mockStore).audit
+}
+
+func TestProof(t *testing.T) {
+	checkpointer, audit := newTestCheckpointer(t, 3)
+	handler := makeProofHandler(checkpointer, nil)
+
+	req, _ := newTestRequest(http.MethodGet, fmt.Sprintf("http://example.com/audit/%v/proof", audit[1].Hash), nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.HeaderMap["Content-Type"][0])
+	proof := &checkpoint.Proof{}
+	err := json.Unmarshal(w.Body.Bytes(), proof)
+	assert.Nil(t, err)
+	assert.Equal(t, audit[1].Hash, proof.Hash)
+	assert.Equal(t, int64(1), proof.LeafIndex)
+	assert.Equal(t, int64(3), proof.TreeSize)
+	assert.True(t, checkpoint.VerifyProof(proof, checkpointer.Latest()))
+}
+
+func TestProofNotCheckpointed(t *testing.T) {
+	checkpointer, _ := newTestCheckpointer(t, 3)
+	handler := makeProofHandler(checkpointer, nil)
+
+	req, _ := newTestRequest(http.MethodGet, "http://example.com/audit/abc/proof", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"block not found in any checkpoint"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestProofCheckpointsNotEnabled(t *testing.T) {
+	handler := makeProofHandler(nil, nil)
+
+	req, _ := newTestRequest(http.MethodGet, "http://example.com/audit/abc/proof", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"checkpoints are not enabled"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestProofInvalidPath(t *testing.T) {
+	handler := makeProofHandler(nil, nil)
+
+	for _, path := range []string{"/audit/abc", "/audit/abc/proofs", "/audit/abc/proof/1"} {
+		req, _ := newTestRequest(http.MethodGet, "http://example.com"+path, nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+}
+
+func TestProofMethodNotAllowed(t *testing.T) {
+	handler := makeProofHandler(nil, nil)
+
+	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit/abc/proof", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestConsistency(t *testing.T) {
+	checkpointer, _ := newTestCheckpointer(t, 2)
+	handler := makeCheckpointHandler(consistencyHandler, checkpointer)
+
+	req, _ := newTestRequest(http.MethodGet, "http://example.com/checkpoints/consistency?from=2&to=2", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.HeaderMap["Content-Type"][0])
+	proof := &checkpoint.ConsistencyProof{}
+	err := json.Unmarshal(w.Body.Bytes(), proof)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), proof.FromSize)
+	assert.Equal(t, int64(2), proof.ToSize)
+	assert.True(t, checkpoint.VerifyConsistencyProof(proof, checkpointer.Latest(), checkpointer.Latest()))
+}
+
+func TestConsistencyNotFound(t *testing.T) {
+	checkpointer, _ := newTestCheckpointer(t, 2)
+	handler := makeCheckpointHandler(consistencyHandler, checkpointer)
+
+	req, _ := newTestRequest(http.MethodGet, "http://example.com/checkpoints/consistency?from=1&to=2", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"checkpoint not found"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestConsistencyBadRequest(t *testing.T) {
+	checkpointer, _ := newTestCheckpointer(t, 2)
+	handler := makeCheckpointHandler(consistencyHandler, checkpointer)
+
+	for _, query := range []string{"", "from=1", "from=a&to=2", "from=2&to=1"} {
+		req, _ := newTestRequest(http.MethodGet, "http://example.com/checkpoints/consistency?"+query, nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestConsistencyCheckpointsNotEnabled(t *testing.T) {
+	handler := makeCheckpointHandler(consistencyHandler, nil)
+
+	req, _ := newTestRequest(http.MethodGet, "http://example.com/checkpoints/consistency?from=1&to=2", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"checkpoints are not enabled"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestErasure(t *testing.T) {
+	subjects := encryption.NewSubjectKeys(&storetest.Keys{}, testSubjectSecret)
+	ms := newMockStore()
+	handler := makeErasureHandler(erasureHandler, ms, defaultBlockType, subjects)
+
+	req, _ := newTestRequest(http.MethodDelete, "http://example.com/subjects/abc?Customer=xyz", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.HeaderMap["Content-Type"][0])
+	audit := ms.(*mockStore).audit
+	assert.Len(t, audit, 1)
+	assert.Equal(t, "erasure", audit[0].Category)
+	assert.Equal(t, "xyz", audit[0].Customer)
+	response := struct{ Hash, PreviousHash, Token string }{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, audit[0].Hash, response.Hash)
+	assert.Len(t, response.Token, 32)
+	// subject is not kept in the chain, only the token returned to the caller
+	assert.Equal(t, "subject erasure "+response.Token, audit[0].Event)
+	assert.NotContains(t, audit[0].Event, "abc")
+}
+
+func TestErasurePartitionRequired(t *testing.T) {
+	keyring := &encryption.Keyring{Subjects: encryption.NewSubjectKeys(&storetest.Keys{}, testSubjectSecret)}
+	block := &subjectBlock{Customer: "abc", Email: "john@example.com"}
+	assert.Nil(t, encryption.Encrypt(keyring, block))
+	ms := newMockStore()
+	handler := makeErasureHandler(erasureHandler, ms, defaultBlockType, keyring.Subjects)
+
+	req, _ := newTestRequest(http.MethodDelete, "http://example.com/subjects/abc", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"Customer is required"}`, strings.TrimSpace(w.Body.String()))
+	assert.Len(t, ms.(*mockStore).audit, 0)
+	// erasure was not recorded so subject key is kept
+	assert.Nil(t, encryption.Decrypt(keyring, block))
+	assert.Equal(t, "john@example.com", block.Email)
+}
+
+var testSubjectSecret = []byte("01234567890123456789012345678901")
+
+type subjectBlock struct {
+	Customer     string     `auditor:"subject"`
+	Timestamp    *time.Time `auditor:"sort"`
+	Email        string     `auditor:"encrypt"`
+	Hash         string     `auditor:"hash"`
+	PreviousHash string     `auditor:"previoushash"`
+}
+
+func TestErasureSaveError(t *testing.T) {
+	keyring := &encryption.Keyring{Subjects: encryption.NewSubjectKeys(&storetest.Keys{}, testSubjectSecret)}
+	block := &subjectBlock{Customer: "abc", Email: "john@example.com"}
+	assert.Nil(t, encryption.Encrypt(keyring, block))
+	handler := makeErasureHandler(erasureHandler, newMockStoreWithError(1)(), defaultBlockType, keyring.Subjects)
+
+	req, _ := newTestRequest(http.MethodDelete, "http://example.com/subjects/abc?Customer=xyz", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"Error 1"}`, strings.TrimSpace(w.Body.String()))
+	// erasure was not recorded so subject key is kept
+	assert.Nil(t, encryption.Decrypt(keyring, block))
+	assert.Equal(t, "john@example.com", block.Email)
+}
+
+func TestAuditPostErasedSubject(t *testing.T) {
+	keyring := &encryption.Keyring{Subjects: encryption.NewSubjectKeys(&storetest.Keys{}, testSubjectSecret)}
+	router := registerHandlers(encryption.NewStore(&mockRecordStore{}, keyring), &Config{BlockType: reflect.TypeOf(subjectBlock{})})
+	assert.Nil(t, keyring.Subjects.Erase("abc"))
+
+	w := postBlock(router, `{"Customer": "abc", "Timestamp": "2019-01-01T12:00:00Z", "Email": "john@example.com"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"data subject was erased"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestErasureNotEnabled(t *testing.T) {
+	handler := makeErasureHandler(erasureHandler, newMockStore(), defaultBlockType, nil)
+
+	req, _ := newTestRequest(http.MethodDelete, "http://example.com/subjects/abc", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"subject keys are not enabled"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestErasureInvalidPathAndMethod(t *testing.T) {
+	handler := makeErasureHandler(erasureHandler, newMockStore(), defaultBlockType, nil)
+
+	for _, path := range []string{"/subjects/", "/subjects/abc/def"} {
+		req, _ := newTestRequest(http.MethodDelete, "http://example.com"+path, nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+
+	req, _ := newTestRequest(http.MethodGet, "http://example.com/subjects/abc", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestRedact(t *testing.T) {
+	now := time.Now()
+	ms := newMockStoreWithAudit([]model.Block{{Customer: "xyz", Timestamp: &now, Event: "signed up", Hash: "abc"}})()
+	handler := makeBlockHandler(ms, defaultBlockType, nil)
+
+	input := bytes.NewBufferString(`{"Fields": ["Event"], "Reason": "GDPR request"}`)
+	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit/abc/redact?Customer=xyz", input)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"Event"}, ms.(*mockStore).redacted)
+	audit := ms.(*mockStore).audit
+	assert.Len(t, audit, 2)
+	assert.Equal(t, "redaction", audit[1].Category)
+	assert.Equal(t, "block abc fields Event redacted: GDPR request", audit[1].Event)
+	assert.Equal(t, "xyz", audit[1].Customer)
+	assert.Equal(t, fmt.Sprintf(`{"Hash":"%v","PreviousHash":"abc"}`, audit[1].Hash), strings.TrimSpace(w.Body.String()))
+}
+
+func TestRedactNotFound(t *testing.T) {
+	handler := makeBlockHandler(newMockStore(), defaultBlockType, nil)
+
+	input := bytes.NewBufferString(`{"Fields": ["Event"], "Reason": "GDPR request"}`)
+	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit/abc/redact", input)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"block not found"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestRedactBadRequest(t *testing.T) {
+	handler := makeBlockHandler(newMockStore(), defaultBlockType, nil)
+
+	for _, body := range []string{`{"Fields": ["Event"]`, `{"Fields": [], "Reason": "GDPR request"}`, `{"Fields": ["Event"]}`} {
+		req, _ := newTestRequest(http.MethodPost, "http://example.com/audit/abc/redact", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestRedactWrongMethod(t *testing.T) {
+	handler := makeBlockHandler(newMockStore(), defaultBlockType, nil)
+
+	req, _ := newTestRequest(http.MethodGet, "http://example.com/audit/abc/redact", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestRedactNotSupported(t *testing.T) {
+	handler := makeBlockHandler(&mockCheckpointStore{}, defaultBlockType, nil)
+
+	input := bytes.NewBufferString(`{"Fields": ["Event"], "Reason": "GDPR request"}`)
+	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit/abc/redact", input)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"redaction is not supported"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestRedactEncryptedFindNotSupported(t *testing.T) {
+	// encrypted store implements Redactor even when underlying store cannot find blocks
+	keyring := &encryption.Keyring{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+	handler := makeBlockHandler(encryption.NewStore(&mockCheckpointStore{}, keyring), defaultBlockType, nil)
+
+	input := bytes.NewBufferString(`{"Fields": ["Event"], "Reason": "GDPR request"}`)
+	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit/abc/redact", input)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"finding blocks by hash is not supported"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestAuditDynamicBlockType(t *testing.T) {
+	blockType, err := schema.Build(&schema.Schema{Fields: []schema.Field{
+		{Name: "Timestamp", Type: "time", Auditor: []string{"sort"}, Validate: "nonzero"},
+		{Name: "Amount", Type: "float64", Validate: "min=1"},
+		{Name: "Event", Type: "string"},
+		{Name: "Hash", Type: "string", Auditor: []string{"hash"}},
+		{Name: "PreviousHash", Type: "string", Auditor: []string{"previoushash"}},
+	}})
+	assert.Nil(t, err)
+	ms := &mockRecordStore{}
+	router := registerHandlers(ms, &Config{BlockType: blockType})
+
+	timestamp := time.Now().Format(time.RFC3339Nano)
+	input := bytes.NewBufferString(fmt.Sprintf(`{"Timestamp": "%v", "Amount": 12.5, "Event": "payment"}`, timestamp))
+	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit", input)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, ms.records, 1)
+
+	// validation rules from schema are applied
+	input = bytes.NewBufferString(fmt.Sprintf(`{"Timestamp": "%v", "Amount": 0.5}`, timestamp))
+	req, _ = newTestRequest(http.MethodPost, "http://example.com/audit", input)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req, _ = newTestRequest(http.MethodGet, "http://example.com/audit", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	result := []map[string]interface{}{}
+	err = json.Unmarshal(w.Body.Bytes(), &result)
+	assert.Nil(t, err)
+	assert.Len(t, result, 1)
+	assert.Equal(t, 12.5, result[0]["Amount"])
+	assert.Equal(t, "payment", result[0]["Event"])
+	assert.NotContains(t, result[0], "Customer")
+}
+
+func TestAuditPayload(t *testing.T) {
+	blockType, err := schema.Build(&schema.Schema{Fields: []schema.Field{
+		{Name: "Timestamp", Type: "time", Auditor: []string{"sort"}},
+		{Name: "Event", Type: "string"},
+		{Name: "Details", Type: "object", Auditor: []string{"payload"}},
+		{Name: "Hash", Type: "string", Auditor: []string{"hash"}},
+		{Name: "PreviousHash", Type: "string", Auditor: []string{"previoushash"}},
+	}})
+	assert.Nil(t, err)
+	ms := &mockRecordStore{}
+	router := registerHandlers(ms, &Config{BlockType: blockType})
+
+	timestamp := time.Now().Format(time.RFC3339Nano)
+	input := bytes.NewBufferString(fmt.Sprintf(`{"Timestamp": "%v", "Event": "updated", "Details": {"changed": {"Email": {"old": "a@example.com", "new": "b@example.com"}}, "fields": 1}}`, timestamp))
+	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit", input)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = newTestRequest(http.MethodGet, "http://example.com/audit", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	result := []map[string]interface{}{}
+	err = json.Unmarshal(w.Body.Bytes(), &result)
+	assert.Nil(t, err)
+	assert.Len(t, result, 1)
+	details := result[0]["Details"].(map[string]interface{})
+	assert.Equal(t, float64(1), details["fields"])
+	assert.Equal(t, "b@example.com", details["changed"].(map[string]interface{})["Email"].(map[string]interface{})["new"])
+}
+
+func TestNewRecordDynamicBlockType(t *testing.T) {
+	blockType, err := schema.Build(&schema.Schema{Fields: []schema.Field{
+		{Name: "Timestamp", Type: "time", Auditor: []string{"sort"}},
+		{Name: "Event", Type: "string"},
+		{Name: "Hash", Type: "string", Auditor: []string{"hash"}},
+		{Name: "PreviousHash", Type: "string", Auditor: []string{"previoushash"}},
+	}})
+	assert.Nil(t, err)
+
+	// block type has no Category field
+	record := newRecord(blockType, "erasure", "subject abc erased")
+	event, _ := blockType.FieldByName("Event")
+	assert.Equal(t, "subject abc erased", model.GetFieldStringValue(record, event))
+	sort := model.GetFieldsTaggedWith(record, "sort")
+	assert.NotNil(t, model.GetFieldValue(record, sort[0]))
+}
+
+func TestGetLastBlockSequence(t *testing.T) {
+	blockType, err := schema.Build(&schema.Schema{Fields: []schema.Field{
+		{Name: "Sequence", Type: "int64", Auditor: []string{"sort"}},
+		{Name: "Hash", Type: "string", Auditor: []string{"hash"}},
+		{Name: "PreviousHash", Type: "string", Auditor: []string{"previoushash"}},
+	}})
+	assert.Nil(t, err)
+	sequence, _ := blockType.FieldByName("Sequence")
+
+	request, err := newTestRequest(http.MethodGet, "http://example.com/?sort=42", nil)
+	assert.Nil(t, err)
+	lastBlock := newBlock(blockType)
+	getLastBlock(request, lastBlock)
+	assert.Equal(t, int64(42), model.GetFieldValue(lastBlock, sequence))
+
+	// records created by auditor use nanoseconds since epoch
+	record := newRecord(blockType, "erasure", "subject abc erased")
+	assert.True(t, model.GetFieldValue(record, sequence).(int64) > 0)
+}
+
+func TestChains(t *testing.T) {
+	blockType, err := schema.Build(&schema.Schema{Fields: []schema.Field{
+		{Name: "Timestamp", Type: "time", Auditor: []string{"sort"}, Validate: "nonzero"},
+		{Name: "User", Type: "string", Validate: "nonzero"},
+		{Name: "Action", Type: "string"},
+		{Name: "Hash", Type: "string", Auditor: []string{"hash"}},
+		{Name: "PreviousHash", Type: "string", Auditor: []string{"previoushash"}},
+	}})
+	assert.Nil(t, err)
+	defaultStore := newMockStore()
+	userActions := &mockRecordStore{}
+	router := registerHandlers(defaultStore, &Config{Chains: map[string]*Chain{"user_actions": {Store: userActions, BlockType: blockType}}})
+
+	timestamp := time.Now().Format(time.RFC3339Nano)
+	input := bytes.NewBufferString(fmt.Sprintf(`{"Timestamp": "%v", "User": "john", "Action": "login"}`, timestamp))
+	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit/user_actions", input)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, userActions.records, 1)
+	assert.Len(t, defaultStore.(*mockStore).audit, 0)
+
+	// chain has its own validation
+	input = bytes.NewBufferString(fmt.Sprintf(`{"Timestamp": "%v", "Event": "login"}`, timestamp))
+	req, _ = newTestRequest(http.MethodPost, "http://example.com/audit/user_actions", input)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// default chain is not affected
+	req, _ = newTestRequest(http.MethodPost, "http://example.com/audit", newJSONInput())
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, userActions.records, 1)
+	assert.Len(t, defaultStore.(*mockStore).audit, 1)
+
+	req, _ = newTestRequest(http.MethodGet, "http://example.com/audit/user_actions", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	result := []map[string]interface{}{}
+	err = json.Unmarshal(w.Body.Bytes(), &result)
+	assert.Nil(t, err)
+	assert.Len(t, result, 1)
+	assert.Equal(t, "john", result[0]["User"])
+
+	// redaction is routed to chain store
+	input = bytes.NewBufferString(`{"Fields": ["User"], "Reason": "GDPR request"}`)
+	req, _ = newTestRequest(http.MethodPost, "http://example.com/audit/user_actions/abc/redact", input)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	// checkpoints are not created for chains
+	req, _ = newTestRequest(http.MethodGet, "http://example.com/audit/user_actions/abc/proof", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"checkpoints are not enabled"}`, strings.TrimSpace(w.Body.String()))
+
+	req, _ = newTestRequest(http.MethodGet, "http://example.com/audit/unknown", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func newClockBlockType(t *testing.T) reflect.Type {
+	blockType, err := schema.Build(&schema.Schema{Fields: []schema.Field{
+		{Name: "Timestamp", Type: "time", Auditor: []string{"sort"}, Validate: "nonzero"},
+		{Name: "ClientTimestamp", Type: "time", Auditor: []string{"clienttime"}},
+		{Name: "Skewed", Type: "bool", Auditor: []string{"skewed"}},
+		{Name: "Event", Type: "string"},
+		{Name: "Hash", Type: "string", Auditor: []string{"hash"}},
+		{Name: "PreviousHash", Type: "string", Auditor: []string{"previoushash"}},
+	}})
+	assert.Nil(t, err)
+	return blockType
+}
+
+func postBlock(router http.Handler, body string) *httptest.ResponseRecorder {
+	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuditServerTimestamps(t *testing.T) {
+	ms := &mockSequencerStore{}
+	router := registerHandlers(ms, &Config{BlockType: newClockBlockType(t), Timestamps: &Timestamps{Mode: ServerTimestamps}})
+
+	w := postBlock(router, `{"Timestamp": "2019-01-01T12:00:00Z", "Event": "skewed client"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	// sort field can be omitted
+	w = postBlock(router, `{"Event": "no client time"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Len(t, ms.records, 2)
+	first := reflect.ValueOf(ms.records[0])
+	assert.WithinDuration(t, time.Now(), *first.FieldByName("Timestamp").Interface().(*time.Time), time.Minute)
+	assert.Equal(t, time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC), *first.FieldByName("ClientTimestamp").Interface().(*time.Time))
+	second := reflect.ValueOf(ms.records[1])
+	assert.NotNil(t, second.FieldByName("Timestamp").Interface())
+	assert.True(t, second.FieldByName("ClientTimestamp").IsNil())
+
+	// head saved by instance with clock ahead of this one
+	ahead := time.Now().Add(time.Hour)
+	head := reflect.New(reflect.TypeOf(ms.records[0]))
+	head.Elem().FieldByName("Timestamp").Set(reflect.ValueOf(&ahead))
+	ms.Save(head.Interface())
+	w = postBlock(router, `{"Event": "after head"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ahead.UTC().Truncate(time.Millisecond).Add(time.Millisecond), *reflect.ValueOf(ms.records[3]).FieldByName("Timestamp").Interface().(*time.Time))
+}
+
+func TestSequence(t *testing.T) {
+	for kind, head := range map[string]interface{}{"int64": int64(1) << 62, "string": "2119-01-01T12:00:00Z"} {
+		blockType, err := schema.Build(&schema.Schema{Fields: []schema.Field{
+			{Name: "Sort", Type: kind, Auditor: []string{"sort"}},
+			{Name: "Hash", Type: "string", Auditor: []string{"hash"}},
+			{Name: "PreviousHash", Type: "string", Auditor: []string{"previoushash"}},
+		}})
+		assert.Nil(t, err)
+		s := model.SchemaOf(blockType)
+		block, previous := newBlock(blockType), newBlock(blockType)
+		s.SetSort(previous, head)
+		sequence(block, previous)
+		assert.True(t, s.SortBefore(previous, block), kind)
+	}
+
+	// server times are in UTC at millisecond precision, the way MongoDB stores them
+	future := time.Date(2119, 1, 1, 12, 0, 0, 500, time.UTC)
+	blockType := reflect.TypeOf(model.Block{})
+	block, previous := newBlock(blockType), newBlock(blockType)
+	model.SchemaOf(blockType).SetSort(previous, &future)
+	sequence(block, previous)
+	assert.Equal(t, future.Truncate(time.Millisecond).Add(time.Millisecond), *block.(*model.Block).Timestamp)
+	sequence(block, nil)
+	timestamp := *block.(*model.Block).Timestamp
+	assert.Equal(t, time.UTC, timestamp.Location())
+	assert.Equal(t, timestamp.Truncate(time.Millisecond), timestamp)
+}
+
+func TestSequenceInt64(t *testing.T) {
+	blockType, err := schema.Build(&schema.Schema{Fields: []schema.Field{
+		{Name: "Sequence", Type: "int64", Auditor: []string{"sort"}},
+		{Name: "Event", Type: "string"},
+		{Name: "Hash", Type: "string", Auditor: []string{"hash"}},
+		{Name: "PreviousHash", Type: "string", Auditor: []string{"previoushash"}},
+	}})
+	assert.Nil(t, err)
+	ms := &mockRecordStore{}
+	// records created by server follow sequence numbers chosen by clients
+	for _, event := range []string{"first", "second"} {
+		record := newRecord(blockType, "erasure", event)
+		assert.Nil(t, saveSequenced(ms, record))
+	}
+	head := newBlock(blockType)
+	reflect.ValueOf(head).Elem().FieldByName("Sequence").SetInt(41)
+	ms.Save(head)
+	assert.Nil(t, saveSequenced(ms, newRecord(blockType, "erasure", "third")))
+
+	sequences := []int64{}
+	for _, record := range ms.records {
+		sequences = append(sequences, reflect.ValueOf(record).FieldByName("Sequence").Int())
+	}
+	assert.Equal(t, []int64{1, 2, 41, 42}, sequences)
+}
+
+func TestAuditRejectSkewedTimestamps(t *testing.T) {
+	ms := &mockRecordStore{}
+	router := registerHandlers(ms, &Config{BlockType: newClockBlockType(t), Timestamps: &Timestamps{Mode: RejectSkewed}})
+
+	now := time.Now()
+	w := postBlock(router, fmt.Sprintf(`{"Timestamp": "%v", "Event": "on time"}`, now.Format(time.RFC3339Nano)))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = postBlock(router, fmt.Sprintf(`{"Timestamp": "%v", "Event": "before head"}`, now.Add(-time.Minute).Format(time.RFC3339Nano)))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"Unprocessable Entity","Violations":[{"Field":"Timestamp","Rule":"head","Message":"must not be older than the head of the chain"}]}`, strings.TrimSpace(w.Body.String()))
+
+	assert.Len(t, ms.records, 1)
+}
+
+func TestAuditFlagSkewedTimestamps(t *testing.T) {
+	ms := &mockRecordStore{}
+	router := registerHandlers(ms, &Config{BlockType: newClockBlockType(t), Timestamps: &Timestamps{Mode: FlagSkewed}})
+
+	now := time.Now()
+	// flag sent by client is ignored
+	w := postBlock(router, fmt.Sprintf(`{"Timestamp": "%v", "Event": "on time", "Skewed": true}`, now.Format(time.RFC3339Nano)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = postBlock(router, fmt.Sprintf(`{"Timestamp": "%v", "Event": "too old"}`, now.Add(-time.Hour).Format(time.RFC3339Nano)))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Len(t, ms.records, 2)
+	assert.False(t, reflect.ValueOf(ms.records[0]).FieldByName("Skewed").Bool())
+	assert.True(t, reflect.ValueOf(ms.records[1]).FieldByName("Skewed").Bool())
+}
+
+func TestTimestampsFromEnv(t *testing.T) {
+	defer os.Setenv("AUDITOR_TIMESTAMPS", "")
+
+	os.Setenv("AUDITOR_TIMESTAMPS", "")
+	timestamps, err := TimestampsFromEnv()
+	assert.Nil(t, err)
+	assert.Nil(t, timestamps)
+
+	os.Setenv("AUDITOR_TIMESTAMPS", "reject")
+	timestamps, err = TimestampsFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, &Timestamps{Mode: RejectSkewed}, timestamps)
+
+	os.Setenv("AUDITOR_TIMESTAMPS", "local")
+	_, err = TimestampsFromEnv()
+	assert.Equal(t, "invalid AUDITOR_TIMESTAMPS: local", err.Error())
+}
+
+func TestTimestampsCheck(t *testing.T) {
+	var timestamps *Timestamps
+	assert.Nil(t, timestamps.Check(defaultBlockType))
+	assert.Nil(t, (&Timestamps{Mode: FlagSkewed}).Check(newClockBlockType(t)))
+	err := (&Timestamps{Mode: FlagSkewed}).Check(defaultBlockType)
+	assert.Equal(t, "block type model.Block must have a field tagged with 'skewed' when timestamps are flagged", err.Error())
+}
+
+func TestAuditCloudEvents(t *testing.T) {
+	ms := &mockRecordStore{}
+	router := registerHandlers(ms, &Config{BlockType: reflect.TypeOf(model.Event{})})
+
+	// structured mode
+	input := bytes.NewBufferString(`{"specversion": "1.0", "id": "1", "source": "/services/auth", "type": "com.example.user.login", "time": "2019-01-01T12:00:00Z", "correlationid": "req-42",
+	  "data": {"actor": {"id": "user-7", "ip": "10.0.0.1"}, "action": "login", "outcome": "success"}}`)
+	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit", input)
+	req.Header.Set("Content-Type", "application/cloudevents+json; charset=utf-8")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// binary mode
+	req, _ = newTestRequest(http.MethodPost, "http://example.com/audit", bytes.NewBufferString(`{"actor": {"id": "user-7"}, "action": "delete", "resource": {"type": "document"}, "outcome": "failure", "reason": "forbidden"}`))
+	for name, value := range map[string]string{"ce-specversion": "1.0", "ce-id": "2", "ce-source": "/services/docs", "ce-type": "com.example.document.delete", "ce-subject": "doc-9", "ce-time": "2019-01-01T12:01:00Z", "Content-Type": "application/json"} {
+		req.Header.Set(name, value)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Len(t, ms.records, 2)
+	first := ms.records[0].(model.Event)
+	assert.Equal(t, "user-7", first.ActorID)
+	assert.Equal(t, "10.0.0.1", first.ActorIP)
+	assert.Equal(t, "req-42", first.CorrelationID)
+	second := ms.records[1].(model.Event)
+	assert.Equal(t, "doc-9", second.ResourceID)
+	assert.Equal(t, "forbidden", second.Reason)
+
+	// invalid event
+	input = bytes.NewBufferString(`{"specversion": "1.0", "id": "3", "type": "com.example.user.login", "time": "2019-01-01T12:00:00Z"}`)
+	req, _ = newTestRequest(http.MethodPost, "http://example.com/audit", input)
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"CloudEvent must have source attribute"}`, strings.TrimSpace(w.Body.String()))
+
+	req, _ = newTestRequest(http.MethodGet, "http://example.com/audit", nil)
+	req.Header.Set("Accept", "application/cloudevents-batch+json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/cloudevents-batch+json", w.Header().Get("Content-Type"))
+	events := []model.CloudEvent{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &events))
+	assert.Len(t, events, 2)
+	assert.Equal(t, "2", events[0].ID)
+	assert.Equal(t, "com.example.document.delete", events[0].Type)
+	assert.Equal(t, second.Hash, events[0].Hash)
+	assert.Equal(t, first.Hash, events[0].PreviousHash)
+}
+
+func TestAuditCloudEventsNotSupported(t *testing.T) {
+	handler := makeHandler(auditHandler, newMockStore(), defaultBlockType)
+
+	req, _ := newTestRequest(http.MethodPost, "http://example.com/audit", bytes.NewBufferString(`{"specversion": "1.0"}`))
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	w := httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"block type does not support CloudEvents"}`, strings.TrimSpace(w.Body.String()))
+
+	req, _ = newTestRequest(http.MethodPost, "http://example.com/audit", newJSONInput())
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = newTestRequest(http.MethodGet, "http://example.com/audit", nil)
+	req.Header.Set("Accept", "application/cloudevents-batch+json")
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+}
+
+func TestNewRecordEvent(t *testing.T) {
+	record := newRecord(reflect.TypeOf(model.Event{}), "erasure", "subject abc erased").(*model.Event)
+	assert.Equal(t, "erasure", record.Action)
+	assert.Equal(t, "subject abc erased", record.Reason)
+	assert.NotNil(t, record.Timestamp)
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store"
+	"github.com/lukaszbudnik/auditor/validation"
+)
+
+// TimestampMode controls how sort field of posted blocks is set
+type TimestampMode string
+
+const (
+	// ClientTimestamps keeps sort value sent by client, this is the default
+	ClientTimestamps TimestampMode = "client"
+	// ServerTimestamps sets sort field to server time, value sent by client is kept in field tagged with clienttime,
+	// when store implements store.Sequencer sort value is assigned after the chain is locked and is never before the head
+	ServerTimestamps TimestampMode = "server"
+	// RejectSkewed keeps sort value sent by client but rejects blocks older than the head of the chain
+	RejectSkewed TimestampMode = "reject"
+	// FlagSkewed keeps sort value sent by client and saves blocks older than the head of the chain
+	// with field tagged with skewed set to true
+	FlagSkewed TimestampMode = "flag"
+)
+
+// Timestamps configures how server treats sort values sent by clients,
+// window around server time is checked by maxSkew validation rule
+type Timestamps struct {
+	Mode TimestampMode
+}
+
+// TimestampsFromEnv creates Timestamps from AUDITOR_TIMESTAMPS, returns nil when mode is not set
+func TimestampsFromEnv() (*Timestamps, error) {
+	mode := TimestampMode(os.Getenv("AUDITOR_TIMESTAMPS"))
+	if len(mode) == 0 {
+		return nil, nil
+	}
+	if mode != ClientTimestamps && mode != ServerTimestamps && mode != RejectSkewed && mode != FlagSkewed {
+		return nil, fmt.Errorf("invalid AUDITOR_TIMESTAMPS: %v", mode)
+	}
+	return &Timestamps{Mode: mode}, nil
+}
+
+// Check returns error if block type does not support the mode
+func (t *Timestamps) Check(blockType reflect.Type) error {
+	if t == nil {
+		return nil
+	}
+	schema := model.SchemaOf(blockType)
+	if t.Mode == FlagSkewed && !schema.HasSkewed() {
+		return fmt.Errorf("block type %v must have a field tagged with 'skewed' when timestamps are flagged", blockType)
+	}
+	return nil
+}
+
+// stamp sets sort field to server time in ServerTimestamps mode, it is called before block is validated
+// so that clients can omit sort field
+func (t *Timestamps) stamp(block interface{}) {
+	if t == nil || t.Mode != ServerTimestamps {
+		return
+	}
+	schema := model.SchemaFor(block)
+	schema.KeepClientTime(block)
+	schema.SetSort(block, sortNow(schema.Type))
+}
+
+// save saves block, in ServerTimestamps mode its sort value is assigned again by saveSequenced
+func (t *Timestamps) save(auditStore store.Store, block interface{}) error {
+	if t != nil && t.Mode == ServerTimestamps {
+		return saveSequenced(auditStore, block)
+	}
+	return auditStore.Save(block)
+}
+
+// saveSequenced saves block with sort value assigned by sequence, it is used for blocks posted in ServerTimestamps mode
+// and for blocks created by server, when store does not implement store.Sequencer head is read before the chain is locked
+func saveSequenced(auditStore store.Store, block interface{}) error {
+	if sequencer, ok := auditStore.(store.Sequencer); ok {
+		return sequencer.SaveSequenced(block, sequence)
+	}
+	schema := model.SchemaFor(block)
+	heads := newBlocks(schema.Type)
+	last := newBlock(schema.Type)
+	// DynamoDB reads blocks from a single partition
+	if schema.HasPartition() {
+		schema.SetPartition(last, schema.Partition(block))
+	}
+	if err := auditStore.Read(heads, 1, last); err != nil {
+		return err
+	}
+	var head interface{}
+	if blocks := reflect.ValueOf(heads).Elem(); blocks.Len() > 0 {
+		head = blocks.Index(0).Addr().Interface()
+	}
+	sequence(block, head)
+	return auditStore.Save(block)
+}
+
+// sequence sets sort field to server time, or right after sort value of head when clock of this instance is behind it,
+// int64 sort fields may hold sequence numbers chosen by clients so they are always set right after head (1 in empty chain)
+func sequence(block, head interface{}) {
+	schema := model.SchemaFor(block)
+	if schema.SortField().Type.Kind() == reflect.Int64 {
+		var next int64 = 1
+		if head != nil && schema.HasSort(head) {
+			next = sortAfter(schema, head).(int64)
+		}
+		schema.SetSort(block, next)
+		return
+	}
+	schema.SetSort(block, sortNow(schema.Type))
+	if head != nil && schema.HasSort(head) && !schema.SortBefore(head, block) {
+		schema.SetSort(block, sortAfter(schema, head))
+	}
+}
+
+// sortAfter returns the smallest sort value after sort value of head, strings are compared lexicographically
+// and RFC3339Nano timestamps omit trailing zeros, so for them the next whole second is used,
+// times are kept at millisecond precision by MongoDB, so for them the next millisecond is used
+func sortAfter(schema *model.BlockSchema, head interface{}) interface{} {
+	sort, _ := schema.SortTime(head)
+	switch schema.SortField().Type.Kind() {
+	case reflect.Int64:
+		return schema.Sort(head).(int64) + 1
+	case reflect.String:
+		return sort.UTC().Truncate(time.Second).Add(time.Second).Format(time.RFC3339Nano)
+	}
+	next := sort.UTC().Truncate(time.Millisecond).Add(time.Millisecond)
+	return &next
+}
+
+// skew returns violation when sort value sent by client is older than the head of the chain,
+// head is read from store, blocks posted concurrently are not checked against each other
+func (t *Timestamps) skew(block interface{}, store store.Store) ([]validation.Violation, error) {
+	if t == nil || t.Mode != RejectSkewed && t.Mode != FlagSkewed {
+		return nil, nil
+	}
+	schema := model.SchemaFor(block)
+	violations := []validation.Violation{}
+
+	head := newBlocks(schema.Type)
+	last := newBlock(schema.Type)
+	// DynamoDB reads blocks from a single partition
+	if schema.HasPartition() {
+		schema.SetPartition(last, schema.Partition(block))
+	}
+	if err := store.Read(head, 1, last); err != nil {
+		return nil, err
+	}
+	blocks := reflect.ValueOf(head).Elem()
+	if blocks.Len() == 0 || !schema.HasSort(block) || !schema.HasSort(blocks.Index(0).Addr().Interface()) {
+		return violations, nil
+	}
+	if schema.SortBefore(block, blocks.Index(0).Addr().Interface()) {
+		violations = append(violations, validation.Violation{Field: schema.SortField().Name, Rule: "head", Message: "must not be older than the head of the chain"})
+	}
+	return violations, nil
+}
@@ -1,6 +1,8 @@
 package dynamodb
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -20,15 +22,38 @@ import (
 	"github.com/lukaszbudnik/auditor/store"
 )
 
+func init() {
+	model.RegisterRules("dynamodb", partitionRule)
+}
+
+// partitionRule checks field used as partition key, Read queries it as a string attribute,
+// key and hash fields must not be tagged with dynamodbav:"-"
+func partitionRule(t reflect.Type) []error {
+	errors := model.ExactlyOne(t, "dynamodb_partition")
+	errors = append(errors, model.OfType(t, "dynamodb_partition", reflect.TypeOf(""))...)
+	errors = append(errors, model.Exported(t, "dynamodb_partition")...)
+	for _, tag := range []string{"dynamodb_partition", "sort", "hash", "idempotencykey", "fingerprint"} {
+		errors = append(errors, model.Stored(t, tag, model.DynamoDBName)...)
+	}
+	return errors
+}
+
 type dynamoDB struct {
-	client *dynamodb.DynamoDB
-	redis  *redis.Client
-	lock   *sync.Mutex
-	lock1  *lock.Locker
-	lock2  *lock.Locker
+	client          *dynamodb.DynamoDB
+	redis           *redis.Client
+	lock            *sync.Mutex
+	lock1           *lock.Locker
+	lock2           *lock.Locker
+	table           string
+	previousHashKey string
 }
 
 func (d *dynamoDB) Save(block interface{}) error {
+	return d.SaveSequenced(block, nil)
+}
+
+// SaveSequenced looks up head block by previous hash, when sequence is nil it is not called
+func (d *dynamoDB) SaveSequenced(block interface{}, sequence func(block, head interface{})) error {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 	_, err := d.lock1.Lock()
@@ -44,30 +69,37 @@ func (d *dynamoDB) Save(block interface{}) error {
 	}
 	defer d.lock2.Unlock()
 
-	previousHash, err := d.redis.Get("auditor.previoushash").Result()
+	previousHash, err := d.redis.Get(d.previousHashKey).Result()
 	if err != nil && err != redis.Nil {
 		log.Printf("ERROR Could not get previoushash key from Redis: %v", err.Error())
 		return err
 	}
 
+	schema := model.SchemaFor(block)
+	if len(previousHash) == 0 {
+		// head item holds hash of the most recent block in all partitions
+		head, err := d.getItem(markerKey(schema, headMarker))
+		if err != nil && err != store.ErrBlockNotFound {
+			return err
+		}
+		if head != nil {
+			previousHash = aws.StringValue(head[markerHashKey].S)
+		}
+	}
 	if len(previousHash) > 0 {
-		previousHashField := model.GetFieldsTaggedWith(block, "previoushash")
-		model.SetFieldValue(block, previousHashField[0], previousHash)
+		schema.SetPreviousHash(block, previousHash)
 	} else {
-		// get type
-		t := reflect.ValueOf(block).Elem().Type()
+		// tables written before head item was introduced, the most recent block of the partition is used
 		// create *[]type
-		ts := reflect.SliceOf(t)
+		ts := reflect.SliceOf(schema.Type)
 		ptr := reflect.New(ts)
 		ptr.Elem().Set(reflect.MakeSlice(ts, 0, 1))
 
 		// for dynamodb last block must not be empty
 		// and most field tagged with dynamodb_partiion populated
 		// below we are copying it from the block
-		lastv := reflect.New(t)
-		fields := model.GetTypeFieldsTaggedWith(t, "dynamodb_partition")
-		value := model.GetFieldValue(block, fields[0])
-		model.SetFieldValue(lastv.Interface(), fields[0], value)
+		lastv := reflect.New(schema.Type)
+		schema.SetPartition(lastv.Interface(), schema.Partition(block))
 
 		d.Read(ptr.Interface(), 1, lastv.Interface())
 		if ptr.Elem().Len() > 0 {
@@ -75,32 +107,127 @@ func (d *dynamoDB) Save(block interface{}) error {
 		}
 	}
 
+	if sequence != nil {
+		var head interface{}
+		if previousHash := schema.PreviousHash(block); len(previousHash) > 0 {
+			head = reflect.New(schema.Type).Interface()
+			schema.SetHash(head, previousHash)
+			schema.SetPartition(head, schema.Partition(block))
+			if err := d.findByHash(head); err != nil {
+				return err
+			}
+		}
+		sequence(block, head)
+	}
+
 	currentHash, err := model.ComputeAndSetHash(block)
 
 	if err != nil {
 		return err
 	}
 
-	av, err := dynamodbattribute.MarshalMap(block)
+	av, err := marshalBlock(block)
 	if err != nil {
 		return err
 	}
 
-	putInput := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String("audit"),
+	err = d.put(schema, block, av)
+	if err == errHeadChanged {
+		// cached previoushash is stale, next block will be linked to the head item
+		d.redis.Del(d.previousHashKey)
 	}
 
-	_, err = d.client.PutItem(putInput)
-
 	if err == nil {
 		// current hash becomes previoushash
-		d.redis.Set("auditor.previoushash", currentHash, time.Second)
+		d.redis.Set(d.previousHashKey, currentHash, time.Second)
 	}
 
 	return err
 }
 
+const (
+	// markerBlockKey is an attribute of marker item which holds key of the block
+	markerBlockKey = "block"
+	// markerHashKey is an attribute of head item which holds hash of the most recent block
+	markerHashKey = "hash"
+	// headMarker is a partition of head item
+	headMarker = "head#"
+)
+
+var errHeadChanged = errors.New("head of the chain was changed concurrently, block was not saved")
+
+// hashMarker returns partition of item which holds key of the block with a given hash
+func hashMarker(hash string) string {
+	return "hash#" + hash
+}
+
+// idempotencyMarker returns partition of item which holds key of the block saved with a given idempotency key
+func idempotencyMarker(partition interface{}, key string) string {
+	return fmt.Sprintf("idempotency#%v#%v", partition, key)
+}
+
+// markerKey returns key of marker item, marker uses sort attribute of the same type as blocks as table key schema is shared
+func markerKey(schema *model.BlockSchema, marker string) map[string]*dynamodb.AttributeValue {
+	sort := &dynamodb.AttributeValue{S: aws.String("marker")}
+	if schema.SortField().Type.Kind() == reflect.Int64 {
+		sort = &dynamodb.AttributeValue{N: aws.String("0")}
+	}
+	return map[string]*dynamodb.AttributeValue{
+		model.DynamoDBName(schema.PartitionField()): {S: aws.String(marker)},
+		model.DynamoDBName(schema.SortField()):      sort,
+	}
+}
+
+// put puts item in one transaction together with marker items which hold its key: hash marker, head item, and idempotency
+// marker when block has idempotency key, markers let blocks be found without knowing their partitions,
+// head item is replaced only when it still points to block's previous hash so that the chain cannot fork
+func (d *dynamoDB) put(schema *model.BlockSchema, block interface{}, av map[string]*dynamodb.AttributeValue) error {
+	partitionName, sortName := model.DynamoDBName(schema.PartitionField()), model.DynamoDBName(schema.SortField())
+	blockKey := &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{
+		partitionName: av[partitionName],
+		sortName:      av[sortName],
+	}}
+	newMarker := func(marker string) *dynamodb.Put {
+		item := markerKey(schema, marker)
+		item[markerBlockKey] = blockKey
+		return &dynamodb.Put{
+			TableName:                aws.String(d.table),
+			Item:                     item,
+			ConditionExpression:      aws.String("attribute_not_exists(#partition)"),
+			ExpressionAttributeNames: map[string]*string{"#partition": aws.String(partitionName)},
+		}
+	}
+
+	hash := newMarker(hashMarker(schema.Hash(block)))
+	head := newMarker(headMarker)
+	head.Item[markerHashKey] = &dynamodb.AttributeValue{S: aws.String(schema.Hash(block))}
+	if previousHash := schema.PreviousHash(block); len(previousHash) > 0 {
+		head.ConditionExpression = aws.String("attribute_not_exists(#partition) OR #hash = :previous")
+		head.ExpressionAttributeNames["#hash"] = aws.String(markerHashKey)
+		head.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{":previous": {S: aws.String(previousHash)}}
+	}
+	items := []*dynamodb.TransactWriteItem{
+		{Put: &dynamodb.Put{TableName: aws.String(d.table), Item: av}},
+		{Put: hash},
+		{Put: head},
+	}
+	if key := schema.IdempotencyKey(block); len(key) > 0 {
+		items = append(items, &dynamodb.TransactWriteItem{Put: newMarker(idempotencyMarker(schema.Partition(block), key))})
+	}
+
+	_, err := d.client.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if canceled, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		reasons := canceled.CancellationReasons
+		if len(reasons) > 2 && aws.StringValue(reasons[2].Code) == "ConditionalCheckFailed" {
+			return errHeadChanged
+		}
+		if len(reasons) > 3 && aws.StringValue(reasons[3].Code) == "ConditionalCheckFailed" {
+			return store.ErrDuplicateKey
+		}
+	}
+	return err
+}
+
 func (d *dynamoDB) Read(result interface{}, limit int64, last interface{}) error {
 
 	if last == nil {
@@ -122,7 +249,7 @@ func (d *dynamoDB) Read(result interface{}, limit int64, last interface{}) error
 	var exclusiveStartKey map[string]*dynamodb.AttributeValue
 
 	queryInput := &dynamodb.QueryInput{
-		TableName:        aws.String("audit"),
+		TableName:        aws.String(d.table),
 		Limit:            aws.Int64(limit),
 		ScanIndexForward: aws.Bool(false),
 		ConsistentRead:   aws.Bool(true),
@@ -140,32 +267,30 @@ func (d *dynamoDB) Read(result interface{}, limit int64, last interface{}) error
 		panic("result and last arguments must be of the same type")
 	}
 
-	fields := model.GetTypeFieldsTaggedWith(lastv.Type().Elem(), "sort")
+	schema := model.SchemaOf(lastv.Type().Elem())
 
-	field := fields[0]
-	fieldi := model.GetFieldValue(last, field)
-	fieldv := reflect.ValueOf(fieldi)
-	if field.Type == reflect.TypeOf(&time.Time{}) && !fieldv.IsNil() {
-		in := []reflect.Value{reflect.ValueOf(time.RFC3339Nano)}
-		timestamp := fieldv.MethodByName("Format").Call(in)[0]
-		exclusiveStartKey = make(map[string]*dynamodb.AttributeValue)
-		exclusiveStartKey[field.Name] = &dynamodb.AttributeValue{
-			S: aws.String(fmt.Sprintf("%v", timestamp)),
+	if schema.HasSort(last) {
+		// sort value is marshalled the same way as when block is saved:
+		// time as RFC3339Nano string (S), int64 as number (N), string as string (S)
+		sort, err := dynamodbattribute.Marshal(schema.Sort(last))
+		if err != nil {
+			return err
 		}
+		exclusiveStartKey = make(map[string]*dynamodb.AttributeValue)
+		exclusiveStartKey[model.DynamoDBName(schema.SortField())] = sort
 	}
 
-	fields = model.GetTypeFieldsTaggedWith(lastv.Type().Elem(), "dynamodb_partition")
-	field = fields[0]
-	value := model.GetFieldValue(last, field)
+	partitionName := model.DynamoDBName(schema.PartitionField())
+	partition := &dynamodb.AttributeValue{
+		S: aws.String(fmt.Sprintf("%v", schema.Partition(last))),
+	}
 
-	queryInput.SetKeyConditionExpression(fmt.Sprintf("%v = :partition", field.Name))
-	queryInput.SetExpressionAttributeValues(map[string]*dynamodb.AttributeValue{":partition": {
-		S: aws.String(fmt.Sprintf("%v", value)),
-	}})
+	// attribute names can be DynamoDB reserved words thus expression attribute names are used
+	queryInput.SetKeyConditionExpression("#partition = :partition")
+	queryInput.SetExpressionAttributeNames(map[string]*string{"#partition": aws.String(partitionName)})
+	queryInput.SetExpressionAttributeValues(map[string]*dynamodb.AttributeValue{":partition": partition})
 	if exclusiveStartKey != nil {
-		exclusiveStartKey[field.Name] = &dynamodb.AttributeValue{
-			S: aws.String(fmt.Sprintf("%v", value)),
-		}
+		exclusiveStartKey[partitionName] = partition
 	}
 
 	if exclusiveStartKey != nil {
@@ -177,7 +302,312 @@ func (d *dynamoDB) Read(result interface{}, limit int64, last interface{}) error
 		return err
 	}
 
-	return dynamodbattribute.UnmarshalListOfMaps(output.Items, &result)
+	if len(schema.RawPayloadFields()) == 0 {
+		if err := dynamodbattribute.UnmarshalListOfMaps(output.Items, &result); err != nil {
+			return err
+		}
+		return model.UpcastAll(result)
+	}
+
+	slicev = reflect.MakeSlice(slicev.Type(), 0, len(output.Items))
+	for _, item := range output.Items {
+		block := reflect.New(schema.Type)
+		if err := unmarshalItem(item, block.Interface()); err != nil {
+			return err
+		}
+		slicev = reflect.Append(slicev, block.Elem())
+	}
+	resultv.Elem().Set(slicev)
+	return model.UpcastAll(result)
+}
+
+func (d *dynamoDB) Redact(block interface{}, fields []string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	schema := model.SchemaFor(block)
+	hash := schema.Hash(block)
+
+	// hash is a DynamoDB reserved word thus expression attribute names are used
+	hashName := aws.String(model.DynamoDBName(schema.HashField()))
+	hashValue := &dynamodb.AttributeValue{S: aws.String(hash)}
+
+	if err := d.findByHash(block); err != nil {
+		return err
+	}
+
+	if err := model.Redact(block, fields); err != nil {
+		return err
+	}
+	// redaction must not change hash, if it does stored block was tampered with
+	if err := model.VerifyHash(block); err != nil {
+		return err
+	}
+
+	av, err := marshalBlock(block)
+	if err != nil {
+		return err
+	}
+
+	putInput := &dynamodb.PutItemInput{
+		Item:                      av,
+		TableName:                 aws.String(d.table),
+		ConditionExpression:       aws.String("#hash = :hash"),
+		ExpressionAttributeNames:  map[string]*string{"#hash": hashName},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":hash": hashValue},
+	}
+
+	_, err = d.client.PutItem(putInput)
+	return err
+}
+
+func (d *dynamoDB) FindByHash(block interface{}) error {
+	if err := d.findByHash(block); err != nil {
+		return err
+	}
+	return model.Upcast(block)
+}
+
+// findByHash finds block in any partition using its hash marker, blocks saved before hash markers were introduced
+// are found by querying partition of block when it is set
+func (d *dynamoDB) findByHash(block interface{}) error {
+	schema := model.SchemaFor(block)
+	marker, err := d.getItem(markerKey(schema, hashMarker(schema.Hash(block))))
+	if err == store.ErrBlockNotFound && len(fmt.Sprintf("%v", schema.Partition(block))) > 0 {
+		return d.find(block, schema.HashField(), schema.Hash(block))
+	}
+	if err != nil {
+		return err
+	}
+	return d.getBlock(marker, block)
+}
+
+// Head finds the most recent block of the chain in all partitions using head item, in tables written before head item
+// was introduced the most recent block of partition of block is used when it is set
+func (d *dynamoDB) Head(block interface{}) error {
+	schema := model.SchemaFor(block)
+	head, err := d.getItem(markerKey(schema, headMarker))
+	if err == store.ErrBlockNotFound && len(fmt.Sprintf("%v", schema.Partition(block))) > 0 {
+		blocks := reflect.New(reflect.SliceOf(schema.Type))
+		if err := d.Read(blocks.Interface(), 1, block); err != nil {
+			return err
+		}
+		if blocks.Elem().Len() == 0 {
+			return store.ErrBlockNotFound
+		}
+		reflect.ValueOf(block).Elem().Set(blocks.Elem().Index(0))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := d.getBlock(head, block); err != nil {
+		return err
+	}
+	return model.Upcast(block)
+}
+
+func (d *dynamoDB) FindByIdempotencyKey(block interface{}) error {
+	schema := model.SchemaFor(block)
+	marker, err := d.getItem(markerKey(schema, idempotencyMarker(schema.Partition(block), schema.IdempotencyKey(block))))
+	if err != nil {
+		return err
+	}
+	if err := d.getBlock(marker, block); err != nil {
+		return err
+	}
+	return model.Upcast(block)
+}
+
+// getBlock populates block with item whose key is held by marker item
+func (d *dynamoDB) getBlock(marker map[string]*dynamodb.AttributeValue, block interface{}) error {
+	key := marker[markerBlockKey]
+	if key == nil || len(key.M) == 0 {
+		return store.ErrBlockNotFound
+	}
+	item, err := d.getItem(key.M)
+	if err != nil {
+		return err
+	}
+	return unmarshalItem(item, block)
+}
+
+// getItem returns item with a given key or store.ErrBlockNotFound when there is no such item
+func (d *dynamoDB) getItem(key map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	output, err := d.client.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Item == nil {
+		return nil, store.ErrBlockNotFound
+	}
+	return output.Item, nil
+}
+
+const (
+	// keyIDName is partition key of table which holds encryption keys, the table has no sort key
+	keyIDName        = "ID"
+	keyValueName     = "Key"
+	keyDestroyedName = "Destroyed"
+)
+
+func (d *dynamoDB) CreateKey(id string, key []byte) ([]byte, error) {
+	_, err := d.client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			keyIDName:    {S: aws.String(id)},
+			keyValueName: {B: key},
+		},
+		ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+		ExpressionAttributeNames: map[string]*string{"#id": aws.String(keyIDName)},
+	})
+	if _, ok := err.(*dynamodb.ConditionalCheckFailedException); ok {
+		// key was created concurrently or destroyed
+		return d.GetKey(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (d *dynamoDB) GetKey(id string) ([]byte, error) {
+	item, err := d.getItem(map[string]*dynamodb.AttributeValue{keyIDName: {S: aws.String(id)}})
+	if err == store.ErrBlockNotFound {
+		return nil, store.ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if destroyed := item[keyDestroyedName]; destroyed != nil && aws.BoolValue(destroyed.BOOL) {
+		return nil, store.ErrKeyDestroyed
+	}
+	if item[keyValueName] == nil {
+		return nil, fmt.Errorf("key %v has no value", id)
+	}
+	return item[keyValueName].B, nil
+}
+
+func (d *dynamoDB) DestroyKey(id string) error {
+	// item is replaced so that key is removed
+	_, err := d.client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			keyIDName:        {S: aws.String(id)},
+			keyDestroyedName: {BOOL: aws.Bool(true)},
+		},
+	})
+	return err
+}
+
+// find queries the whole partition, it is used to find blocks saved before hash markers were introduced
+func (d *dynamoDB) find(block interface{}, field reflect.StructField, value string) error {
+	schema := model.SchemaFor(block)
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String(d.table),
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String("#partition = :partition"),
+		FilterExpression:       aws.String("#field = :value"),
+		ExpressionAttributeNames: map[string]*string{
+			"#partition": aws.String(model.DynamoDBName(schema.PartitionField())),
+			"#field":     aws.String(model.DynamoDBName(field)),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":partition": {S: aws.String(fmt.Sprintf("%v", schema.Partition(block)))},
+			":value":     {S: aws.String(value)},
+		},
+	}
+
+	var item map[string]*dynamodb.AttributeValue
+	err := d.client.QueryPages(queryInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		if len(page.Items) > 0 {
+			item = page.Items[0]
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return store.ErrBlockNotFound
+	}
+	return unmarshalItem(item, block)
+}
+
+// marshalBlock marshals block into DynamoDB item, json.RawMessage payload fields
+// are stored as maps instead of binary data, empty objects in payloads are stored as empty maps instead of nulls
+func marshalBlock(block interface{}) (map[string]*dynamodb.AttributeValue, error) {
+	av, err := dynamodbattribute.MarshalMap(block)
+	if err != nil {
+		return nil, err
+	}
+	encoder := dynamodbattribute.NewEncoder(func(e *dynamodbattribute.Encoder) {
+		e.EnableEmptyCollections = true
+	})
+	for _, field := range model.SchemaFor(block).FieldsTaggedWith("payload") {
+		payload := model.GetFieldValue(block, field)
+		if raw, ok := payload.(json.RawMessage); ok {
+			if payload, err = model.DecodePayload(raw); err != nil {
+				return nil, fmt.Errorf("field %v is not valid JSON: %v", field.Name, err.Error())
+			}
+		}
+		if av[model.DynamoDBName(field)], err = encoder.Encode(payload); err != nil {
+			return nil, err
+		}
+	}
+	return av, nil
+}
+
+// unmarshalItem populates block from DynamoDB item, payload maps are encoded back to JSON,
+// numbers in payloads are decoded the way model.DecodeNumbers does
+func unmarshalItem(item map[string]*dynamodb.AttributeValue, block interface{}) error {
+	fields := model.SchemaFor(block).FieldsTaggedWith("payload")
+	if len(fields) == 0 {
+		return dynamodbattribute.UnmarshalMap(item, block)
+	}
+	decoder := dynamodbattribute.NewDecoder(func(d *dynamodbattribute.Decoder) {
+		d.UseNumber = true
+	})
+	rest := make(map[string]*dynamodb.AttributeValue, len(item))
+	for name, value := range item {
+		rest[name] = value
+	}
+	payloads := make(map[string]interface{})
+	for _, field := range fields {
+		name := model.DynamoDBName(field)
+		var value interface{}
+		if av, ok := rest[name]; ok {
+			if err := decoder.Decode(av, &value); err != nil {
+				return err
+			}
+		}
+		value, err := model.DecodeNumbers(value)
+		if err != nil {
+			return err
+		}
+		if field.Type == reflect.TypeOf(json.RawMessage{}) {
+			if value, err = model.EncodePayload(value); err != nil {
+				return err
+			}
+		} else if value == nil {
+			value = reflect.Zero(field.Type).Interface()
+		}
+		payloads[field.Name] = value
+		delete(rest, name)
+	}
+	if err := dynamodbattribute.UnmarshalMap(rest, block); err != nil {
+		return err
+	}
+	for name, payload := range payloads {
+		field, _ := reflect.TypeOf(block).Elem().FieldByName(name)
+		model.SetFieldValue(block, field, payload)
+	}
+	return nil
 }
 
 func (d *dynamoDB) Close() {
@@ -189,8 +619,13 @@ func (d *dynamoDB) Close() {
 	}
 }
 
-// New creates Store implementation for DynamoDB
+// New creates Store implementation for DynamoDB which uses default audit table
 func New() (store.Store, error) {
+	return NewWithName(store.DefaultName)
+}
+
+// NewWithName creates Store implementation for DynamoDB which uses table with a given name
+func NewWithName(name string) (store.Store, error) {
 	client, err := newClient()
 	if err != nil {
 		return nil, err
@@ -208,16 +643,16 @@ func New() (store.Store, error) {
 		Addr:    redisEndpoint,
 	})
 
-	lock1 := lock.New(redis, "auditor.lock1", &lock.Options{
+	lock1 := lock.New(redis, store.RedisKey(name, "lock1"), &lock.Options{
 		RetryCount:  10,
 		TokenPrefix: token,
 	})
-	lock2 := lock.New(redis, "auditor.lock2", &lock.Options{
+	lock2 := lock.New(redis, store.RedisKey(name, "lock2"), &lock.Options{
 		RetryCount:  10,
 		TokenPrefix: token,
 	})
 
-	dynamoDB := &dynamoDB{client: client, redis: redis, lock: &sync.Mutex{}, lock1: lock1, lock2: lock2}
+	dynamoDB := &dynamoDB{client: client, redis: redis, lock: &sync.Mutex{}, lock1: lock1, lock2: lock2, table: name, previousHashKey: store.RedisKey(name, "previoushash")}
 	return dynamoDB, nil
 }
 
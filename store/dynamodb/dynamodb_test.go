@@ -1,14 +1,19 @@
 package dynamodb
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/joho/godotenv"
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -38,45 +43,67 @@ func TestMain(m *testing.M) {
 	os.Exit(result)
 }
 
+// tables used by tests and types of their sort keys
+var tables = map[string]string{"audit": "S", "sequence": "N", "walk": "S"}
+
+// keysTable is used by tests of subject keys
+const keysTable = "subjects"
+
 func setup() error {
 	client, err := newClient()
 	if err != nil {
 		return err
 	}
 
-	createTableInput := &dynamodb.CreateTableInput{
-		AttributeDefinitions: []*dynamodb.AttributeDefinition{
-			{
-				AttributeName: aws.String("Customer"),
-				AttributeType: aws.String("S"),
-			},
-			{
-				AttributeName: aws.String("Timestamp"),
-				AttributeType: aws.String("S"),
+	for table, sortType := range tables {
+		sortKey := "Timestamp"
+		if sortType == "N" {
+			sortKey = "Sequence"
+		}
+		createTableInput := &dynamodb.CreateTableInput{
+			AttributeDefinitions: []*dynamodb.AttributeDefinition{
+				{
+					AttributeName: aws.String("Customer"),
+					AttributeType: aws.String("S"),
+				},
+				{
+					AttributeName: aws.String(sortKey),
+					AttributeType: aws.String(sortType),
+				},
 			},
-		},
-		KeySchema: []*dynamodb.KeySchemaElement{
-			{
-				AttributeName: aws.String("Customer"),
-				KeyType:       aws.String("HASH"),
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{
+					AttributeName: aws.String("Customer"),
+					KeyType:       aws.String("HASH"),
+				},
+				{
+					AttributeName: aws.String(sortKey),
+					KeyType:       aws.String("RANGE"),
+				},
 			},
-			{
-				AttributeName: aws.String("Timestamp"),
-				KeyType:       aws.String("RANGE"),
+			ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+				ReadCapacityUnits:  aws.Int64(10),
+				WriteCapacityUnits: aws.Int64(10),
 			},
-		},
+			TableName: aws.String(table),
+		}
+
+		_, err = client.CreateTable(createTableInput)
+		if err != nil {
+			log.Fatalf("Got error calling CreateTable: %v", err.Error())
+		}
+	}
+
+	// table which holds subject keys has only partition key
+	_, err = client.CreateTable(&dynamodb.CreateTableInput{
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{{AttributeName: aws.String(keyIDName), AttributeType: aws.String("S")}},
+		KeySchema:            []*dynamodb.KeySchemaElement{{AttributeName: aws.String(keyIDName), KeyType: aws.String("HASH")}},
 		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
 			ReadCapacityUnits:  aws.Int64(10),
 			WriteCapacityUnits: aws.Int64(10),
 		},
-		TableName: aws.String("audit"),
-	}
-
-	_, err = client.CreateTable(createTableInput)
-	if err != nil {
-		log.Fatalf("Got error calling CreateTable: %v", err.Error())
-	}
-
+		TableName: aws.String(keysTable),
+	})
 	return err
 }
 
@@ -93,25 +120,19 @@ func tearDown() error {
 		return err
 	}
 
-	found := false
 	for _, tableName := range listTableOutput.TableNames {
-		if *tableName == "audit" {
-			found = true
-			break
+		if _, ok := tables[*tableName]; !ok && *tableName != keysTable {
+			continue
+		}
+		deleteTableInput := &dynamodb.DeleteTableInput{
+			TableName: tableName,
+		}
+		if _, err = client.DeleteTable(deleteTableInput); err != nil {
+			return err
 		}
 	}
 
-	if !found {
-		return nil
-	}
-
-	deleteTableInput := &dynamodb.DeleteTableInput{
-		TableName: aws.String("audit"),
-	}
-
-	_, err = client.DeleteTable(deleteTableInput)
-
-	return err
+	return nil
 }
 
 func TestDynamoDB(t *testing.T) {
@@ -144,3 +165,281 @@ func TestDynamoDB(t *testing.T) {
 	assert.Subset(t, all, page1)
 	assert.Subset(t, all, page2)
 }
+
+func TestDynamoDBWalk(t *testing.T) {
+	s, err := NewWithName("walk")
+	assert.Nil(t, err)
+	defer s.Close()
+	walker := s.(store.Walker)
+
+	time1 := time.Now().Truncate(time.Nanosecond)
+	first := &testBlock{Customer: "abc", Timestamp: &time1, Event: "first"}
+	assert.Nil(t, s.Save(first))
+	time2 := time1.Add(time.Second)
+	second := &testBlock{Customer: "def", Timestamp: &time2, Event: "second"}
+	assert.Nil(t, s.Save(second))
+	// blocks of different partitions are linked
+	assert.Equal(t, first.Hash, second.PreviousHash)
+
+	// when cached previoushash expires block is linked to the head of the chain, not to the last block of its partition
+	time.Sleep(1500 * time.Millisecond)
+	time3 := time2.Add(time.Second)
+	third := &testBlock{Customer: "abc", Timestamp: &time3, Event: "third"}
+	assert.Nil(t, s.Save(third))
+	assert.Equal(t, second.Hash, third.PreviousHash)
+
+	head := &testBlock{}
+	assert.Nil(t, walker.Head(head))
+	assert.Equal(t, third.Hash, head.Hash)
+
+	// blocks are found by hash in all partitions
+	previous := &testBlock{Hash: head.PreviousHash}
+	assert.Nil(t, walker.FindByHash(previous))
+	assert.Equal(t, "def", previous.Customer)
+	assert.Equal(t, store.ErrBlockNotFound, walker.FindByHash(&testBlock{Hash: "unknown"}))
+
+	// marker items are not read as blocks
+	all := []testBlock{}
+	assert.Nil(t, s.Read(&all, 10, &testBlock{Customer: "abc"}))
+	assert.Len(t, all, 2)
+}
+
+type redactableBlock struct {
+	Customer     string     `auditor:"dynamodb_partition"`
+	Timestamp    *time.Time `auditor:"sort"`
+	Event        string
+	Email        string            `auditor:"redactable"`
+	Salts        map[string]string `auditor:"salts"`
+	Hash         string            `auditor:"hash"`
+	PreviousHash string            `auditor:"previoushash"`
+}
+
+func TestDynamoDBVerifyHash(t *testing.T) {
+	s, err := New()
+	assert.Nil(t, err)
+	defer s.Close()
+
+	// DynamoDB returns times in UTC
+	time1 := time.Now().Add(time.Minute)
+	block := &testBlock{Customer: "verify", Timestamp: &time1, Category: "restapi", Event: "local time with nanoseconds"}
+	err = s.Save(block)
+	assert.Nil(t, err)
+
+	all := []testBlock{}
+	err = s.Read(&all, 1, &testBlock{Customer: "verify"})
+	assert.Nil(t, err)
+	assert.Equal(t, block.Hash, all[0].Hash)
+	assert.Nil(t, model.VerifyHash(&all[0]))
+}
+
+func TestDynamoDBKeys(t *testing.T) {
+	s, err := NewWithName(keysTable)
+	assert.Nil(t, err)
+	defer s.Close()
+	keys := s.(store.KeyStore)
+
+	_, err = keys.GetKey("abc")
+	assert.Equal(t, store.ErrKeyNotFound, err)
+	key, err := keys.CreateKey("abc", []byte("first"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("first"), key)
+	// key created first wins
+	key, err = keys.CreateKey("abc", []byte("second"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("first"), key)
+	key, err = keys.GetKey("abc")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("first"), key)
+
+	assert.Nil(t, keys.DestroyKey("abc"))
+	_, err = keys.GetKey("abc")
+	assert.Equal(t, store.ErrKeyDestroyed, err)
+	_, err = keys.CreateKey("abc", []byte("third"))
+	assert.Equal(t, store.ErrKeyDestroyed, err)
+	// tombstone is saved for unknown keys too
+	assert.Nil(t, keys.DestroyKey("def"))
+	_, err = keys.GetKey("def")
+	assert.Equal(t, store.ErrKeyDestroyed, err)
+}
+
+func TestDynamoDBRedact(t *testing.T) {
+	s, err := New()
+	assert.Nil(t, err)
+	defer s.Close()
+
+	time1 := time.Now().Truncate(time.Nanosecond)
+	block := &redactableBlock{Customer: "redact", Timestamp: &time1, Event: "signed up", Email: "john@example.com"}
+	err = s.Save(block)
+	assert.Nil(t, err)
+
+	redacted := &redactableBlock{Customer: "redact", Hash: block.Hash}
+	err = s.(store.Redactor).Redact(redacted, []string{"Email"})
+	assert.Nil(t, err)
+	assert.True(t, model.IsRedacted(redacted.Email))
+	assert.Equal(t, "signed up", redacted.Event)
+
+	all := []redactableBlock{}
+	err = s.Read(&all, 1, &redactableBlock{Customer: "redact"})
+	assert.Nil(t, err)
+	assert.Equal(t, redacted.Email, all[0].Email)
+	hash, err := model.ComputeHash(&all[0])
+	assert.Nil(t, err)
+	assert.Equal(t, block.Hash, hash)
+
+	err = s.(store.Redactor).Redact(&redactableBlock{Customer: "redact", Hash: "unknown"}, []string{"Email"})
+	assert.Equal(t, store.ErrBlockNotFound, err)
+}
+
+type idempotentBlock struct {
+	Customer       string     `auditor:"dynamodb_partition"`
+	Timestamp      *time.Time `auditor:"sort"`
+	Event          string
+	IdempotencyKey string `auditor:"idempotencykey"`
+	Fingerprint    string `auditor:"fingerprint"`
+	Hash           string `auditor:"hash"`
+	PreviousHash   string `auditor:"previoushash"`
+}
+
+func TestDynamoDBIdempotencyKey(t *testing.T) {
+	s, err := New()
+	assert.Nil(t, err)
+	defer s.Close()
+
+	time1 := time.Now().Truncate(time.Nanosecond)
+	block := &idempotentBlock{Customer: "idempotency", Timestamp: &time1, Event: "login", IdempotencyKey: "key-1", Fingerprint: "abc"}
+	assert.Nil(t, s.Save(block))
+
+	time2 := time1.Add(time.Second)
+	duplicate := &idempotentBlock{Customer: "idempotency", Timestamp: &time2, Event: "login", IdempotencyKey: "key-1", Fingerprint: "abc"}
+	assert.Equal(t, store.ErrDuplicateKey, s.Save(duplicate))
+
+	// keys are unique per partition
+	other := &idempotentBlock{Customer: "idempotency-other", Timestamp: &time2, Event: "login", IdempotencyKey: "key-1"}
+	assert.Nil(t, s.Save(other))
+
+	found := &idempotentBlock{Customer: "idempotency", IdempotencyKey: "key-1"}
+	assert.Nil(t, s.(store.Deduplicator).FindByIdempotencyKey(found))
+	assert.Equal(t, block.Hash, found.Hash)
+	assert.Equal(t, "abc", found.Fingerprint)
+
+	// marker items are not read as blocks
+	all := []idempotentBlock{}
+	assert.Nil(t, s.Read(&all, 10, &idempotentBlock{Customer: "idempotency"}))
+	assert.Len(t, all, 1)
+
+	err = s.(store.Deduplicator).FindByIdempotencyKey(&idempotentBlock{Customer: "idempotency", IdempotencyKey: "unknown"})
+	assert.Equal(t, store.ErrBlockNotFound, err)
+}
+
+func TestPartitionRule(t *testing.T) {
+	assert.Empty(t, partitionRule(reflect.TypeOf(testBlock{})))
+	// partition must be exported string
+	s := struct {
+		customer int `auditor:"dynamodb_partition"`
+	}{}
+	errors := partitionRule(reflect.TypeOf(s))
+	assert.Len(t, errors, 2)
+	// partition is required
+	assert.Len(t, partitionRule(reflect.TypeOf(struct{}{})), 1)
+}
+
+type sequenceBlock struct {
+	Customer     string `auditor:"dynamodb_partition"`
+	Sequence     int64  `auditor:"sort"`
+	Event        string
+	Hash         string `auditor:"hash"`
+	PreviousHash string `auditor:"previoushash"`
+}
+
+func TestDynamoDBSequence(t *testing.T) {
+	store, err := NewWithName("sequence")
+	assert.Nil(t, err)
+	defer store.Close()
+
+	assert.Nil(t, store.Save(&sequenceBlock{Customer: "abc", Sequence: 1, Event: "first"}))
+	assert.Nil(t, store.Save(&sequenceBlock{Customer: "abc", Sequence: 2, Event: "second"}))
+
+	page1 := []sequenceBlock{}
+	err = store.Read(&page1, 1, &sequenceBlock{Customer: "abc"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), page1[0].Sequence)
+
+	page2 := []sequenceBlock{}
+	err = store.Read(&page2, 1, &page1[0])
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), page2[0].Sequence)
+	assert.Equal(t, page2[0].Hash, page1[0].PreviousHash)
+}
+
+type renamedBlock struct {
+	Tenant       string     `auditor:"dynamodb_partition" dynamodbav:"Customer"`
+	Time         *time.Time `auditor:"sort" json:"Timestamp"`
+	Event        string
+	Hash         string `auditor:"hash" dynamodbav:"hash"`
+	PreviousHash string `auditor:"previoushash"`
+}
+
+func TestDynamoDBAttributeNames(t *testing.T) {
+	store, err := New()
+	assert.Nil(t, err)
+	defer store.Close()
+
+	time1 := time.Now().Truncate(time.Nanosecond)
+	time2 := time1.Add(1 * time.Second).Truncate(time.Nanosecond)
+	assert.Nil(t, store.Save(&renamedBlock{Tenant: "renamed", Time: &time1, Event: "first"}))
+	assert.Nil(t, store.Save(&renamedBlock{Tenant: "renamed", Time: &time2, Event: "second"}))
+
+	page1 := []renamedBlock{}
+	assert.Nil(t, store.Read(&page1, 1, &renamedBlock{Tenant: "renamed"}))
+	assert.Equal(t, "second", page1[0].Event)
+
+	page2 := []renamedBlock{}
+	assert.Nil(t, store.Read(&page2, 1, &page1[0]))
+	assert.Equal(t, "first", page2[0].Event)
+	assert.Equal(t, "renamed", page2[0].Tenant)
+}
+
+type payloadBlock struct {
+	Customer     string                 `auditor:"dynamodb_partition"`
+	Timestamp    *time.Time             `auditor:"sort"`
+	Details      json.RawMessage        `auditor:"payload"`
+	Metadata     map[string]interface{} `auditor:"payload"`
+	Hash         string                 `auditor:"hash"`
+	PreviousHash string                 `auditor:"previoushash"`
+}
+
+func TestDynamoDBPayload(t *testing.T) {
+	s, err := New()
+	assert.Nil(t, err)
+	defer s.Close()
+
+	now := time.Now().Truncate(time.Nanosecond)
+	block := &payloadBlock{
+		Customer:  "payload",
+		Timestamp: &now,
+		Details:   json.RawMessage(`{"changed": {"Email": {"old": "a@example.com", "new": "b@example.com"}}, "fields": 1, "id": 9007199254740993, "empty": {}}`),
+		Metadata:  map[string]interface{}{"ip": "127.0.0.1", "tags": []interface{}{"a", "b"}, "retries": float64(2), "id": int64(9007199254740993), "empty": map[string]interface{}{}},
+	}
+	assert.Nil(t, s.Save(block))
+
+	// payloads are stored as maps
+	av, err := marshalBlock(block)
+	assert.Nil(t, err)
+	assert.NotNil(t, av["Details"].M)
+
+	page := []payloadBlock{}
+	assert.Nil(t, s.Read(&page, 1, &payloadBlock{Customer: "payload"}))
+	assert.JSONEq(t, string(block.Details), string(page[0].Details))
+	assert.Equal(t, "127.0.0.1", page[0].Metadata["ip"])
+	// integers above 2^53 and empty objects are kept as they are
+	assert.Equal(t, "9007199254740993", fmt.Sprint(page[0].Metadata["id"]))
+	assert.Len(t, page[0].Metadata["empty"], 0)
+	assert.NotNil(t, page[0].Metadata["empty"])
+
+	// hash of block read from store verifies
+	read := page[0]
+	read.Hash = ""
+	hash, err := model.ComputeHash(&read)
+	assert.Nil(t, err)
+	assert.Equal(t, block.Hash, hash)
+}
@@ -2,6 +2,7 @@ package mongodb
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net"
@@ -20,30 +21,63 @@ import (
 	"github.com/lukaszbudnik/auditor/store"
 )
 
+func init() {
+	model.RegisterRules("mongodb", indexRule)
+}
+
+// indexRule checks fields used for indexes and queries, unexported fields and fields tagged with bson:"-" are not stored by mgo
+func indexRule(t reflect.Type) []error {
+	errors := model.Exported(t, "mongodb_index")
+	for _, tag := range []string{"mongodb_index", "hash", "sort", "idempotencykey", "fingerprint"} {
+		errors = append(errors, model.Stored(t, tag, model.BSONName)...)
+	}
+	return errors
+}
+
 type mongoDB struct {
-	session *mgo.Session
-	redis   *redis.Client
-	lock    *sync.Mutex
-	lock1   *lock.Locker
+	session         *mgo.Session
+	redis           *redis.Client
+	lock            *sync.Mutex
+	lock1           *lock.Locker
+	collection      string
+	previousHashKey string
 }
 
 func (m *mongoDB) Save(block interface{}) error {
+	return m.SaveSequenced(block, nil)
+}
+
+// SaveSequenced looks up head block by previous hash, when sequence is nil it is not called
+func (m *mongoDB) SaveSequenced(block interface{}, sequence func(block, head interface{})) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	collection := m.session.DB("audit").C("audit")
+	collection := m.session.DB("audit").C(m.collection)
+	schema := model.SchemaFor(block)
 
-	indexFields := model.GetFieldsTaggedWith(block, "mongodb_index")
-	for _, field := range indexFields {
-		name := field.Name
+	for _, field := range schema.Indexes() {
 		index := mgo.Index{
-			Key:        []string{name},
+			Key:        []string{model.BSONName(field)},
 			Background: true,
 		}
 		if err := collection.EnsureIndex(index); err != nil {
 			return err
 		}
 	}
+	if schema.HasIdempotencyKey() {
+		// unique partial index guarantees idempotency, blocks saved without key are not indexed,
+		// keys are unique per partition the same way they are in DynamoDB
+		name := model.BSONName(schema.IdempotencyKeyField())
+		index := mgo.Index{
+			Key:           idempotencyIndex(schema),
+			Unique:        true,
+			PartialFilter: bson.M{name: bson.M{"$gt": ""}},
+			Background:    true,
+		}
+		if err := collection.EnsureIndex(index); err != nil {
+			return err
+		}
+	}
 
 	_, err := m.lock1.Lock()
 	if err != nil {
@@ -52,20 +86,17 @@ func (m *mongoDB) Save(block interface{}) error {
 	}
 	defer m.lock1.Unlock()
 
-	previousHash, err := m.redis.Get("auditor.previoushash").Result()
+	previousHash, err := m.redis.Get(m.previousHashKey).Result()
 	if err != nil && err != redis.Nil {
 		log.Printf("ERROR Could not get previoushash key from Redis: %v", err.Error())
 		return err
 	}
 
 	if len(previousHash) > 0 {
-		previousHashField := model.GetFieldsTaggedWith(block, "previoushash")
-		model.SetFieldValue(block, previousHashField[0], previousHash)
+		schema.SetPreviousHash(block, previousHash)
 	} else {
-		// get type
-		t := reflect.ValueOf(block).Elem().Type()
 		// create *[]type
-		ts := reflect.SliceOf(t)
+		ts := reflect.SliceOf(schema.Type)
 		ptr := reflect.New(ts)
 		ptr.Elem().Set(reflect.MakeSlice(ts, 0, 1))
 
@@ -74,16 +105,34 @@ func (m *mongoDB) Save(block interface{}) error {
 			model.SetPreviousHash(block, ptr.Elem().Index(0).Addr().Interface())
 		}
 	}
+	if sequence != nil {
+		var head interface{}
+		if previousHash := schema.PreviousHash(block); len(previousHash) > 0 {
+			head = reflect.New(schema.Type).Interface()
+			schema.SetHash(head, previousHash)
+			if err := m.FindByHash(head); err != nil {
+				return err
+			}
+		}
+		sequence(block, head)
+	}
 	currentHash, err := model.ComputeAndSetHash(block)
 	if err != nil {
 		return err
 	}
 
-	if err := collection.Insert(block); err != nil {
+	doc, err := document(block)
+	if err != nil {
+		return err
+	}
+	if err := collection.Insert(doc); err != nil {
+		if mgo.IsDup(err) && len(schema.IdempotencyKey(block)) > 0 {
+			return store.ErrDuplicateKey
+		}
 		return err
 	}
 
-	m.redis.Set("auditor.previoushash", currentHash, time.Second)
+	m.redis.Set(m.previousHashKey, currentHash, time.Second)
 
 	return nil
 }
@@ -104,8 +153,8 @@ func (m *mongoDB) Read(result interface{}, limit int64, last interface{}) error
 
 	query := bson.M{}
 
-	sortFields := model.GetTypeFieldsTaggedWith(slicev.Type().Elem(), "sort")
-	sortField := sortFields[0]
+	schema := model.SchemaOf(slicev.Type().Elem())
+	sortField := schema.SortField()
 
 	lastv := reflect.ValueOf(last)
 	if last != nil && !lastv.IsNil() {
@@ -120,25 +169,223 @@ func (m *mongoDB) Read(result interface{}, limit int64, last interface{}) error
 			panic("result and last arguments must be of the same type")
 		}
 
-		timestamp := lastv.Elem().FieldByName(sortField.Name).Interface()
-		timestampv := reflect.ValueOf(timestamp)
-		if !timestampv.IsNil() {
-			query = bson.M{strings.ToLower(sortField.Name): bson.M{"$lt": timestamp}}
+		if schema.HasSort(last) {
+			query = bson.M{model.BSONName(sortField): bson.M{"$lt": schema.Sort(last)}}
+		}
+	}
+
+	collection := m.session.DB("audit").C(m.collection)
+	q := collection.Find(query).Sort(fmt.Sprintf("-%v", model.BSONName(sortField))).Limit(int(limit))
+	if len(schema.RawPayloadFields()) == 0 {
+		if err := q.All(result); err != nil {
+			return err
+		}
+		return model.UpcastAll(result)
+	}
+
+	docs := []bson.M{}
+	if err := q.All(&docs); err != nil {
+		return err
+	}
+	slicev = reflect.MakeSlice(slicev.Type(), 0, len(docs))
+	for _, doc := range docs {
+		block := reflect.New(schema.Type)
+		if err := fromDocument(doc, block.Interface()); err != nil {
+			return err
 		}
+		slicev = reflect.Append(slicev, block.Elem())
+	}
+	resultv.Elem().Set(slicev)
+	return model.UpcastAll(result)
+}
+
+func (m *mongoDB) Redact(block interface{}, fields []string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	schema := model.SchemaFor(block)
+	hash := schema.Hash(block)
+	selector := bson.M{model.BSONName(schema.HashField()): hash}
+
+	collection := m.session.DB("audit").C(m.collection)
+	doc := bson.M{}
+	err := collection.Find(selector).One(&doc)
+	if err == mgo.ErrNotFound {
+		return store.ErrBlockNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := fromDocument(doc, block); err != nil {
+		return err
 	}
 
-	collection := m.session.DB("audit").C("audit")
-	return collection.Find(query).Sort(fmt.Sprintf("-%v", strings.ToLower(sortField.Name))).Limit(int(limit)).All(result)
+	if err := model.Redact(block, fields); err != nil {
+		return err
+	}
+	// redaction must not change hash, if it does stored block was tampered with
+	if err := model.VerifyHash(block); err != nil {
+		return err
+	}
+
+	redacted, err := document(block)
+	if err != nil {
+		return err
+	}
+	return collection.Update(selector, redacted)
+}
+
+func (m *mongoDB) FindByHash(block interface{}) error {
+	schema := model.SchemaFor(block)
+	return m.findOne(bson.M{model.BSONName(schema.HashField()): schema.Hash(block)}, block)
+}
+
+func (m *mongoDB) FindByIdempotencyKey(block interface{}) error {
+	schema := model.SchemaFor(block)
+	selector := bson.M{model.BSONName(schema.IdempotencyKeyField()): schema.IdempotencyKey(block)}
+	if schema.HasPartition() {
+		selector[model.BSONName(schema.PartitionField())] = schema.Partition(block)
+	}
+	return m.findOne(selector, block)
+}
+
+// idempotencyIndex returns fields of unique index of idempotency keys, when block type has partition field
+// keys are scoped to partitions
+func idempotencyIndex(schema *model.BlockSchema) []string {
+	key := model.BSONName(schema.IdempotencyKeyField())
+	if schema.HasPartition() {
+		return []string{model.BSONName(schema.PartitionField()), key}
+	}
+	return []string{key}
+}
+
+// findOne populates block with document matching selector
+func (m *mongoDB) findOne(selector bson.M, block interface{}) error {
+	collection := m.session.DB("audit").C(m.collection)
+	doc := bson.M{}
+	err := collection.Find(selector).One(&doc)
+	if err == mgo.ErrNotFound {
+		return store.ErrBlockNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := fromDocument(doc, block); err != nil {
+		return err
+	}
+	return model.Upcast(block)
+}
+
+// key is a document of collection which holds encryption keys, destroyed key is kept as a tombstone without key
+type key struct {
+	ID        string `bson:"_id"`
+	Key       []byte `bson:"key,omitempty"`
+	Destroyed bool   `bson:"destroyed,omitempty"`
+}
+
+func (m *mongoDB) CreateKey(id string, value []byte) ([]byte, error) {
+	collection := m.session.DB("audit").C(m.collection)
+	err := collection.Insert(&key{ID: id, Key: value})
+	if mgo.IsDup(err) {
+		// key was created concurrently or destroyed
+		return m.GetKey(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (m *mongoDB) GetKey(id string) ([]byte, error) {
+	collection := m.session.DB("audit").C(m.collection)
+	doc := &key{}
+	err := collection.FindId(id).One(doc)
+	if err == mgo.ErrNotFound {
+		return nil, store.ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if doc.Destroyed {
+		return nil, store.ErrKeyDestroyed
+	}
+	return doc.Key, nil
+}
+
+func (m *mongoDB) DestroyKey(id string) error {
+	collection := m.session.DB("audit").C(m.collection)
+	// document is replaced so that key is removed
+	_, err := collection.UpsertId(id, &key{ID: id, Destroyed: true})
+	return err
+}
+
+// document returns value inserted into MongoDB, for blocks with json.RawMessage payload fields
+// it is a document in which payloads are stored as subdocuments instead of binary data
+func document(block interface{}) (interface{}, error) {
+	fields := model.SchemaFor(block).RawPayloadFields()
+	if len(fields) == 0 {
+		return block, nil
+	}
+	raw, err := bson.Marshal(block)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		payload, err := model.DecodePayload(model.GetFieldValue(block, field).(json.RawMessage))
+		if err != nil {
+			return nil, fmt.Errorf("field %v is not valid JSON: %v", field.Name, err.Error())
+		}
+		doc[model.BSONName(field)] = payload
+	}
+	return doc, nil
+}
+
+// fromDocument populates block from document read from MongoDB, payload subdocuments are encoded back to JSON
+func fromDocument(doc bson.M, block interface{}) error {
+	payloads := make(map[string]json.RawMessage)
+	for _, field := range model.SchemaFor(block).RawPayloadFields() {
+		name := model.BSONName(field)
+		payload, err := model.EncodePayload(doc[name])
+		if err != nil {
+			return err
+		}
+		payloads[field.Name] = payload
+		delete(doc, name)
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err := bson.Unmarshal(raw, block); err != nil {
+		return err
+	}
+	for name, payload := range payloads {
+		field, _ := reflect.TypeOf(block).Elem().FieldByName(name)
+		model.SetFieldValue(block, field, payload)
+	}
+	return nil
 }
 
 func (m *mongoDB) Close() {
 	if m.session != nil {
 		m.session.Close()
 	}
+	if m.redis != nil {
+		m.redis.Close()
+	}
 }
 
-// New creates Store implementation for MongoDB
+// New creates Store implementation for MongoDB which uses default audit collection
 func New() (store.Store, error) {
+	return NewWithName(store.DefaultName)
+}
+
+// NewWithName creates Store implementation for MongoDB which uses collection with a given name
+func NewWithName(name string) (store.Store, error) {
 	session, err := newSession()
 	if err != nil {
 		return nil, err
@@ -156,12 +403,12 @@ func New() (store.Store, error) {
 		Addr:    redisEndpoint,
 	})
 
-	lock1 := lock.New(redis, "auditor.lock1", &lock.Options{
+	lock1 := lock.New(redis, store.RedisKey(name, "lock1"), &lock.Options{
 		RetryCount:  10,
 		TokenPrefix: token,
 	})
 
-	var mongoDB store.Store = &mongoDB{session: session, redis: redis, lock: &sync.Mutex{}, lock1: lock1}
+	var mongoDB store.Store = &mongoDB{session: session, redis: redis, lock: &sync.Mutex{}, lock1: lock1, collection: name, previousHashKey: store.RedisKey(name, "previoushash")}
 	return mongoDB, nil
 }
 
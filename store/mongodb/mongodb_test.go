@@ -1,13 +1,18 @@
 package mongodb
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/globalsign/mgo/bson"
 	"github.com/joho/godotenv"
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -69,6 +74,91 @@ func TestMongoDB(t *testing.T) {
 	assert.True(t, len(indexes) >= 3)
 }
 
+func TestMongoDBVerifyHash(t *testing.T) {
+	s, err := New()
+	assert.Nil(t, err)
+	defer s.Close()
+
+	// MongoDB keeps milliseconds and returns times in UTC
+	time1 := time.Now().Add(2 * time.Minute)
+	block := &testBlock{Timestamp: &time1, Category: "restapi", Event: "local time with nanoseconds"}
+	err = s.Save(block)
+	assert.Nil(t, err)
+
+	all := []testBlock{}
+	err = s.Read(&all, 1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, block.Hash, all[0].Hash)
+	assert.Nil(t, model.VerifyHash(&all[0]))
+}
+
+func TestMongoDBKeys(t *testing.T) {
+	s, err := NewWithName("subjects")
+	assert.Nil(t, err)
+	defer s.Close()
+	keys := s.(store.KeyStore)
+
+	_, err = keys.GetKey("abc")
+	assert.Equal(t, store.ErrKeyNotFound, err)
+	key, err := keys.CreateKey("abc", []byte("first"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("first"), key)
+	// key created first wins
+	key, err = keys.CreateKey("abc", []byte("second"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("first"), key)
+	key, err = keys.GetKey("abc")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("first"), key)
+
+	assert.Nil(t, keys.DestroyKey("abc"))
+	_, err = keys.GetKey("abc")
+	assert.Equal(t, store.ErrKeyDestroyed, err)
+	_, err = keys.CreateKey("abc", []byte("third"))
+	assert.Equal(t, store.ErrKeyDestroyed, err)
+	// tombstone is saved for unknown keys too
+	assert.Nil(t, keys.DestroyKey("def"))
+	_, err = keys.GetKey("def")
+	assert.Equal(t, store.ErrKeyDestroyed, err)
+}
+
+type redactableBlock struct {
+	Timestamp    *time.Time `auditor:"sort"`
+	Event        string
+	Email        string            `auditor:"redactable"`
+	Salts        map[string]string `auditor:"salts"`
+	Hash         string            `auditor:"hash"`
+	PreviousHash string            `auditor:"previoushash"`
+}
+
+func TestMongoDBRedact(t *testing.T) {
+	s, err := New()
+	assert.Nil(t, err)
+	defer s.Close()
+
+	time1 := time.Now().Add(3 * time.Minute)
+	block := &redactableBlock{Timestamp: &time1, Event: "signed up", Email: "john@example.com"}
+	err = s.Save(block)
+	assert.Nil(t, err)
+
+	redacted := &redactableBlock{Hash: block.Hash}
+	err = s.(store.Redactor).Redact(redacted, []string{"Email"})
+	assert.Nil(t, err)
+	assert.True(t, model.IsRedacted(redacted.Email))
+	assert.Equal(t, "signed up", redacted.Event)
+
+	all := []redactableBlock{}
+	err = s.Read(&all, 1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, redacted.Email, all[0].Email)
+	hash, err := model.ComputeHash(&all[0])
+	assert.Nil(t, err)
+	assert.Equal(t, block.Hash, hash)
+
+	err = s.(store.Redactor).Redact(&redactableBlock{Hash: "unknown"}, []string{"Email"})
+	assert.Equal(t, store.ErrBlockNotFound, err)
+}
+
 func tearDown() error {
 	session, err := newSession()
 	if err != nil {
@@ -92,5 +182,189 @@ func tearDown() error {
 		return err
 	}
 
-	return nil
+	_, err = session.DB("audit").C("subjects").RemoveAll(nil)
+	return err
+}
+
+type idempotentBlock struct {
+	Customer       string     `auditor:"dynamodb_partition"`
+	Timestamp      *time.Time `auditor:"sort"`
+	Event          string
+	IdempotencyKey string `auditor:"idempotencykey"`
+	Fingerprint    string `auditor:"fingerprint"`
+	Hash           string `auditor:"hash"`
+	PreviousHash   string `auditor:"previoushash"`
+}
+
+func TestMongoDBIdempotencyKey(t *testing.T) {
+	s, err := NewWithName("idempotency")
+	assert.Nil(t, err)
+	defer s.Close()
+
+	session, err := newSession()
+	assert.Nil(t, err)
+	session.DB("audit").C("idempotency").DropCollection()
+	defer session.DB("audit").C("idempotency").DropCollection()
+
+	time1 := time.Now().Truncate(time.Millisecond)
+	block := &idempotentBlock{Customer: "abc", Timestamp: &time1, Event: "login", IdempotencyKey: "key-1", Fingerprint: "abc"}
+	assert.Nil(t, s.Save(block))
+
+	time2 := time1.Add(time.Second)
+	duplicate := &idempotentBlock{Customer: "abc", Timestamp: &time2, Event: "login", IdempotencyKey: "key-1", Fingerprint: "abc"}
+	assert.Equal(t, store.ErrDuplicateKey, s.Save(duplicate))
+
+	// keys are unique per partition, the same as in DynamoDB
+	other := &idempotentBlock{Customer: "other", Timestamp: &time2, Event: "login", IdempotencyKey: "key-1", Fingerprint: "def"}
+	assert.Nil(t, s.Save(other))
+
+	// blocks without key are not unique
+	assert.Nil(t, s.Save(&idempotentBlock{Timestamp: &time2, Event: "logout"}))
+	assert.Nil(t, s.Save(&idempotentBlock{Timestamp: &time2, Event: "logout"}))
+
+	found := &idempotentBlock{Customer: "abc", IdempotencyKey: "key-1"}
+	assert.Nil(t, s.(store.Deduplicator).FindByIdempotencyKey(found))
+	assert.Equal(t, block.Hash, found.Hash)
+	assert.Equal(t, "abc", found.Fingerprint)
+	found = &idempotentBlock{Customer: "other", IdempotencyKey: "key-1"}
+	assert.Nil(t, s.(store.Deduplicator).FindByIdempotencyKey(found))
+	assert.Equal(t, other.Hash, found.Hash)
+
+	err = s.(store.Deduplicator).FindByIdempotencyKey(&idempotentBlock{Customer: "abc", IdempotencyKey: "unknown"})
+	assert.Equal(t, store.ErrBlockNotFound, err)
+}
+
+func TestIndexRule(t *testing.T) {
+	assert.Empty(t, indexRule(reflect.TypeOf(testBlock{})))
+	s := struct {
+		category string `auditor:"mongodb_index"`
+	}{}
+	assert.Len(t, indexRule(reflect.TypeOf(s)), 1)
+}
+
+type sequenceBlock struct {
+	ID           string `auditor:"sort"`
+	Event        string
+	Hash         string `auditor:"hash"`
+	PreviousHash string `auditor:"previoushash"`
+}
+
+func TestMongoDBStringSort(t *testing.T) {
+	store, err := NewWithName("sequence")
+	assert.Nil(t, err)
+	defer store.Close()
+
+	session, err := newSession()
+	assert.Nil(t, err)
+	session.DB("audit").C("sequence").DropCollection()
+	defer session.DB("audit").C("sequence").DropCollection()
+
+	// ULIDs
+	assert.Nil(t, store.Save(&sequenceBlock{ID: "01ARZ3NDEKTSV4RRFFQ69G5FAV", Event: "first"}))
+	assert.Nil(t, store.Save(&sequenceBlock{ID: "01ARZ3NDEKTSV4RRFFQ69G5FAW", Event: "second"}))
+
+	page1 := []sequenceBlock{}
+	err = store.Read(&page1, 1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "second", page1[0].Event)
+
+	page2 := []sequenceBlock{}
+	err = store.Read(&page2, 1, &page1[0])
+	assert.Nil(t, err)
+	assert.Equal(t, "first", page2[0].Event)
+	assert.Equal(t, page2[0].Hash, page1[0].PreviousHash)
+}
+
+type renamedBlock struct {
+	Category     string     `auditor:"mongodb_index" bson:"cat"`
+	Timestamp    *time.Time `auditor:"sort" bson:"ts"`
+	Event        string
+	Hash         string            `auditor:"hash" bson:"h"`
+	PreviousHash string            `auditor:"previoushash"`
+	Email        string            `auditor:"redactable"`
+	Salts        map[string]string `auditor:"salts"`
+}
+
+func TestMongoDBBSONNames(t *testing.T) {
+	s, err := NewWithName("renamed")
+	assert.Nil(t, err)
+	defer s.Close()
+
+	session, err := newSession()
+	assert.Nil(t, err)
+	session.DB("audit").C("renamed").DropCollection()
+	defer session.DB("audit").C("renamed").DropCollection()
+
+	time1 := time.Now().Truncate(time.Millisecond)
+	time2 := time1.Add(1 * time.Second).Truncate(time.Millisecond)
+	assert.Nil(t, s.Save(&renamedBlock{Timestamp: &time1, Category: "restapi", Event: "first", Email: "john@example.com"}))
+	assert.Nil(t, s.Save(&renamedBlock{Timestamp: &time2, Category: "restapi", Event: "second"}))
+
+	page1 := []renamedBlock{}
+	assert.Nil(t, s.Read(&page1, 1, nil))
+	assert.Equal(t, "second", page1[0].Event)
+
+	page2 := []renamedBlock{}
+	assert.Nil(t, s.Read(&page2, 1, &page1[0]))
+	assert.Equal(t, "first", page2[0].Event)
+
+	redacted := &renamedBlock{Hash: page2[0].Hash}
+	assert.Nil(t, s.(store.Redactor).Redact(redacted, []string{"Email"}))
+	assert.True(t, model.IsRedacted(redacted.Email))
+
+	indexes, err := session.DB("audit").C("renamed").Indexes()
+	assert.Nil(t, err)
+	keys := []string{}
+	for _, index := range indexes {
+		keys = append(keys, index.Key...)
+	}
+	assert.Contains(t, keys, "cat")
+}
+
+type payloadBlock struct {
+	Timestamp    *time.Time             `auditor:"sort"`
+	Details      json.RawMessage        `auditor:"payload"`
+	Metadata     map[string]interface{} `auditor:"payload"`
+	Hash         string                 `auditor:"hash"`
+	PreviousHash string                 `auditor:"previoushash"`
+}
+
+func TestMongoDBPayload(t *testing.T) {
+	s, err := NewWithName("payload")
+	assert.Nil(t, err)
+	defer s.Close()
+
+	session, err := newSession()
+	assert.Nil(t, err)
+	session.DB("audit").C("payload").DropCollection()
+	defer session.DB("audit").C("payload").DropCollection()
+
+	now := time.Now().Truncate(time.Millisecond)
+	block := &payloadBlock{
+		Timestamp: &now,
+		Details:   json.RawMessage(`{"changed": {"Email": {"old": "a@example.com", "new": "b@example.com"}}, "fields": 1, "id": 9007199254740993, "empty": {}}`),
+		Metadata:  map[string]interface{}{"ip": "127.0.0.1", "tags": []interface{}{"a", "b"}, "retries": float64(2), "id": int64(9007199254740993), "empty": map[string]interface{}{}},
+	}
+	assert.Nil(t, s.Save(block))
+
+	// payloads are stored as subdocuments
+	doc := bson.M{}
+	assert.Nil(t, session.DB("audit").C("payload").Find(nil).One(&doc))
+	assert.Equal(t, "b@example.com", doc["details"].(bson.M)["changed"].(bson.M)["Email"].(bson.M)["new"])
+
+	page := []payloadBlock{}
+	assert.Nil(t, s.Read(&page, 1, nil))
+	assert.JSONEq(t, string(block.Details), string(page[0].Details))
+	assert.Equal(t, "127.0.0.1", page[0].Metadata["ip"])
+	// integers above 2^53 and empty objects are kept as they are
+	assert.Equal(t, "9007199254740993", fmt.Sprint(page[0].Metadata["id"]))
+	assert.Len(t, page[0].Metadata["empty"], 0)
+	assert.NotNil(t, page[0].Metadata["empty"])
+
+	// hash of block read from store verifies
+	read := page[0]
+	read.Hash = ""
+	hash, err := model.ComputeHash(&read)
+	assert.Nil(t, err)
+	assert.Equal(t, block.Hash, hash)
 }
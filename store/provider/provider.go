@@ -11,12 +11,18 @@ import (
 
 // NewStore creates new Store implementation based on AUDITOR_STORE or returns error
 func NewStore() (store.Store, error) {
+	return NewStoreWithName(store.DefaultName)
+}
+
+// NewStoreWithName creates new Store implementation based on AUDITOR_STORE which uses
+// collection (MongoDB) or table (DynamoDB) with a given name or returns error
+func NewStoreWithName(name string) (store.Store, error) {
 	storeName := os.Getenv("AUDITOR_STORE")
 	switch storeName {
 	case "mongodb":
-		return mongodb.New()
+		return mongodb.NewWithName(name)
 	case "dynamodb":
-		return dynamodb.New()
+		return dynamodb.NewWithName(name)
 	default:
 		return nil, fmt.Errorf("Unknown store: %v", storeName)
 	}
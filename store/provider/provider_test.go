@@ -33,6 +33,17 @@ func TestNewMongoDB(t *testing.T) {
 	assert.Equal(t, "*mongodb.mongoDB", reflect.TypeOf(store).String())
 }
 
+func TestNewMongoDBWithName(t *testing.T) {
+	err := godotenv.Load("../../.env.test.mongodb")
+	assert.Nil(t, err)
+	os.Setenv("AUDITOR_STORE", "mongodb")
+
+	store, err := NewStoreWithName("checkpoint")
+	assert.Nil(t, err)
+
+	assert.Equal(t, "*mongodb.mongoDB", reflect.TypeOf(store).String())
+}
+
 func TestUnknownStore(t *testing.T) {
 	os.Setenv("AUDITOR_STORE", "X")
 
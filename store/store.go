@@ -1,8 +1,99 @@
 package store
 
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// DefaultName is a name of default audit collection (MongoDB) or table (DynamoDB)
+	DefaultName = "audit"
+)
+
+// ErrBlockNotFound is returned when block with a given hash does not exist
+var ErrBlockNotFound = errors.New("block not found")
+
+// ErrRedactionNotSupported is returned when store does not implement Redactor
+var ErrRedactionNotSupported = errors.New("redaction is not supported")
+
+// ErrFindNotSupported is returned when store does not implement Finder
+var ErrFindNotSupported = errors.New("finding blocks by hash is not supported")
+
+// ErrDuplicateKey is returned by Save when block with the same idempotency key was already saved
+var ErrDuplicateKey = errors.New("duplicate idempotency key")
+
+// ErrIdempotencyNotSupported is returned when store does not implement Deduplicator
+var ErrIdempotencyNotSupported = errors.New("idempotency keys are not supported")
+
+// ErrKeyNotFound is returned when there is no key with a given id
+var ErrKeyNotFound = errors.New("key not found")
+
+// ErrKeyDestroyed is returned when key with a given id was destroyed
+var ErrKeyDestroyed = errors.New("key was destroyed")
+
 // Store represents store operations for audit database
 type Store interface {
 	Save(block interface{}) error
 	Read(result interface{}, limit int64, last interface{}) error
 	Close()
 }
+
+// Finder is implemented by stores which can find blocks by hash
+type Finder interface {
+	// FindByHash finds block by its hash field and populates block with it, returns ErrBlockNotFound when there is no such block
+	// (DynamoDB finds blocks in all partitions, dynamodb_partition field is needed only for blocks saved before hash markers were introduced)
+	FindByHash(block interface{}) error
+}
+
+// Walker is implemented by stores which hold blocks of one chain in many partitions, previoushash links blocks of different
+// partitions so the chain can only be followed by hash from its head
+type Walker interface {
+	Finder
+	// Head populates block with the most recent block of the chain, returns ErrBlockNotFound when the chain is empty
+	Head(block interface{}) error
+}
+
+// Redactor is implemented by stores which can redact fields of already saved blocks
+type Redactor interface {
+	Finder
+	// Redact finds block by its hash field the way FindByHash does,
+	// redacts given fields and overwrites it, block is populated with the redacted block
+	Redact(block interface{}, fields []string) error
+}
+
+// Sequencer is implemented by stores which can assign sort value of a block after the chain is locked,
+// such sort values follow the order of the chain even when blocks are saved concurrently by many auditor instances
+type Sequencer interface {
+	// SaveSequenced calls sequence with block and the most recent block of the chain (nil when the chain is empty)
+	// after the chain is locked and before hash of block is computed, then saves block the same way Save does
+	SaveSequenced(block interface{}, sequence func(block, head interface{})) error
+}
+
+// Deduplicator is implemented by stores which guarantee that idempotency keys are unique,
+// their Save returns ErrDuplicateKey when block with the same idempotency key was already saved
+type Deduplicator interface {
+	// FindByIdempotencyKey finds block by its idempotencykey field (for DynamoDB dynamodb_partition field must be set too)
+	// and populates block with it, returns ErrBlockNotFound when there is no such block
+	FindByIdempotencyKey(block interface{}) error
+}
+
+// KeyStore is implemented by stores which can keep encryption keys shared by all auditor instances, destroyed keys
+// are replaced by tombstones so that destroyed key can be told apart from key which never existed
+type KeyStore interface {
+	// CreateKey saves key with a given id unless there already is one, returns key saved with that id
+	// (key saved concurrently by other instance wins) or ErrKeyDestroyed when key with that id was destroyed
+	CreateKey(id string, key []byte) ([]byte, error)
+	// GetKey returns key with a given id, returns ErrKeyNotFound when there is no such key or ErrKeyDestroyed when it was destroyed
+	GetKey(id string) ([]byte, error)
+	// DestroyKey replaces key with a given id by a tombstone, tombstone is saved even when there is no such key
+	DestroyKey(id string) error
+}
+
+// RedisKey returns Redis key used by store with a given name,
+// for backward compatibility default store uses auditor.<key> keys
+func RedisKey(name, key string) string {
+	if name == DefaultName {
+		return fmt.Sprintf("auditor.%v", key)
+	}
+	return fmt.Sprintf("auditor.%v.%v", name, key)
+}
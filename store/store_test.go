@@ -0,0 +1,15 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisKeyDefault(t *testing.T) {
+	assert.Equal(t, "auditor.previoushash", RedisKey(DefaultName, "previoushash"))
+}
+
+func TestRedisKey(t *testing.T) {
+	assert.Equal(t, "auditor.checkpoint.lock1", RedisKey("checkpoint", "lock1"))
+}
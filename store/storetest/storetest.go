@@ -0,0 +1,173 @@
+// Package storetest provides in-memory store used in tests of packages which follow audit chain
+package storetest
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store"
+)
+
+// Store is a simple in-memory store, Read returns blocks sorted by sort field in descending order
+type Store struct {
+	Blocks []reflect.Value
+}
+
+// NewAudit creates Store with count model.Block blocks
+func NewAudit(count int) *Store {
+	audit := &Store{}
+	audit.Append(count)
+	return audit
+}
+
+// Append saves count model.Block blocks with timestamps one second apart
+func (s *Store) Append(count int) {
+	start := time.Now().Add(time.Duration(len(s.Blocks)) * time.Second)
+	for i := 0; i < count; i++ {
+		timestamp := start.Add(time.Duration(i) * time.Second)
+		s.Save(&model.Block{Timestamp: &timestamp, Event: "event"})
+	}
+}
+
+// HashAt returns hash of i-th saved model.Block
+func (s *Store) HashAt(i int) string {
+	return s.Blocks[i].Interface().(*model.Block).Hash
+}
+
+// Save links block to the last saved block, computes its hash and saves its copy
+func (s *Store) Save(block interface{}) error {
+	if len(s.Blocks) > 0 {
+		model.SetPreviousHash(block, s.Blocks[len(s.Blocks)-1].Interface())
+	}
+	model.ComputeAndSetHash(block)
+	clone := reflect.New(reflect.TypeOf(block).Elem())
+	clone.Elem().Set(reflect.ValueOf(block).Elem())
+	s.Blocks = append(s.Blocks, clone)
+	return nil
+}
+
+func sortValue(block reflect.Value) time.Time {
+	field := model.GetTypeFieldsTaggedWith(block.Type().Elem(), "sort")[0]
+	t := block.Elem().FieldByName(field.Name).Interface().(*time.Time)
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+// Read returns blocks older than last
+func (s *Store) Read(result interface{}, limit int64, last interface{}) error {
+	blocks := append([]reflect.Value{}, s.Blocks...)
+	sort.Slice(blocks, func(i, j int) bool {
+		return sortValue(blocks[i]).After(sortValue(blocks[j]))
+	})
+	slicev := reflect.ValueOf(result).Elem()
+	for _, b := range blocks {
+		if int64(slicev.Len()) == limit {
+			break
+		}
+		if last != nil {
+			lastSort := sortValue(reflect.ValueOf(last))
+			if !lastSort.IsZero() && !sortValue(b).Before(lastSort) {
+				continue
+			}
+		}
+		slicev = reflect.Append(slicev, b.Elem())
+	}
+	reflect.ValueOf(result).Elem().Set(slicev)
+	return nil
+}
+
+// Close does nothing
+func (s *Store) Close() {
+}
+
+// PartitionedStore reads only blocks of the partition of last block, the way DynamoDB does,
+// while blocks of all partitions are linked in one chain and can be found by hash
+type PartitionedStore struct {
+	Store
+}
+
+// Read returns blocks of the partition of last block older than last
+func (s *PartitionedStore) Read(result interface{}, limit int64, last interface{}) error {
+	schema := model.SchemaFor(last)
+	partition := &Store{}
+	for _, block := range s.Blocks {
+		if schema.Partition(block.Interface()) == schema.Partition(last) {
+			partition.Blocks = append(partition.Blocks, block)
+		}
+	}
+	return partition.Read(result, limit, last)
+}
+
+// Head returns the most recently saved block
+func (s *PartitionedStore) Head(block interface{}) error {
+	if len(s.Blocks) == 0 {
+		return store.ErrBlockNotFound
+	}
+	reflect.ValueOf(block).Elem().Set(s.Blocks[len(s.Blocks)-1].Elem())
+	return nil
+}
+
+// FindByHash finds block by hash in all partitions
+func (s *PartitionedStore) FindByHash(block interface{}) error {
+	schema := model.SchemaFor(block)
+	for _, b := range s.Blocks {
+		if schema.Hash(b.Interface()) == schema.Hash(block) {
+			reflect.ValueOf(block).Elem().Set(b.Elem())
+			return nil
+		}
+	}
+	return store.ErrBlockNotFound
+}
+
+// Keys is a simple in-memory store.KeyStore, destroyed keys are kept as nil values
+type Keys struct {
+	lock sync.Mutex
+	keys map[string][]byte
+}
+
+// CreateKey saves key unless there already is one with a given id
+func (k *Keys) CreateKey(id string, key []byte) ([]byte, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.keys == nil {
+		k.keys = make(map[string][]byte)
+	}
+	if existing, ok := k.keys[id]; ok {
+		if existing == nil {
+			return nil, store.ErrKeyDestroyed
+		}
+		return existing, nil
+	}
+	k.keys[id] = key
+	return key, nil
+}
+
+// GetKey returns key with a given id
+func (k *Keys) GetKey(id string) ([]byte, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+	if key == nil {
+		return nil, store.ErrKeyDestroyed
+	}
+	return key, nil
+}
+
+// DestroyKey replaces key with a tombstone
+func (k *Keys) DestroyKey(id string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.keys == nil {
+		k.keys = make(map[string][]byte)
+	}
+	k.keys[id] = nil
+	return nil
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/lukaszbudnik/auditor/model"
+)
+
+// TypedStore is a compile-time safe wrapper around Store for blocks of type T
+type TypedStore[T any] struct {
+	store Store
+}
+
+// NewTypedStore creates TypedStore, returns error if T is not a valid auditor block
+func NewTypedStore[T any](store Store) (*TypedStore[T], error) {
+	if store == nil {
+		return nil, fmt.Errorf("store must not be nil")
+	}
+	if err := model.ValidateBlockType(new(T)); err != nil {
+		return nil, err
+	}
+	return &TypedStore[T]{store: store}, nil
+}
+
+// Save computes hash of block, links it with previous block and saves it
+func (s *TypedStore[T]) Save(block *T) error {
+	if block == nil {
+		return fmt.Errorf("block must not be nil")
+	}
+	return s.store.Save(block)
+}
+
+// Read reads up to limit blocks older than cursor, newest first, cursor is usually the last block of previous page,
+// when cursor is nil the newest blocks are returned (DynamoDB requires cursor with dynamodb_partition field set)
+func (s *TypedStore[T]) Read(limit int64, cursor *T) ([]T, error) {
+	result := []T{}
+	var last interface{}
+	if cursor != nil {
+		last = cursor
+	}
+	if err := s.store.Read(&result, limit, last); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Close closes underlying store
+func (s *TypedStore[T]) Close() {
+	s.store.Close()
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type testBlock struct {
+	Timestamp    *time.Time `auditor:"sort"`
+	Event        string
+	Hash         string `auditor:"hash"`
+	PreviousHash string `auditor:"previoushash"`
+}
+
+type invalidBlock struct {
+	Event string
+}
+
+type mockStore struct {
+	blocks []testBlock
+	last   interface{}
+}
+
+func (ms *mockStore) Save(block interface{}) error {
+	if len(ms.blocks) > 0 {
+		model.SetPreviousHash(block, &ms.blocks[len(ms.blocks)-1])
+	}
+	model.ComputeAndSetHash(block)
+	ms.blocks = append(ms.blocks, *block.(*testBlock))
+	return nil
+}
+
+func (ms *mockStore) Read(result interface{}, limit int64, last interface{}) error {
+	ms.last = last
+	blocks := result.(*[]testBlock)
+	for i := len(ms.blocks) - 1; i >= 0 && int64(len(*blocks)) < limit; i-- {
+		*blocks = append(*blocks, ms.blocks[i])
+	}
+	return nil
+}
+
+func (ms *mockStore) Close() {
+}
+
+func TestTypedStore(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	ms := &mockStore{}
+	typed, err := NewTypedStore[testBlock](ms)
+	assert.Nil(t, err)
+	defer typed.Close()
+
+	now := time.Now()
+	assert.Nil(t, typed.Save(&testBlock{Timestamp: &now, Event: "first"}))
+	assert.Nil(t, typed.Save(&testBlock{Timestamp: &now, Event: "second"}))
+
+	blocks, err := typed.Read(1, nil)
+	assert.Nil(t, err)
+	assert.Len(t, blocks, 1)
+	assert.Equal(t, "second", blocks[0].Event)
+	assert.Equal(t, ms.blocks[0].Hash, blocks[0].PreviousHash)
+	// nil cursor is passed to store as nil interface
+	assert.Nil(t, ms.last)
+
+	_, err = typed.Read(1, &blocks[0])
+	assert.Nil(t, err)
+	assert.Equal(t, &blocks[0], ms.last)
+
+	assert.Equal(t, "block must not be nil", typed.Save(nil).Error())
+}
+
+func TestNewTypedStoreErrors(t *testing.T) {
+	os.Setenv("AUDITOR_STORE", "")
+	_, err := NewTypedStore[invalidBlock](&mockStore{})
+	assert.Equal(t, "block type invalidBlock is invalid: block type must have one field tagged with 'hash', found: 0; block type must have one field tagged with 'previoushash', found: 0; block type must have one field tagged with 'sort', found: 0", err.Error())
+
+	_, err = NewTypedStore[string](&mockStore{})
+	assert.Equal(t, "block argument must be a pointer to struct, but got: string", err.Error())
+
+	_, err = NewTypedStore[testBlock](nil)
+	assert.Equal(t, "store must not be nil", err.Error())
+}
@@ -0,0 +1,245 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// now is replaced in tests
+var now = time.Now
+
+// Property holds rules applied to a single field, it is a subset of JSON Schema keywords,
+// MaxSkew (for example 5m) is an auditor extension for time fields: value must be within MaxSkew of server time
+type Property struct {
+	Description string        `json:"description"`
+	Enum        []interface{} `json:"enum"`
+	Const       interface{}   `json:"const"`
+	Pattern     string        `json:"pattern"`
+	MinLength   *int          `json:"minLength"`
+	MaxLength   *int          `json:"maxLength"`
+	Minimum     *float64      `json:"minimum"`
+	Maximum     *float64      `json:"maximum"`
+	MaxSkew     string        `json:"maxSkew"`
+	pattern     *regexp.Regexp
+	maxSkew     time.Duration
+}
+
+// Rules is a subset of JSON Schema: required fields, field properties, and conditional rules
+// (for example if Category is login then Subcategory is required)
+type Rules struct {
+	Schema      string               `json:"$schema"`
+	Title       string               `json:"title"`
+	Description string               `json:"description"`
+	Required    []string             `json:"required"`
+	Properties  map[string]*Property `json:"properties"`
+	AllOf       []*Rules             `json:"allOf"`
+	If          *Rules               `json:"if"`
+	Then        *Rules               `json:"then"`
+	Else        *Rules               `json:"else"`
+}
+
+// Violation describes a single violated rule
+type Violation struct {
+	Field   string
+	Rule    string
+	Message string
+}
+
+// Enabled returns true when AUDITOR_VALIDATION is set
+func Enabled() bool {
+	return len(os.Getenv("AUDITOR_VALIDATION")) > 0
+}
+
+// Load loads rules from JSON file which maps block type names (audit for the default chain) to rules
+func Load(path string) (map[string]*Rules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// misspelled keywords would silently disable rules
+	decoder.DisallowUnknownFields()
+	rules := make(map[string]*Rules)
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, err
+	}
+	for name, r := range rules {
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("rules of %v: %v", name, err.Error())
+		}
+	}
+	return rules, nil
+}
+
+func (r *Rules) compile() error {
+	if r == nil {
+		return nil
+	}
+	for name, property := range r.Properties {
+		var err error
+		if len(property.Pattern) > 0 {
+			if property.pattern, err = regexp.Compile(property.Pattern); err != nil {
+				return fmt.Errorf("invalid pattern of %v: %v", name, err.Error())
+			}
+		}
+		if len(property.MaxSkew) > 0 {
+			if property.maxSkew, err = time.ParseDuration(property.MaxSkew); err != nil {
+				return fmt.Errorf("invalid maxSkew of %v: %v", name, err.Error())
+			}
+		}
+	}
+	for _, nested := range append(r.AllOf, r.If, r.Then, r.Else) {
+		if err := nested.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check returns error if rules refer to fields which block type does not have
+func (r *Rules) Check(blockType reflect.Type) error {
+	if r == nil {
+		return nil
+	}
+	names := append([]string{}, r.Required...)
+	for name := range r.Properties {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if !hasField(blockType, name) {
+			return fmt.Errorf("block type has no field %v", name)
+		}
+	}
+	for _, nested := range append(r.AllOf, r.If, r.Then, r.Else) {
+		if err := nested.Check(blockType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasField checks fields by their JSON names as rules are applied to JSON representation of block
+func hasField(blockType reflect.Type, name string) bool {
+	for i := 0; i < blockType.NumField(); i++ {
+		field := blockType.Field(i)
+		jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+		if jsonName == name || len(jsonName) == 0 && field.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate validates block and returns all violations sorted by field,
+// zero values (empty strings, nulls) are treated as missing
+func (r *Rules) Validate(block interface{}) ([]Violation, error) {
+	data, err := json.Marshal(block)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	violations := r.validate(values)
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Field < violations[j].Field })
+	return violations, nil
+}
+
+func (r *Rules) validate(values map[string]interface{}) []Violation {
+	violations := []Violation{}
+	if r == nil {
+		return violations
+	}
+	for _, name := range r.Required {
+		if missing(values[name]) {
+			violations = append(violations, Violation{name, "required", "is required"})
+		}
+	}
+	for name, property := range r.Properties {
+		if value := values[name]; !missing(value) {
+			violations = append(violations, property.validate(name, value)...)
+		}
+	}
+	for _, nested := range r.AllOf {
+		violations = append(violations, nested.validate(values)...)
+	}
+	if r.If != nil {
+		if len(r.If.validate(values)) == 0 {
+			violations = append(violations, r.Then.validate(values)...)
+		} else {
+			violations = append(violations, r.Else.validate(values)...)
+		}
+	}
+	return violations
+}
+
+func missing(value interface{}) bool {
+	return value == nil || value == ""
+}
+
+func (p *Property) validate(name string, value interface{}) []Violation {
+	violations := []Violation{}
+	add := func(rule, format string, args ...interface{}) {
+		violations = append(violations, Violation{name, rule, fmt.Sprintf(format, args...)})
+	}
+	if len(p.Enum) > 0 && !contains(p.Enum, value) {
+		add("enum", "must be one of: %v", join(p.Enum))
+	}
+	if p.Const != nil && !reflect.DeepEqual(p.Const, value) {
+		add("const", "must be %v", p.Const)
+	}
+	if s, ok := value.(string); ok {
+		length := utf8.RuneCountInString(s)
+		if p.pattern != nil && !p.pattern.MatchString(s) {
+			add("pattern", "must match pattern %v", p.Pattern)
+		}
+		if p.MinLength != nil && length < *p.MinLength {
+			add("minLength", "must be at least %v characters long", *p.MinLength)
+		}
+		if p.MaxLength != nil && length > *p.MaxLength {
+			add("maxLength", "must be at most %v characters long", *p.MaxLength)
+		}
+		if p.maxSkew > 0 {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil || t.Sub(now()) > p.maxSkew || now().Sub(t) > p.maxSkew {
+				add("maxSkew", "must be within %v of server time", p.maxSkew)
+			}
+		}
+	}
+	if f, ok := value.(float64); ok {
+		if p.Minimum != nil && f < *p.Minimum {
+			add("minimum", "must be greater than or equal to %v", *p.Minimum)
+		}
+		if p.Maximum != nil && f > *p.Maximum {
+			add("maximum", "must be less than or equal to %v", *p.Maximum)
+		}
+	}
+	return violations
+}
+
+func contains(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func join(values []interface{}) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprintf("%v", v)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,137 @@
+package validation
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRules = `{
+  "audit": {
+    "$schema": "http://json-schema.org/draft-07/schema#",
+    "required": ["Customer"],
+    "properties": {
+      "Category": {"enum": ["login", "logout", "payment"]},
+      "Customer": {"pattern": "^c-[0-9]+$"},
+      "Event": {"maxLength": 10},
+      "Timestamp": {"maxSkew": "5m"}
+    },
+    "if": {"properties": {"Category": {"const": "login"}}},
+    "then": {"required": ["Subcategory"]}
+  }
+}`
+
+func writeRules(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "rules")
+	assert.Nil(t, err)
+	file.WriteString(content)
+	file.Close()
+	return file.Name()
+}
+
+func loadRules(t *testing.T, content string) *Rules {
+	path := writeRules(t, content)
+	defer os.Remove(path)
+	rules, err := Load(path)
+	assert.Nil(t, err)
+	return rules["audit"]
+}
+
+func TestValidate(t *testing.T) {
+	fixed := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return fixed }
+	defer func() { now = time.Now }()
+	rules := loadRules(t, testRules)
+
+	timestamp := fixed.Add(-time.Minute)
+	violations, err := rules.Validate(&model.Block{Customer: "c-123", Timestamp: &timestamp, Category: "login", Subcategory: "password", Event: "logged in"})
+	assert.Nil(t, err)
+	assert.Empty(t, violations)
+
+	timestamp = fixed.Add(-time.Hour)
+	violations, err = rules.Validate(&model.Block{Customer: "abc", Timestamp: &timestamp, Category: "login", Event: "user logged in"})
+	assert.Nil(t, err)
+	assert.Equal(t, []Violation{
+		{"Customer", "pattern", "must match pattern ^c-[0-9]+$"},
+		{"Event", "maxLength", "must be at most 10 characters long"},
+		{"Subcategory", "required", "is required"},
+		{"Timestamp", "maxSkew", "must be within 5m0s of server time"},
+	}, violations)
+
+	violations, err = rules.Validate(&model.Block{Category: "signup"})
+	assert.Nil(t, err)
+	assert.Equal(t, []Violation{
+		{"Category", "enum", "must be one of: login, logout, payment"},
+		{"Customer", "required", "is required"},
+	}, violations)
+}
+
+func TestValidateElseAndNumbers(t *testing.T) {
+	type Payment struct {
+		Category string
+		Amount   float64
+		Currency string
+	}
+	rules := loadRules(t, `{"audit": {
+	  "allOf": [{"properties": {"Amount": {"minimum": 1, "maximum": 100}}}],
+	  "if": {"properties": {"Category": {"const": "refund"}}},
+	  "then": {"properties": {"Currency": {"minLength": 3}}},
+	  "else": {"required": ["Currency"]}
+	}}`)
+
+	violations, err := rules.Validate(&Payment{Category: "charge", Amount: 150})
+	assert.Nil(t, err)
+	assert.Equal(t, []Violation{
+		{"Amount", "maximum", "must be less than or equal to 100"},
+		{"Currency", "required", "is required"},
+	}, violations)
+
+	violations, err = rules.Validate(&Payment{Category: "refund", Amount: 0.5, Currency: "€"})
+	assert.Nil(t, err)
+	assert.Equal(t, []Violation{
+		{"Amount", "minimum", "must be greater than or equal to 1"},
+		{"Currency", "minLength", "must be at least 3 characters long"},
+	}, violations)
+}
+
+func TestLoadErrors(t *testing.T) {
+	for content, expected := range map[string]string{
+		`{"audit": {"required": ["Customer"], "propertis": {}}}`:            `json: unknown field "propertis"`,
+		`{"audit": {"properties": {"Customer": {"pattern": "[a-"}}}}`:       "rules of audit: invalid pattern of Customer: error parsing regexp: missing closing ]: `[a-`",
+		`{"audit": {"then": {"properties": {"Event": {"maxSkew": "5x"}}}}}`: `rules of audit: invalid maxSkew of Event: time: unknown unit "x" in duration "5x"`,
+	} {
+		path := writeRules(t, content)
+		_, err := Load(path)
+		os.Remove(path)
+		assert.NotNil(t, err)
+		if err != nil {
+			assert.Equal(t, expected, err.Error())
+		}
+	}
+
+	_, err := Load("/non/existing/rules.json")
+	assert.NotNil(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	rules := loadRules(t, testRules)
+	assert.Nil(t, rules.Check(reflect.TypeOf(model.Block{})))
+
+	rules = loadRules(t, `{"audit": {"if": {"required": ["Category"]}, "then": {"required": ["Severity"]}}}`)
+	err := rules.Check(reflect.TypeOf(model.Block{}))
+	assert.NotNil(t, err)
+	assert.Equal(t, "block type has no field Severity", err.Error())
+}
+
+func TestEnabled(t *testing.T) {
+	os.Setenv("AUDITOR_VALIDATION", "")
+	assert.False(t, Enabled())
+	os.Setenv("AUDITOR_VALIDATION", "rules.json")
+	defer os.Setenv("AUDITOR_VALIDATION", "")
+	assert.True(t, Enabled())
+}
@@ -0,0 +1,117 @@
+package witness
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// GitFile is a name of file in git repository to which statements are appended
+	GitFile     = "heads.jsonl"
+	httpTimeout = 10 * time.Second
+)
+
+// Sink publishes signed statements to an external witness
+type Sink interface {
+	Publish(statement *Statement) error
+}
+
+// NewSinks creates sinks from comma separated list of:
+// file:/path/to/file, git:/path/to/repository, http://host/path or https://host/path
+func NewSinks(config string) ([]Sink, error) {
+	sinks := []Sink{}
+	for _, s := range strings.Split(config, ",") {
+		s = strings.TrimSpace(s)
+		switch {
+		case strings.HasPrefix(s, "file:"):
+			sinks = append(sinks, &FileSink{Path: strings.TrimPrefix(s, "file:")})
+		case strings.HasPrefix(s, "git:"):
+			sinks = append(sinks, &GitSink{Dir: strings.TrimPrefix(s, "git:")})
+		case strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://"):
+			sinks = append(sinks, &HTTPSink{URL: s, Client: &http.Client{Timeout: httpTimeout}})
+		default:
+			return nil, fmt.Errorf("unknown witness sink: %v", s)
+		}
+	}
+	return sinks, nil
+}
+
+// FileSink appends statements as JSON lines to a local file
+type FileSink struct {
+	Path string
+}
+
+// Publish appends statement to file
+func (s *FileSink) Publish(statement *Statement) error {
+	return appendStatement(s.Path, statement)
+}
+
+func appendStatement(path string, statement *Statement) error {
+	line, err := json.Marshal(statement)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// GitSink appends statements to heads.jsonl file in a local git repository and commits it
+type GitSink struct {
+	Dir string
+}
+
+// Publish appends statement to heads.jsonl and commits it
+func (s *GitSink) Publish(statement *Statement) error {
+	if err := appendStatement(filepath.Join(s.Dir, GitFile), statement); err != nil {
+		return err
+	}
+	if err := s.git("add", GitFile); err != nil {
+		return err
+	}
+	return s.git("commit", "-m", fmt.Sprintf("auditor head %v at height %v", statement.Hash, statement.Height))
+}
+
+func (s *GitSink) git(args ...string) error {
+	cmd := exec.Command("git", append([]string{"-C", s.Dir}, args...)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %v failed: %v: %v", args[0], err.Error(), strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// HTTPSink POSTs statements as JSON to a remote endpoint
+type HTTPSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// Publish POSTs statement to URL, any non 2xx response is an error
+func (s *HTTPSink) Publish(statement *Statement) error {
+	body, err := json.Marshal(statement)
+	if err != nil {
+		return err
+	}
+	response, err := s.Client.Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("witness %v returned %v", s.URL, response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package witness
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memorySink struct {
+	statements []*Statement
+}
+
+func (s *memorySink) Publish(statement *Statement) error {
+	s.statements = append(s.statements, statement)
+	return nil
+}
+
+func TestNewSinks(t *testing.T) {
+	sinks, err := NewSinks("file:/tmp/heads.jsonl, git:/tmp/witness,https://witness.example.com/heads")
+	assert.Nil(t, err)
+	assert.Len(t, sinks, 3)
+	assert.Equal(t, "/tmp/heads.jsonl", sinks[0].(*FileSink).Path)
+	assert.Equal(t, "/tmp/witness", sinks[1].(*GitSink).Dir)
+	assert.Equal(t, "https://witness.example.com/heads", sinks[2].(*HTTPSink).URL)
+}
+
+func TestNewSinksError(t *testing.T) {
+	_, err := NewSinks("ftp://witness.example.com")
+	assert.Equal(t, "unknown witness sink: ftp://witness.example.com", err.Error())
+}
+
+func TestFileSink(t *testing.T) {
+	_, privateKey := newKey(t)
+	dir, err := ioutil.TempDir("", "witness")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "heads.jsonl")
+	sink := &FileSink{Path: path}
+	assert.Nil(t, sink.Publish(Sign(privateKey, "abc", 1, time.Now())))
+	assert.Nil(t, sink.Publish(Sign(privateKey, "def", 2, time.Now())))
+
+	bytes, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(bytes)), "\n")
+	assert.Len(t, lines, 2)
+	statement := &Statement{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), statement))
+	assert.Equal(t, "abc", statement.Hash)
+}
+
+func TestGitSink(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	_, privateKey := newKey(t)
+	dir, err := ioutil.TempDir("", "witness")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	sink := &GitSink{Dir: dir}
+	assert.Nil(t, sink.git("init"))
+	assert.Nil(t, sink.git("config", "user.name", "auditor"))
+	assert.Nil(t, sink.git("config", "user.email", "auditor@example.com"))
+
+	assert.Nil(t, sink.Publish(Sign(privateKey, "abc", 1, time.Now())))
+	assert.Nil(t, sink.Publish(Sign(privateKey, "def", 2, time.Now())))
+
+	output, err := exec.Command("git", "-C", dir, "log", "--format=%s").Output()
+	assert.Nil(t, err)
+	assert.Equal(t, "auditor head def at height 2\nauditor head abc at height 1", strings.TrimSpace(string(output)))
+
+	statement, err := ReadLatest(filepath.Join(dir, GitFile))
+	assert.Nil(t, err)
+	assert.Equal(t, "def", statement.Hash)
+}
+
+func TestGitSinkError(t *testing.T) {
+	_, privateKey := newKey(t)
+	sink := &GitSink{Dir: "/does/not/exist"}
+	assert.NotNil(t, sink.Publish(Sign(privateKey, "abc", 1, time.Now())))
+}
+
+func TestHTTPSink(t *testing.T) {
+	_, privateKey := newKey(t)
+	received := &Statement{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		json.NewDecoder(r.Body).Decode(received)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	sink := &HTTPSink{URL: server.URL, Client: server.Client()}
+	assert.Nil(t, sink.Publish(Sign(privateKey, "abc", 1, time.Now())))
+	assert.Equal(t, "abc", received.Hash)
+}
+
+func TestHTTPSinkError(t *testing.T) {
+	_, privateKey := newKey(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	sink := &HTTPSink{URL: server.URL, Client: server.Client()}
+	err := sink.Publish(Sign(privateKey, "abc", 1, time.Now()))
+	assert.Equal(t, "witness "+server.URL+" returned 503 Service Unavailable", err.Error())
+}
@@ -0,0 +1,265 @@
+package witness
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/chain"
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store"
+)
+
+const defaultInterval = time.Minute
+
+// Statement is a signed statement about the head of the audit chain
+type Statement struct {
+	Hash      string
+	Height    int64
+	Timestamp time.Time
+	Signature string
+}
+
+func (s *Statement) message() []byte {
+	return []byte(fmt.Sprintf("auditor-head\n%v\n%v\n%v", s.Hash, s.Height, s.Timestamp.UTC().Format(time.RFC3339Nano)))
+}
+
+// Sign creates signed statement about chain head
+func Sign(key ed25519.PrivateKey, hash string, height int64, timestamp time.Time) *Statement {
+	statement := &Statement{Hash: hash, Height: height, Timestamp: timestamp.UTC()}
+	statement.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, statement.message()))
+	return statement
+}
+
+// VerifySignature verifies statement signature
+func VerifySignature(key ed25519.PublicKey, statement *Statement) error {
+	signature, err := base64.StdEncoding.DecodeString(statement.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err.Error())
+	}
+	if !ed25519.Verify(key, statement.message(), signature) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// Verify verifies statement signature and checks that the chain still contains published head at published height,
+// it detects chains which were truncated or rewritten after the head was published
+func Verify(key ed25519.PublicKey, statement *Statement, store store.Store, block interface{}) error {
+	if err := VerifySignature(key, statement); err != nil {
+		return err
+	}
+	follower := chain.NewFollower(store, block, "", 0)
+	hashes, err := follower.Next()
+	if err != nil {
+		return err
+	}
+	if int64(len(hashes)) < statement.Height {
+		return fmt.Errorf("chain has %v blocks but head published at %v has height %v, chain was truncated", len(hashes), statement.Timestamp, statement.Height)
+	}
+	if statement.Height < 1 || hashes[statement.Height-1] != statement.Hash {
+		return fmt.Errorf("block at height %v does not match head %v published at %v, chain was rewritten", statement.Height, statement.Hash, statement.Timestamp)
+	}
+	return nil
+}
+
+// ReadLatest reads the latest statement from a file written by file or git sink
+func ReadLatest(path string) (*Statement, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var last string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); len(line) > 0 {
+			last = line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(last) == 0 {
+		return nil, fmt.Errorf("no statements found in %v", path)
+	}
+	statement := &Statement{}
+	if err := json.Unmarshal([]byte(last), statement); err != nil {
+		return nil, err
+	}
+	return statement, nil
+}
+
+// LoadPrivateKey loads Ed25519 private key from PEM encoded PKCS #8 file
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%v is not an Ed25519 private key", path)
+	}
+	return privateKey, nil
+}
+
+// LoadPublicKey loads Ed25519 public key from PEM encoded PKIX file
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%v is not an Ed25519 public key", path)
+	}
+	return publicKey, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %v", path)
+	}
+	return block, nil
+}
+
+// Publisher periodically publishes signed statements about chain head to sinks
+type Publisher struct {
+	follower  *chain.Follower
+	key       ed25519.PrivateKey
+	sinks     []Sink
+	interval  time.Duration
+	published []string
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// Enabled returns true when AUDITOR_WITNESS_SINK is set
+func Enabled() bool {
+	return len(os.Getenv("AUDITOR_WITNESS_SINK")) > 0
+}
+
+// NewPublisherFromEnv creates Publisher configured using AUDITOR_WITNESS_* env variables,
+// block is a pointer to struct of the same type as blocks stored in audit store
+func NewPublisherFromEnv(audit store.Store, block interface{}) (*Publisher, error) {
+	sinks, err := NewSinks(os.Getenv("AUDITOR_WITNESS_SINK"))
+	if err != nil {
+		return nil, err
+	}
+	key, err := LoadPrivateKey(os.Getenv("AUDITOR_WITNESS_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("could not load AUDITOR_WITNESS_KEY: %v", err.Error())
+	}
+	interval := defaultInterval
+	if s := os.Getenv("AUDITOR_WITNESS_INTERVAL"); len(s) > 0 {
+		if interval, err = time.ParseDuration(s); err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid AUDITOR_WITNESS_INTERVAL: %v", s)
+		}
+	}
+	// DynamoDB follows the chain by hash from its head, partition is only used for tables saved before head item was introduced
+	fields := model.GetFieldsTaggedWith(block, "dynamodb_partition")
+	if len(fields) > 0 {
+		model.SetFieldValue(block, fields[0], os.Getenv("AUDITOR_WITNESS_PARTITION"))
+	}
+	return NewPublisher(audit, block, key, sinks, interval), nil
+}
+
+// NewPublisher creates Publisher which every interval publishes signed chain head to sinks
+func NewPublisher(audit store.Store, block interface{}, key ed25519.PrivateKey, sinks []Sink, interval time.Duration) *Publisher {
+	return &Publisher{
+		follower:  chain.NewFollower(audit, block, "", 0),
+		key:       key,
+		sinks:     sinks,
+		interval:  interval,
+		published: make([]string, len(sinks)),
+	}
+}
+
+// Start starts a background goroutine which periodically publishes chain head
+func (p *Publisher) Start() {
+	p.done = make(chan struct{})
+	p.stopped = make(chan struct{})
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+				if err := p.Run(); err != nil {
+					log.Printf("ERROR Could not publish chain head: %v", err.Error())
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops background goroutine started by Start and waits until it exits,
+// so that statement being published is written before the store is closed
+func (p *Publisher) Stop() {
+	if p.done != nil {
+		close(p.done)
+		<-p.stopped
+		p.done = nil
+	}
+}
+
+// Run reads new blocks and when chain head changed publishes signed statement to sinks,
+// head published to each sink is tracked separately so that only sinks which failed are retried
+func (p *Publisher) Run() error {
+	if _, err := p.follower.Next(); err != nil {
+		return err
+	}
+	hash, height := p.follower.Head()
+	if len(hash) == 0 {
+		return nil
+	}
+	var statement *Statement
+	errs := []string{}
+	for i, sink := range p.sinks {
+		if p.published[i] == hash {
+			continue
+		}
+		if statement == nil {
+			statement = Sign(p.key, hash, height, time.Now())
+		}
+		if err := sink.Publish(statement); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		p.published[i] = hash
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	if statement != nil {
+		log.Printf("INFO auditor published chain head %v at height %v", hash, height)
+	}
+	return nil
+}
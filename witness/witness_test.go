@@ -0,0 +1,200 @@
+package witness
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lukaszbudnik/auditor/model"
+	"github.com/lukaszbudnik/auditor/store/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	return publicKey, privateKey
+}
+
+func TestSignAndVerifySignature(t *testing.T) {
+	publicKey, privateKey := newKey(t)
+	statement := Sign(privateKey, "abc", 3, time.Now())
+	assert.Nil(t, VerifySignature(publicKey, statement))
+
+	statement.Height = 2
+	assert.Equal(t, "invalid signature", VerifySignature(publicKey, statement).Error())
+
+	statement.Signature = "!"
+	assert.NotNil(t, VerifySignature(publicKey, statement))
+}
+
+func TestLoadKeys(t *testing.T) {
+	publicKey, privateKey := newKey(t)
+	dir, err := ioutil.TempDir("", "witness")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	privateBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.Nil(t, err)
+	publicBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	assert.Nil(t, err)
+	privatePath := filepath.Join(dir, "witness.pem")
+	publicPath := filepath.Join(dir, "witness.pub.pem")
+	ioutil.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateBytes}), 0600)
+	ioutil.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicBytes}), 0644)
+
+	loadedPrivateKey, err := LoadPrivateKey(privatePath)
+	assert.Nil(t, err)
+	assert.Equal(t, privateKey, loadedPrivateKey)
+	loadedPublicKey, err := LoadPublicKey(publicPath)
+	assert.Nil(t, err)
+	assert.Equal(t, publicKey, loadedPublicKey)
+
+	_, err = LoadPrivateKey(publicPath)
+	assert.NotNil(t, err)
+	_, err = LoadPublicKey(filepath.Join(dir, "missing.pem"))
+	assert.NotNil(t, err)
+}
+
+func TestPublisher(t *testing.T) {
+	_, privateKey := newKey(t)
+	audit := storetest.NewAudit(3)
+	sink := &memorySink{}
+	publisher := NewPublisher(audit, &model.Block{}, privateKey, []Sink{sink}, time.Minute)
+
+	err := publisher.Run()
+	assert.Nil(t, err)
+	assert.Len(t, sink.statements, 1)
+	assert.Equal(t, audit.HashAt(2), sink.statements[0].Hash)
+	assert.Equal(t, int64(3), sink.statements[0].Height)
+
+	// head did not change, nothing is published
+	err = publisher.Run()
+	assert.Nil(t, err)
+	assert.Len(t, sink.statements, 1)
+
+	audit.Append(1)
+	err = publisher.Run()
+	assert.Nil(t, err)
+	assert.Len(t, sink.statements, 2)
+	assert.Equal(t, int64(4), sink.statements[1].Height)
+}
+
+type failingSink struct {
+	failures int
+	memorySink
+}
+
+func (s *failingSink) Publish(statement *Statement) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink is down")
+	}
+	return s.memorySink.Publish(statement)
+}
+
+func TestPublisherRetriesFailedSinks(t *testing.T) {
+	_, privateKey := newKey(t)
+	audit := storetest.NewAudit(3)
+	healthy := &memorySink{}
+	failing := &failingSink{failures: 1}
+	publisher := NewPublisher(audit, &model.Block{}, privateKey, []Sink{healthy, failing}, time.Minute)
+
+	err := publisher.Run()
+	assert.Equal(t, "sink is down", err.Error())
+	assert.Len(t, healthy.statements, 1)
+	assert.Len(t, failing.statements, 0)
+
+	// only failed sink is retried, healthy one does not get duplicates
+	err = publisher.Run()
+	assert.Nil(t, err)
+	assert.Len(t, healthy.statements, 1)
+	assert.Len(t, failing.statements, 1)
+	assert.Equal(t, audit.HashAt(2), failing.statements[0].Hash)
+
+	err = publisher.Run()
+	assert.Nil(t, err)
+	assert.Len(t, healthy.statements, 1)
+	assert.Len(t, failing.statements, 1)
+}
+
+func TestPublisherEmptyChain(t *testing.T) {
+	_, privateKey := newKey(t)
+	sink := &memorySink{}
+	publisher := NewPublisher(storetest.NewAudit(0), &model.Block{}, privateKey, []Sink{sink}, time.Minute)
+
+	err := publisher.Run()
+	assert.Nil(t, err)
+	assert.Empty(t, sink.statements)
+}
+
+func TestVerify(t *testing.T) {
+	publicKey, privateKey := newKey(t)
+	audit := storetest.NewAudit(5)
+	statement := Sign(privateKey, audit.HashAt(4), 5, time.Now())
+
+	err := Verify(publicKey, statement, audit, &model.Block{})
+	assert.Nil(t, err)
+
+	// chain grew, published head is still there
+	audit.Append(2)
+	err = Verify(publicKey, statement, audit, &model.Block{})
+	assert.Nil(t, err)
+}
+
+func TestVerifyTruncated(t *testing.T) {
+	publicKey, privateKey := newKey(t)
+	audit := storetest.NewAudit(5)
+	statement := Sign(privateKey, audit.HashAt(4), 5, time.Now())
+
+	audit.Blocks = audit.Blocks[:3]
+	err := Verify(publicKey, statement, audit, &model.Block{})
+	assert.Contains(t, err.Error(), "chain has 3 blocks but head published at")
+	assert.Contains(t, err.Error(), "has height 5, chain was truncated")
+}
+
+func TestVerifyRewritten(t *testing.T) {
+	publicKey, privateKey := newKey(t)
+	audit := storetest.NewAudit(5)
+	statement := Sign(privateKey, "abc", 5, time.Now())
+
+	err := Verify(publicKey, statement, audit, &model.Block{})
+	assert.Contains(t, err.Error(), "block at height 5 does not match head abc published at")
+}
+
+func TestVerifyInvalidSignature(t *testing.T) {
+	publicKey, _ := newKey(t)
+	_, otherPrivateKey := newKey(t)
+	audit := storetest.NewAudit(1)
+	statement := Sign(otherPrivateKey, audit.HashAt(0), 1, time.Now())
+
+	err := Verify(publicKey, statement, audit, &model.Block{})
+	assert.Equal(t, "invalid signature", err.Error())
+}
+
+func TestReadLatest(t *testing.T) {
+	_, privateKey := newKey(t)
+	file, err := ioutil.TempFile("", "heads")
+	assert.Nil(t, err)
+	file.Close()
+	defer os.Remove(file.Name())
+
+	_, err = ReadLatest(file.Name())
+	assert.Contains(t, err.Error(), "no statements found in")
+
+	sink := &FileSink{Path: file.Name()}
+	sink.Publish(Sign(privateKey, "abc", 1, time.Now()))
+	sink.Publish(Sign(privateKey, "def", 2, time.Now()))
+
+	statement, err := ReadLatest(file.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, "def", statement.Hash)
+	assert.Equal(t, int64(2), statement.Height)
+}